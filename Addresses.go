@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// addressSelection describes how the MX and policy hosts are connected to.
+// It models a sending MTA instead of the net package, which races IPv4
+// against IPv6 and takes whichever answers first.
+const addressSelection = "MX hosts in order of preference; the addresses of each host in the order of the system's address selection policy (RFC 6724), tried one at a time until one accepts the connection"
+
+// connectTimeout bounds the connection attempt to each address, like the
+// connect timeout of an MTA.
+const connectTimeout = 30 * time.Second
+
+// orderedAddresses resolves host to the addresses a sending MTA would try,
+// in the order it would try them. The resolver already sorts them by the
+// system's RFC 6724 policy, which prefers IPv6 only when the machine has
+// IPv6 connectivity.
+func orderedAddresses(ctx context.Context, host string) ([]string, error) {
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		return []string{ip.String()}, nil
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		addresses = append(addresses, ip.String())
+	}
+	return addresses, nil
+}
+
+// addressErrors collects the failure of every address tried.
+type addressErrors []error
+
+func (e addressErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e addressErrors) Unwrap() []error { return e }
+
+// dialInOrder connects to port on the addresses of host one at a time, in
+// the order orderedAddresses gives. It returns the connection and the
+// addresses in that order; the one connected to is the connection's remote
+// address.
+func dialInOrder(ctx context.Context, network string, host string, port string) (net.Conn, []string, error) {
+	lookupCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+	addresses, err := orderedAddresses(lookupCtx, host)
+	cancel()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: connectTimeout}
+	var errs addressErrors
+	for _, address := range addresses {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(address, port))
+		if err == nil {
+			return conn, addresses, nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 1 {
+		return nil, addresses, errs[0]
+	}
+	return nil, addresses, errs
+}
+
+// Kinds of dial failure, the connect_failure of an MX in JSON. No IPv6 is
+// an MX with only IPv6 addresses failing on a machine without IPv6
+// connectivity, see smtpProbe.
+const (
+	dialRefused     = "refused"
+	dialTimeout     = "timeout"
+	dialUnreachable = "unreachable"
+	dialNoIPv6      = "no-local-ipv6"
+)
+
+// dialFailure classifies the error of dialInOrder: "refused" when a host
+// answered with a reset, so nothing listens on the port; "timeout" when the
+// attempt went unanswered, which a firewall that drops packets causes, very
+// often one on the scanner's own network blocking outbound port 25; and
+// "unreachable" when an ICMP unreachable or a missing route ended it. When
+// the addresses failed differently the most telling failure wins, in that
+// order. It is "" for anything else, like a failed lookup.
+func dialFailure(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return dialRefused
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return dialTimeout
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return dialUnreachable
+	}
+	return ""
+}
+
+// ipv6Probe is an IPv6 address routed to the internet, one of Google
+// Public DNS. Nothing is sent to it.
+const ipv6Probe = "[2001:4860:4860::8888]:53"
+
+// ipv6Assessment is whether this machine has IPv6 connectivity, as far as
+// it can tell without sending anything, and the reason.
+type ipv6Assessment struct {
+	Connected bool   `json:"connected"`
+	Detail    string `json:"detail"`
+}
+
+var (
+	localIPv6Once       sync.Once
+	localIPv6Assessment ipv6Assessment
+)
+
+// localIPv6 assesses the IPv6 connectivity of this machine, once per run.
+// Connecting a UDP socket only picks a route and a source address: without
+// a route there is no IPv6, and a link-local or unique local source
+// address can't reach the internet either, at least not without NAT66.
+func localIPv6() ipv6Assessment {
+	localIPv6Once.Do(func() {
+		conn, err := net.Dial("udp6", ipv6Probe)
+		if err != nil {
+			localIPv6Assessment.Detail = fmt.Sprintf("no IPv6 route: %v", err)
+			return
+		}
+		defer conn.Close()
+		source := conn.LocalAddr().(*net.UDPAddr).IP
+		switch {
+		case !source.IsGlobalUnicast():
+			localIPv6Assessment.Detail = fmt.Sprintf("only the link-local IPv6 address %s", source)
+		case source.IsPrivate():
+			localIPv6Assessment.Detail = fmt.Sprintf("only the unique local IPv6 address %s", source)
+		default:
+			localIPv6Assessment = ipv6Assessment{Connected: true, Detail: fmt.Sprintf("routed from %s", source)}
+		}
+	})
+	return localIPv6Assessment
+}
+
+// ipv6Only reports whether addresses are all IPv6, and not none.
+func ipv6Only(addresses []string) bool {
+	for _, address := range addresses {
+		if ip := net.ParseIP(address); ip == nil || ip.To4() != nil {
+			return false
+		}
+	}
+	return len(addresses) > 0
+}
+
+// remoteIP is the address a connection was made to, without the port.
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// batchReport is the JSON output of a -domains-file run.
+type batchReport struct {
+	Results    []*Result `json:"results"`
+	Omitted    int       `json:"omitted_passing,omitempty"`
+	Unchanged  int       `json:"omitted_unchanged,omitempty"`
+	Verdict    *Verdict  `json:"verdict"`
+	Status     string    `json:"status"`
+	ExitCode   int       `json:"exit_code"`
+	ExitReason string    `json:"exit_reason"`
+
+	// Sample is the estimate for the whole list of a -sample run.
+	Sample *sampleReport `json:"sample,omitempty"`
+}
+
+// fullyPassing reports whether a result has neither errors nor warnings,
+// the domains -only-failures leaves out.
+func fullyPassing(result *Result) bool {
+	return result.Status == statusPass
+}
+
+// readDomains reads one domain per line from path, or from stdin for "-".
+// Blank lines and lines starting with # are skipped.
+func readDomains(path string) ([]string, error) {
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var domains []string
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains = append(domains, line)
+	}
+	return domains, scanner.Err()
+}
+
+// batchMain validates every domain listed in path and returns the aggregate
+// exit code, see batchExitCode. With -resume it continues the run recorded
+// in the state file, whose domains and results replace reading path; the
+// results of the earlier run count in the summary but aren't printed
+// again.
+func batchMain(path string, opts *options) int {
+	var checkpoint *batchCheckpoint
+	if opts.resume != "" {
+		var err error
+		if checkpoint, err = loadCheckpoint(opts.resume, path); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+
+	var domains []string
+	var population int
+	if checkpoint != nil {
+		domains, population = checkpoint.Domains, checkpoint.Population
+		if checkpoint.SampleSeed != 0 {
+			opts.sampleSeed = checkpoint.SampleSeed
+		}
+		fmt.Fprintf(os.Stderr, "Resuming from %s, saved %s: %d of %d domains done\n",
+			opts.resume, checkpoint.Updated.Format("2006-01-02 15:04:05 MST"), len(checkpoint.Results), len(domains))
+	} else {
+		var err error
+		if domains, err = readDomains(path); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		if len(domains) == 0 {
+			fmt.Printf("No domains in %s\n", path)
+			return 1
+		}
+		population = len(domains)
+		if opts.sample != (sampleSpec{}) {
+			domains = sampleDomains(domains, opts.sample.size(population), opts.sampleSeed)
+		}
+		if opts.resume != "" {
+			checkpoint = &batchCheckpoint{DomainsFile: path, Domains: domains, Population: population}
+			if opts.sample != (sampleSpec{}) {
+				checkpoint.SampleSeed = opts.sampleSeed
+			}
+		}
+	}
+
+	var results, shown []*Result
+	if checkpoint != nil {
+		for _, result := range checkpoint.Results {
+			results = append(results, result)
+			if !(opts.onlyFailures && fullyPassing(result)) {
+				shown = append(shown, result)
+			}
+		}
+	}
+	resumed := len(shown)
+	saved := time.Now()
+	unchanged := 0
+	for _, domain := range domains[len(results):] {
+		result := validate(domain, opts)
+		annotate(result, opts)
+		opts.certs.observe(result)
+		results = append(results, result)
+		if checkpoint != nil && time.Since(saved) >= checkpointInterval {
+			checkpoint.Results = results
+			if err := checkpoint.save(opts.resume); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: saving the progress to %s failed: %v\n", opts.resume, err)
+			}
+			saved = time.Now()
+		}
+		if opts.push.gateway != "" {
+			pushMetrics(result, &opts.push)
+		}
+		if opts.cache != nil {
+			// Always record the run, -check-id-change compares with it.
+			if !opts.cache.changed(result) && opts.changedOnly {
+				unchanged++
+				continue
+			}
+		}
+		if opts.onlyFailures && fullyPassing(result) {
+			continue
+		}
+		shown = append(shown, result)
+		if opts.format == "text" {
+			if len(shown) > resumed+1 {
+				fmt.Println()
+				fmt.Println(strings.Repeat("=", 72))
+				fmt.Println()
+			}
+			printResult(result, opts)
+		} else if opts.format == formatGHA {
+			printGHA(result, func() { printResult(result, opts) })
+		}
+	}
+
+	if checkpoint != nil {
+		// The run is complete, the next -resume starts a new one.
+		if err := os.Remove(opts.resume); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Warning: removing %s failed: %v\n", opts.resume, err)
+		}
+	}
+	saveCerts(opts.certs)
+	verdict := decideBatch(results)
+	code, reason := batchExitCode(results, opts)
+	var sample *sampleReport
+	if opts.sample != (sampleSpec{}) {
+		sample = estimateFromSample(results, population, opts.sampleSeed)
+	}
+	if opts.changedOnly && unchanged == len(results) {
+		return unchangedExitCode(code)
+	}
+	if opts.outputDir != "" {
+		if err := writeReports(opts.outputDir, results, opts.format); err != nil {
+			fmt.Printf("Writing reports to %s failed: %v\n", opts.outputDir, err)
+			return 1
+		}
+	} else if opts.format == "json" {
+		writeJSON(batchReport{Results: shown, Omitted: len(results) - len(shown) - unchanged, Unchanged: unchanged, Verdict: verdict, Status: batchStatus(results), ExitCode: code, ExitReason: reason, Sample: sample})
+		return code
+	} else if opts.format == formatSARIF {
+		writeJSON(sarifReport(shown))
+		return code
+	}
+
+	if opts.outputDir != "" {
+		fmt.Printf("Wrote %s to %s\n", plural(len(results), "report"), opts.outputDir)
+	}
+	fmt.Println()
+	fmt.Println("Batch summary:")
+	for _, result := range shown {
+		kind := failureKind(result)
+		if kind == "" {
+			fmt.Printf("\t%-30s %s\n", result.Domain, result.Verdict.Status)
+		} else {
+			fmt.Printf("\t%-30s %s (%s)\n", result.Domain, result.Verdict.Status, kind)
+		}
+	}
+	if omitted := len(results) - len(shown) - unchanged; omitted > 0 {
+		fmt.Printf("\t%s omitted by -only-failures\n", plural(omitted, "passing domain"))
+	}
+	if unchanged > 0 {
+		fmt.Printf("\t%s since the previous run omitted by -changed-only\n", plural(unchanged, "unchanged domain"))
+	}
+	if sample != nil {
+		fmt.Println()
+		printSampleReport(sample)
+	}
+	fmt.Println()
+	fmt.Printf("Exit code %d: %s\n", code, reason)
+	fmt.Println(verdict.line("batch"))
+	return code
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// benchmarkMaxFailures stops benchmarking a host after this many handshakes
+// in a row failed; the host is down or rate limiting us, and hammering it
+// further measures nothing.
+const benchmarkMaxFailures = 5
+
+// benchmarkResult is the handshake latency distribution of one MX, the JSON
+// output of -benchmark.
+type benchmarkResult struct {
+	Host        string  `json:"host"`
+	Port        string  `json:"port"`
+	Attempts    int     `json:"attempts"`
+	Failures    int     `json:"failures"`
+	FailureRate float64 `json:"failure_rate"`
+	MinMillis   float64 `json:"min_ms"`
+	P50Millis   float64 `json:"p50_ms"`
+	P95Millis   float64 `json:"p95_ms"`
+	P99Millis   float64 `json:"p99_ms"`
+	MaxMillis   float64 `json:"max_ms"`
+	StoppedBy   string  `json:"stopped,omitempty"`
+	LastError   string  `json:"last_error,omitempty"`
+}
+
+// benchmarkMain performs count STARTTLS handshakes against each MX of
+// domain, or against the single host:port of -cert-only, one at a time and
+// opts.benchmarkGap apart, and reports the latency distribution per host.
+// No mail is sent. It exits 1 when a host could not be measured at all.
+func benchmarkMain(domain string, target string, count int, opts *options) int {
+	type hostPort struct{ host, port string }
+	var hosts []hostPort
+	if target != "" {
+		host, port, err := net.SplitHostPort(target)
+		if err != nil {
+			host, port = strings.Trim(target, "[]"), "25"
+		}
+		hosts = append(hosts, hostPort{host, port})
+	} else {
+		records, err := mxRecords(domain)
+		if err != nil {
+			fmt.Printf("MX lookup for %s failed: %v\n", domain, err)
+			return 1
+		}
+		for _, record := range records {
+			hosts = append(hosts, hostPort{record, "25"})
+		}
+		if len(hosts) == 0 {
+			fmt.Printf("%s has no MX records\n", domain)
+			return 1
+		}
+	}
+
+	var results []benchmarkResult
+	for _, hp := range hosts {
+		results = append(results, benchmarkHost(hp.host, hp.port, count, opts))
+	}
+
+	if opts.format == "json" {
+		writeJSON(results)
+	} else {
+		printBenchmark(results, count)
+	}
+	for _, result := range results {
+		if result.Failures == result.Attempts {
+			return 1
+		}
+	}
+	return 0
+}
+
+// benchmarkHost runs the handshakes against one host. A failure doubles the
+// pause before the next attempt, so a server that starts refusing us is
+// given room instead of being hammered.
+func benchmarkHost(host string, port string, count int, opts *options) benchmarkResult {
+	result := benchmarkResult{Host: host, Port: port}
+	var latencies []time.Duration
+	gap, failuresInRow := opts.benchmarkGap, 0
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			time.Sleep(gap)
+		}
+		mx := smtpProbe(host, port, 0, probeDebug{})
+		result.Attempts++
+		if !mx.StartTLS {
+			result.Failures++
+			result.LastError = mx.Error
+			failuresInRow++
+			gap *= 2
+			if failuresInRow == benchmarkMaxFailures {
+				result.StoppedBy = fmt.Sprintf("%d failures in a row", failuresInRow)
+				break
+			}
+			continue
+		}
+		latencies = append(latencies, mx.Handshake)
+		gap, failuresInRow = opts.benchmarkGap, 0
+	}
+
+	result.FailureRate = float64(result.Failures) / float64(result.Attempts)
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		result.MinMillis = millis(latencies[0])
+		result.P50Millis = millis(percentile(latencies, 50))
+		result.P95Millis = millis(percentile(latencies, 95))
+		result.P99Millis = millis(percentile(latencies, 99))
+		result.MaxMillis = millis(latencies[len(latencies)-1])
+	}
+	return result
+}
+
+// percentile returns the nearest-rank percentile p of sorted.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// millis converts d to fractional milliseconds; handshakes within a data
+// center take well under one.
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// printBenchmark renders one row per host.
+func printBenchmark(results []benchmarkResult, count int) {
+	fmt.Printf("STARTTLS handshake latency over %s per host\n\n", plural(count, "attempt"))
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "host\tattempts\tfailed\tmin\tp50\tp95\tp99\tmax\t")
+	for _, r := range results {
+		if r.Failures == r.Attempts {
+			fmt.Fprintf(w, "%s\t%d\t%.0f%%\t-\t-\t-\t-\t-\t\n", displayName(r.Host), r.Attempts, 100*r.FailureRate)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%.0f%%\t%.1fms\t%.1fms\t%.1fms\t%.1fms\t%.1fms\t\n", displayName(r.Host), r.Attempts, 100*r.FailureRate,
+			r.MinMillis, r.P50Millis, r.P95Millis, r.P99Millis, r.MaxMillis)
+	}
+	w.Flush()
+	for _, r := range results {
+		if r.StoppedBy != "" {
+			fmt.Printf("\n%s: stopped after %s, last error: %s\n", displayName(r.Host), r.StoppedBy, r.LastError)
+		} else if r.LastError != "" {
+			fmt.Printf("\n%s: last error: %s\n", displayName(r.Host), r.LastError)
+		}
+	}
+}
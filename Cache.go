@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// exitUnchangedFail is the exit code of a -changed-only run that found the
+// same failure as last time. An unchanged pass exits 0.
+const exitUnchangedFail = 4
+
+// runSummary is what -changed-only remembers of a domain between runs: the
+// findings by code, subject and severity and the deployment state, and for
+// -check-id-change the STS id and the policy in canonical form. The policy
+// is the first one seen with the id: senders cache that one until the id
+// changes, so a policy changed under the same id keeps failing until the
+// id is bumped. Messages
+// carry latencies, expiry countdowns and addresses, so they are left out,
+// as is everything else that varies from run to run without anything
+// having changed.
+type runSummary struct {
+	Domain          string           `json:"domain"`
+	DeploymentState string           `json:"deployment_state,omitempty"`
+	Findings        []summaryFinding `json:"findings"`
+	STSID           string           `json:"sts_id,omitempty"`
+	Policy          string           `json:"policy,omitempty"`
+	PolicySince     time.Time        `json:"policy_since,omitzero"`
+	Checked         time.Time        `json:"checked"`
+}
+
+type summaryFinding struct {
+	Code     string   `json:"code"`
+	Subject  string   `json:"subject,omitempty"`
+	Severity Severity `json:"severity"`
+}
+
+func summarize(result *Result) *runSummary {
+	summary := &runSummary{Domain: result.Domain, DeploymentState: result.DeploymentState, Findings: []summaryFinding{}, Checked: time.Now().UTC()}
+	summary.STSID = recordID(result.STSRecord)
+	if result.Policy != "" {
+		summary.Policy, summary.PolicySince = canonicalPolicy(result.Policy), summary.Checked
+	}
+	for _, f := range result.Findings {
+		if !f.Suppressed {
+			summary.Findings = append(summary.Findings, summaryFinding{Code: f.Code, Subject: f.Subject, Severity: f.Severity})
+		}
+	}
+	sort.Slice(summary.Findings, func(i, j int) bool {
+		a, b := summary.Findings[i], summary.Findings[j]
+		if a.Code != b.Code {
+			return a.Code < b.Code
+		}
+		if a.Subject != b.Subject {
+			return a.Subject < b.Subject
+		}
+		return a.Severity < b.Severity
+	})
+	return summary
+}
+
+// same reports whether two summaries describe the same state, ignoring when
+// they were taken.
+func (s *runSummary) same(other *runSummary) bool {
+	if s.DeploymentState != other.DeploymentState || len(s.Findings) != len(other.Findings) {
+		return false
+	}
+	for i := range s.Findings {
+		if s.Findings[i] != other.Findings[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// runCache keeps the summary of the last run of each domain in dir, one
+// file per domain named like the -output-dir reports.
+type runCache struct {
+	dir string
+}
+
+// newRunCache uses dir, or StrictMTATest in the user's cache directory when
+// dir is empty.
+func newRunCache(dir string) (*runCache, error) {
+	if dir == "" {
+		base, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(base, "StrictMTATest")
+	}
+	return &runCache{dir: dir}, nil
+}
+
+func (c *runCache) path(domain string) string {
+	return filepath.Join(c.dir, reportFileName(domain, "json"))
+}
+
+// previous returns the summary of the last run of domain, or nil when
+// there is none. A cache entry that can't be read is a warning on stderr
+// and counts as none.
+func (c *runCache) previous(domain string) *runSummary {
+	path := c.path(domain)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Warning: reading the cache failed: %v\n", err)
+		}
+		return nil
+	}
+	var previous runSummary
+	if err := json.Unmarshal(data, &previous); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring unreadable cache entry %s: %v\n", path, err)
+		return nil
+	}
+	return &previous
+}
+
+// changed compares result with the previous run of its domain and records
+// it as the new previous run, keeping the previous policy while the id
+// stays the same. A domain without a previous run counts as changed, so
+// the first run is always reported. A cache that can't be read or written
+// is a warning on stderr, and the result counts as changed.
+func (c *runCache) changed(result *Result) bool {
+	path := c.path(result.Domain)
+	current := summarize(result)
+	changed := true
+	if previous := c.previous(result.Domain); previous != nil {
+		changed = !current.same(previous)
+		if previous.Policy != "" && current.STSID != "" && previous.STSID == current.STSID {
+			current.Policy, current.PolicySince = previous.Policy, previous.PolicySince
+		}
+	}
+
+	data, err := json.MarshalIndent(current, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(c.dir, 0755)
+	}
+	if err == nil {
+		err = writeFileAtomic(path, append(data, '\n'))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: writing the cache failed: %v\n", err)
+	}
+	return changed
+}
+
+// unchangedExitCode is the exit code of a -changed-only run in which nothing
+// changed, given the code it would otherwise have exited with.
+func unchangedExitCode(code int) int {
+	if code == exitPass {
+		return exitPass
+	}
+	return exitUnchangedFail
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestIDChangeAcrossRuns(t *testing.T) {
+	cache := &runCache{dir: t.TempDir()}
+	original := policyOf("version: STSv1", "mode: testing", "mx: mx.example.com", "max_age: 86400")
+	enforced := policyOf("version: STSv1", "mode: enforce", "mx: mx.example.com", "max_age: 86400")
+	runs := []struct {
+		name   string
+		id     string
+		policy string
+		want   []string
+	}{
+		{"first run", "20260101", original, nil},
+		{"policy changed under the same id", "20260101", enforced, []string{"POLICY-CHANGED-ID-UNCHANGED"}},
+		{"still the same id", "20260101", enforced, []string{"POLICY-CHANGED-ID-UNCHANGED"}},
+		{"id bumped", "20260102", enforced, []string{"STS-ID-CHANGED"}},
+		{"settled", "20260102", enforced, nil},
+		{"reverted under the new id", "20260102", original, []string{"POLICY-CHANGED-ID-UNCHANGED"}},
+		{"new policy restored", "20260102", enforced, nil},
+	}
+	for _, run := range runs {
+		result := &Result{Domain: "example.com", STSRecord: "v=STSv1; id=" + run.id, Policy: run.policy}
+		verifyIDChange(result, cache.previous(result.Domain))
+		var codes []string
+		for _, f := range result.Findings {
+			codes = append(codes, f.Code)
+		}
+		if len(codes) != len(run.want) || (len(codes) > 0 && codes[0] != run.want[0]) {
+			t.Errorf("%s: findings %q, want %q", run.name, codes, run.want)
+		}
+		cache.changed(result)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// canonicalKeys is the order of the known keys in a canonical policy, that
+// of the examples in RFC 8461 §3.2.
+var canonicalKeys = []string{"version", "mode", "mx", "max_age"}
+
+// canonicalPolicy re-serializes a policy in a normalized form: lowercase
+// keys in canonical order with unknown keys sorted after them, one space
+// after the colon, LF line endings and no duplicates. mx values are
+// normalized the way they are matched. Of several values for a single
+// valued key only the first is kept, the one validation uses. Lines
+// without a key are dropped.
+func canonicalPolicy(policy string) string {
+	values := make(map[string][]string)
+	var unknown []string
+	for _, line := range strings.Split(policy, "\n") {
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:colon]))
+		value := strings.TrimSpace(line[colon+1:])
+		if key == "" {
+			continue
+		}
+		if key == "mx" {
+			if normalized := normalizeDomain(value); normalized != "" {
+				value = normalized
+			}
+		}
+		if _, ok := values[key]; !ok && !contains(canonicalKeys, key) {
+			unknown = append(unknown, key)
+		}
+		single := key != "mx" && contains(canonicalKeys, key)
+		if (single && len(values[key]) > 0) || contains(values[key], value) {
+			continue
+		}
+		values[key] = append(values[key], value)
+	}
+	sort.Strings(unknown)
+
+	var buf strings.Builder
+	keys := append(append([]string(nil), canonicalKeys...), unknown...)
+	for _, key := range keys {
+		for _, value := range values[key] {
+			fmt.Fprintf(&buf, "%s: %s\n", key, value)
+		}
+	}
+	return buf.String()
+}
+
+// canonicalizeMain prints the canonical form of the policy of domain, or of
+// the local policy file, or writes it to output.
+func canonicalizeMain(domain string, policyFile string, output string, opts *options) int {
+	var policy string
+	if policyFile != "" {
+		data, err := ioutil.ReadFile(policyFile)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		policy, _ = stripAnnotations(string(data))
+	} else {
+		domain, _ = mailDomainOf(domain)
+		url := "https://mta-sts." + domain + "/.well-known/mta-sts.txt"
+		response, _, err := queryHTTPSRecord(url, opts.retries)
+		if err != nil {
+			fmt.Printf("Fetching %s failed: %v\n", url, err)
+			return 1
+		}
+		policy = response.Body
+	}
+
+	canonical := canonicalPolicy(policy)
+	if output == "" {
+		fmt.Print(canonical)
+		return 0
+	}
+	if err := writeFileAtomic(output, []byte(canonical)); err != nil {
+		fmt.Printf("Writing %s failed: %v\n", output, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// certGroup is a leaf certificate and the MX hosts presenting it.
+type certGroup struct {
+	cert  *CertInfo
+	hosts []string
+}
+
+// groupCerts groups the probed MX hosts by the fingerprint of their leaf
+// certificate, largest group first.
+func groupCerts(mxs []MXResult) []*certGroup {
+	byFingerprint := make(map[string]*certGroup)
+	var groups []*certGroup
+	for _, mx := range mxs {
+		if mx.Cert == nil {
+			continue
+		}
+		group, ok := byFingerprint[mx.Cert.Fingerprint]
+		if !ok {
+			group = &certGroup{cert: mx.Cert}
+			byFingerprint[mx.Cert.Fingerprint] = group
+			groups = append(groups, group)
+		}
+		group.hosts = append(group.hosts, mx.Host)
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return len(groups[i].hosts) > len(groups[j].hosts) })
+	return groups
+}
+
+// checkSharedCerts reports MX hosts that present the same certificate and
+// certificates with wildcard names. A shared certificate expires on every
+// host at once, so its expiry findings are amended with the blast radius.
+func checkSharedCerts(result *Result) {
+	groups := groupCerts(result.MX)
+	for _, group := range groups {
+		cert := group.cert
+		if wildcards := wildcardNames(cert.DNSNames); len(wildcards) > 0 {
+			result.infof("CERT-WILDCARD", strings.Join(group.hosts, ", "), "certificate SHA256:%s has wildcard names %s",
+				shorten(cert.Fingerprint, 16), strings.Join(wildcards, ", "))
+		}
+		if len(group.hosts) < 2 {
+			continue
+		}
+
+		share := fmt.Sprintf("all %d MX hosts", len(group.hosts))
+		if len(group.hosts) < len(result.MX) {
+			share = fmt.Sprintf("%d of %d MX hosts", len(group.hosts), len(result.MX))
+		}
+		result.infof("CERT-SHARED", strings.Join(group.hosts, ", "), "%s share cert SHA256:%s, expires %s",
+			share, shorten(cert.Fingerprint, 16), cert.NotAfter.Format("2006-01-02"))
+
+		shared := make(map[string]bool)
+		for _, host := range group.hosts {
+			shared[host] = true
+		}
+		for i, f := range result.Findings {
+			if (f.Code == "CERT-EXPIRING" || f.Code == "CERT-EXPIRED") && shared[f.Subject] {
+				result.Findings[i].Message += "; the certificate is shared by " + plural(len(group.hosts), "MX host") +
+					", which all fail at the same time"
+			}
+		}
+	}
+}
+
+func wildcardNames(names []string) []string {
+	var wildcards []string
+	for _, name := range names {
+		if strings.HasPrefix(name, "*.") {
+			wildcards = append(wildcards, name)
+		}
+	}
+	return wildcards
+}
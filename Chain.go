@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"strings"
+	"time"
+)
+
+// inspectChainOrder records in info how the presented chain deviates from
+// the leaf-to-root order of RFC 8446 §4.4.2, each certificate signed by the
+// next. Go's verifier and most browsers accept any order and ignore stray
+// certificates, but OpenSSL in strict modes and several MTAs do not, so a
+// chain that verifies here can still fail for some senders.
+//
+// The path is followed from the leaf through the presented certificates.
+// Certificates on neither that path nor any path the system roots verify
+// are extraneous.
+func inspectChainOrder(info *CertInfo, chain []*x509.Certificate) {
+	path := []*x509.Certificate{chain[0]}
+	used := map[int]bool{0: true}
+	for {
+		current := path[len(path)-1]
+		if isSelfSigned(current) {
+			break
+		}
+		next := -1
+		for i, cert := range chain {
+			if !used[i] && bytes.Equal(current.RawIssuer, cert.RawSubject) && current.CheckSignatureFrom(cert) == nil {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			break
+		}
+		used[next] = true
+		path = append(path, chain[next])
+	}
+
+	for i, cert := range path {
+		if !cert.Equal(chain[i]) {
+			info.OutOfOrder = chainSubjects(chain)
+			break
+		}
+	}
+
+	onPath := make(map[string]bool)
+	for _, cert := range path {
+		onPath[string(cert.Raw)] = true
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	opts := x509.VerifyOptions{Intermediates: intermediates, Roots: rootPool}
+	if time.Now().After(chain[0].NotAfter) {
+		opts.CurrentTime = chain[0].NotAfter
+	}
+	verified, _ := chain[0].Verify(opts)
+	for _, candidate := range verified {
+		for _, cert := range candidate {
+			onPath[string(cert.Raw)] = true
+		}
+	}
+	for _, cert := range chain[1:] {
+		if !onPath[string(cert.Raw)] {
+			info.Extraneous = append(info.Extraneous, cert.Subject.String())
+		}
+	}
+
+	for _, cert := range chain[1:] {
+		if isSelfSigned(cert) && onPath[string(cert.Raw)] {
+			info.RootIncluded = cert.Subject.String()
+		}
+	}
+}
+
+// isSelfSigned reports whether cert is a root: issued by itself and signed
+// with its own key.
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
+func chainSubjects(chain []*x509.Certificate) []string {
+	subjects := make([]string, len(chain))
+	for i, cert := range chain {
+		subjects[i] = cert.Subject.String()
+	}
+	return subjects
+}
+
+// addChainFindings reports the chain order problems inspectChainOrder found
+// for the certificate served by subject.
+func addChainFindings(result *Result, subject string, cert *CertInfo) {
+	if len(cert.OutOfOrder) > 0 {
+		result.warnf("CHAIN-OUT-OF-ORDER", subject, "certificates are not in leaf-to-root order, each signed by the next; presented: %s",
+			strings.Join(cert.OutOfOrder, " -> "))
+	}
+	for _, extra := range cert.Extraneous {
+		result.warnf("CHAIN-EXTRANEOUS-CERT", subject, "presented certificate %s is not part of any path to the leaf", extra)
+	}
+	if cert.RootIncluded != "" {
+		result.infof("CHAIN-ROOT-INCLUDED", subject, "the chain includes the root %s, which senders already have; it only adds to the handshake", cert.RootIncluded)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// checkpointInterval is how often -resume saves the progress of a batch
+// run. Each save rewrites the whole file, so saving after every domain
+// would make long lists quadratic; an interrupted run loses at most this
+// much work.
+const checkpointInterval = 10 * time.Second
+
+// batchCheckpoint is the -resume state file of a -domains-file run. It
+// holds the domains in the order they are validated, sampled already when
+// -sample is used, and the results of the first len(Results) of them.
+// SampleSeed is the seed of that sample, reported again with the estimate.
+type batchCheckpoint struct {
+	DomainsFile string    `json:"domains_file"`
+	Domains     []string  `json:"domains"`
+	Population  int       `json:"population"`
+	SampleSeed  int64     `json:"sample_seed,omitempty"`
+	Updated     time.Time `json:"updated"`
+	Results     []*Result `json:"results"`
+}
+
+// loadCheckpoint reads the state file at path, or returns nil when there is
+// none yet. A state file of another domains file is an error rather than
+// being overwritten.
+func loadCheckpoint(path string, domainsFile string) (*batchCheckpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var checkpoint batchCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("%s is not a -resume state file: %v", path, err)
+	}
+	if checkpoint.DomainsFile != domainsFile {
+		return nil, fmt.Errorf("%s is the state of a run of %s, not of %s", path, checkpoint.DomainsFile, domainsFile)
+	}
+	if len(checkpoint.Results) > len(checkpoint.Domains) {
+		return nil, fmt.Errorf("%s has more results than domains", path)
+	}
+	return &checkpoint, nil
+}
+
+// save writes the checkpoint atomically, so an interruption leaves either
+// the previous state or the new one.
+func (c *batchCheckpoint) save(path string) error {
+	c.Updated = time.Now()
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
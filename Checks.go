@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Names of the checks a run can perform, in the order they run.
+const (
+	checkMXLookup      = "mx-lookup"
+	checkSTARTTLS      = "mx-starttls"
+	checkSTSTXT        = "sts-txt"
+	checkNSConsistency = "sts-ns-consistency"
+	checkPolicy        = "policy-fetch"
+	checkDualStack     = "policy-dual-stack"
+	checkRecheck       = "policy-recheck"
+	checkOrigin        = "policy-origin"
+	checkSyntax        = "policy-syntax"
+	checkMXCoverage    = "mx-coverage"
+	checkProfile       = "policy-profile"
+	checkTLSRPT        = "tlsrpt"
+	checkIDChange      = "sts-id-change"
+)
+
+var allChecks = []string{
+	checkMXLookup,
+	checkSTARTTLS,
+	checkSTSTXT,
+	checkNSConsistency,
+	checkPolicy,
+	checkDualStack,
+	checkRecheck,
+	checkOrigin,
+	checkSyntax,
+	checkMXCoverage,
+	checkProfile,
+	checkTLSRPT,
+	checkIDChange,
+}
+
+// Check records whether a check was performed and how it went. Status is
+// one of pass, warn, fail or skipped; skipped checks carry the reason.
+type Check struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+
+	// Findings[first:last] were added while the check ran.
+	first, last int
+}
+
+// beginCheck marks the start of a check. Every finding added until the
+// matching endCheck belongs to it.
+func (r *Result) beginCheck() int {
+	return len(r.Findings)
+}
+
+func (r *Result) endCheck(name string, mark int) {
+	r.Checks = append(r.Checks, Check{Name: name, first: mark, last: len(r.Findings)})
+}
+
+func (r *Result) skipCheck(name string, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, Check{Name: name, Status: "skipped", Reason: fmt.Sprintf(format, args...)})
+}
+
+// merge appends the findings and checks collected in a separate Result,
+// keeping each check pointing at its own findings.
+func (r *Result) merge(phase *Result) {
+	offset := len(r.Findings)
+	r.Findings = append(r.Findings, phase.Findings...)
+	for _, check := range phase.Checks {
+		check.first += offset
+		check.last += offset
+		r.Checks = append(r.Checks, check)
+	}
+}
+
+// finishChecks derives the status of each performed check from the worst
+// unsuppressed finding it produced.
+func finishChecks(result *Result) {
+	for i, check := range result.Checks {
+		if check.Status == "skipped" {
+			continue
+		}
+		status := "pass"
+		for _, f := range result.Findings[check.first:check.last] {
+			if f.Suppressed {
+				continue
+			}
+			if f.Severity == SeverityError {
+				status = "fail"
+				break
+			}
+			if f.Severity == SeverityWarning {
+				status = "warn"
+			}
+		}
+		result.Checks[i].Status = status
+	}
+}
+
+// printChecks lists the checks for verbose text output.
+func printChecks(result *Result) {
+	fmt.Println("Checks:")
+	for _, check := range result.Checks {
+		if check.Reason != "" {
+			fmt.Printf("\t%-14s %s (%s)\n", check.Name, check.Status, check.Reason)
+		} else {
+			fmt.Printf("\t%-14s %s\n", check.Name, check.Status)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// compareMain implements `compare <domainA> <domainB>`. Both domains are
+// validated and rendered side by side with differing rows highlighted. The
+// check flags of the main command apply to both.
+//
+// The exit code follows the batch verdict: it fails when either domain has a
+// genuine failure. Differences only fail it when -require-equal is given.
+func compareMain(args []string) int {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	checks := addCheckFlags(flags)
+	requireEqual := flags.Bool("require-equal", false, "Exit non-zero when the two domains differ")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s compare [-require-equal] [check flags] <domainA> <domainB>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 1
+	}
+	if err := checks.complete(); err != nil {
+		fmt.Fprintf(flags.Output(), "%v\n\n", err)
+		flags.PrintDefaults()
+		return 1
+	}
+
+	opts := checks.opts
+	opts.quiet = true
+	a := validate(flags.Arg(0), opts)
+	b := validate(flags.Arg(1), opts)
+	annotate(a, opts)
+	annotate(b, opts)
+
+	differences := printComparison(a, b)
+
+	for _, result := range []*Result{a, b} {
+		if len(result.Findings) == 0 {
+			continue
+		}
+		fmt.Printf("\nFindings for %s:\n", result.Domain)
+		for _, finding := range result.Findings {
+			fmt.Printf("\t%s\n", finding)
+		}
+	}
+
+	fmt.Printf("\n%d difference(s) found\n", differences)
+
+	batch := decideBatch([]*Result{a, b})
+	if *requireEqual && differences > 0 {
+		batch.Status = verdictFail
+		batch.Summary += fmt.Sprintf("; %d difference(s) with -require-equal", differences)
+	}
+
+	fmt.Println()
+	fmt.Println(a.Verdict.line(a.Domain))
+	fmt.Println(b.Verdict.line(b.Domain))
+	fmt.Println(batch.line("batch"))
+
+	return batch.exitCode()
+}
+
+type comparisonRow struct {
+	label string
+	a     string
+	b     string
+}
+
+// comparisonRows lines up the interesting fields of two results.
+func comparisonRows(a, b *Result) []comparisonRow {
+	rows := []comparisonRow{
+		{"policy mode", a.Mode, b.Mode},
+		{"max_age", a.MaxAge, b.MaxAge},
+		{"mx patterns", sortedJoin(a.PolicyMX), sortedJoin(b.PolicyMX)},
+		{"MX hosts", fmt.Sprintf("%d", len(a.MX)), fmt.Sprintf("%d", len(b.MX))},
+	}
+
+	aMX := sortedMX(a.MX)
+	bMX := sortedMX(b.MX)
+	for i := 0; i < len(aMX) || i < len(bMX); i++ {
+		row := comparisonRow{label: fmt.Sprintf("MX %d", i+1)}
+		if i < len(aMX) {
+			row.a = aMX[i].Host + " (" + aMX[i].Status() + ")"
+		}
+		if i < len(bMX) {
+			row.b = bMX[i].Host + " (" + bMX[i].Status() + ")"
+		}
+		rows = append(rows, row)
+	}
+
+	rows = append(rows, comparisonRow{"TLSRPT", presence(a.TLSRPTRecord), presence(b.TLSRPTRecord)})
+	return rows
+}
+
+// printComparison renders the aligned table and returns the number of
+// differing rows.
+func printComparison(a, b *Result) int {
+	differences := 0
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "\t%s\t%s\t\n", a.Domain, b.Domain)
+	for _, row := range comparisonRows(a, b) {
+		marker := ""
+		if !rowsEqual(row) {
+			differences++
+			marker = "\x1b[31;1m≠\x1b[0m"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", row.label, display(row.a), display(row.b), marker)
+	}
+	w.Flush()
+
+	return differences
+}
+
+// rowsEqual compares two table cells. MX host names naturally differ
+// between domains so only their status is compared.
+func rowsEqual(row comparisonRow) bool {
+	if strings.HasPrefix(row.label, "MX ") {
+		return statusOf(row.a) == statusOf(row.b)
+	}
+	return row.a == row.b
+}
+
+func statusOf(cell string) string {
+	i := strings.Index(cell, " (")
+	if i < 0 {
+		return cell
+	}
+	return cell[i:]
+}
+
+func sortedJoin(values []string) string {
+	sorted := append([]string(nil), values...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ", ")
+}
+
+func sortedMX(mxs []MXResult) []MXResult {
+	sorted := append([]MXResult(nil), mxs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Host < sorted[j].Host })
+	return sorted
+}
+
+func presence(record string) string {
+	if record == "" {
+		return "missing"
+	}
+	return "present"
+}
+
+func display(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// draftComparison is the JSON output of -compare-draft.
+type draftComparison struct {
+	RFC8461     *Result     `json:"rfc8461"`
+	Draft10     *Result     `json:"draft10"`
+	Differences *resultDiff `json:"differences"`
+}
+
+// compareDraftMain validates domain under each spec and reports the
+// findings that only one of them produces or rates differently. The exit
+// code is that of the -spec result.
+func compareDraftMain(domain string, opts *options) int {
+	results := make(map[string]*Result)
+	for _, spec := range []string{specRFC8461, specDraft10} {
+		specOpts := *opts
+		specOpts.spec = spec
+		results[spec] = validate(domain, &specOpts)
+		annotate(results[spec], &specOpts)
+	}
+	rfc, draft := results[specRFC8461], results[specDraft10]
+	// Appeared is then what only RFC 8461 reports, disappeared what only
+	// the draft does.
+	diff := diffResult(draft, rfc)
+
+	if opts.format == "json" {
+		writeJSON(draftComparison{RFC8461: rfc, Draft10: draft, Differences: diff})
+		return results[opts.spec].Verdict.exitCode()
+	}
+
+	fmt.Printf("Validating %s against %s and %s\n\n", domain, specNames[specRFC8461], specNames[specDraft10])
+	if len(diff.Appeared) > 0 {
+		fmt.Printf("Only under %s:\n", specNames[specRFC8461])
+		for _, f := range diff.Appeared {
+			fmt.Printf("\t%s\n", f)
+		}
+	}
+	if len(diff.Disappeared) > 0 {
+		fmt.Printf("Only under %s:\n", specNames[specDraft10])
+		for _, f := range diff.Disappeared {
+			fmt.Printf("\t%s\n", f)
+		}
+	}
+	for _, c := range diff.Changed {
+		fmt.Printf("[%s] %s: %s under %s, %s under %s\n", c.Code, c.Subject,
+			c.After, specNames[specRFC8461], c.Before, specNames[specDraft10])
+	}
+	if len(diff.Appeared)+len(diff.Disappeared)+len(diff.Changed) == 0 {
+		fmt.Println("Both specs produce the same findings")
+	}
+
+	fmt.Println()
+	fmt.Println(rfc.Verdict.line(domain + " " + specRFC8461))
+	fmt.Println(draft.Verdict.line(domain + " " + specDraft10))
+	return results[opts.spec].Verdict.exitCode()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// mxCoverage maps the live MX hosts to the policy mx patterns that
+// authorize them, in both directions, so the effect of removing a pattern
+// can be read off.
+type mxCoverage struct {
+	Hosts    []hostCoverage    `json:"hosts"`
+	Patterns []patternCoverage `json:"patterns"`
+}
+
+// hostCoverage is one live MX host. Pattern is the first pattern matching
+// it, the one reported as authorizing it; Also are the other matches.
+type hostCoverage struct {
+	Host    string   `json:"host"`
+	Covered bool     `json:"covered"`
+	Pattern string   `json:"pattern,omitempty"`
+	Also    []string `json:"also_matched_by,omitempty"`
+}
+
+// patternCoverage is one policy mx pattern. Sole are the hosts no other
+// pattern matches, the hosts removing it would leave uncovered.
+type patternCoverage struct {
+	Pattern string   `json:"pattern"`
+	Hosts   []string `json:"hosts"`
+	Sole    []string `json:"only_pattern_for,omitempty"`
+}
+
+// coverMX matches every host against every pattern. Repeated patterns are
+// listed once.
+func coverMX(spec string, patterns []string, hosts []string) *mxCoverage {
+	coverage := &mxCoverage{Hosts: []hostCoverage{}, Patterns: []patternCoverage{}}
+	index := make(map[string]int)
+	for _, pattern := range patterns {
+		if _, ok := index[pattern]; !ok {
+			index[pattern] = len(coverage.Patterns)
+			coverage.Patterns = append(coverage.Patterns, patternCoverage{Pattern: pattern, Hosts: []string{}})
+		}
+	}
+	for _, host := range hosts {
+		hc := hostCoverage{Host: host}
+		var matched []string
+		for _, pc := range coverage.Patterns {
+			if mxMatch(spec, []string{pc.Pattern}, host) != "" {
+				matched = append(matched, pc.Pattern)
+				coverage.Patterns[index[pc.Pattern]].Hosts = append(coverage.Patterns[index[pc.Pattern]].Hosts, host)
+			}
+		}
+		if len(matched) > 0 {
+			hc.Covered, hc.Pattern, hc.Also = true, matched[0], matched[1:]
+		}
+		if len(matched) == 1 {
+			pc := &coverage.Patterns[index[matched[0]]]
+			pc.Sole = append(pc.Sole, host)
+		}
+		coverage.Hosts = append(coverage.Hosts, hc)
+	}
+	return coverage
+}
+
+// printCoverage renders the coverage as two tables, hosts then patterns.
+func printCoverage(coverage *mxCoverage) {
+	fmt.Println("MX coverage:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, hc := range coverage.Hosts {
+		switch {
+		case !hc.Covered:
+			fmt.Fprintf(w, "\t%s\tNOT COVERED\t\n", displayName(hc.Host))
+		case len(hc.Also) > 0:
+			fmt.Fprintf(w, "\t%s\tby %s\talso matched by %s\n", displayName(hc.Host), displayName(hc.Pattern), strings.Join(hc.Also, ", "))
+		default:
+			fmt.Fprintf(w, "\t%s\tby %s\t\n", displayName(hc.Host), displayName(hc.Pattern))
+		}
+	}
+	w.Flush()
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, pc := range coverage.Patterns {
+		switch {
+		case len(pc.Hosts) == 0:
+			fmt.Fprintf(w, "\t%s\tmatches nothing\t\n", displayName(pc.Pattern))
+		case len(pc.Sole) > 0:
+			fmt.Fprintf(w, "\t%s\tcovers %s\tonly pattern for %s\n", displayName(pc.Pattern), strings.Join(pc.Hosts, ", "), strings.Join(pc.Sole, ", "))
+		default:
+			fmt.Fprintf(w, "\t%s\tcovers %s\t\n", displayName(pc.Pattern), strings.Join(pc.Hosts, ", "))
+		}
+	}
+	w.Flush()
+	fmt.Println()
+}
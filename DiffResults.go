@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// findingChange is a finding present before and after with a different
+// severity.
+type findingChange struct {
+	Code    string   `json:"code"`
+	Subject string   `json:"subject,omitempty"`
+	Before  Severity `json:"before"`
+	After   Severity `json:"after"`
+}
+
+// fieldChange is a policy, MX or certificate field that changed.
+type fieldChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// resultDiff is what changed for one domain between two saved results.
+// Status is "added" or "removed" when the domain is only in one of them.
+type resultDiff struct {
+	Domain      string          `json:"domain"`
+	Status      string          `json:"status,omitempty"`
+	Appeared    []Finding       `json:"appeared,omitempty"`
+	Disappeared []Finding       `json:"disappeared,omitempty"`
+	Changed     []findingChange `json:"severity_changed,omitempty"`
+	Fields      []fieldChange   `json:"fields_changed,omitempty"`
+	Regressions []string        `json:"regressions,omitempty"`
+}
+
+func (d *resultDiff) empty() bool {
+	return d.Status == "" && len(d.Appeared) == 0 && len(d.Disappeared) == 0 && len(d.Changed) == 0 && len(d.Fields) == 0
+}
+
+// diffReport is the JSON output of diff-results.
+type diffReport struct {
+	Domains []*resultDiff `json:"domains"`
+	Verdict *Verdict      `json:"verdict"`
+}
+
+// diffResultsMain implements `diff-results <before.json> <after.json>`. The
+// files are JSON output of earlier runs, single domain or -domains-file.
+// It exits 1 when an error appeared that wasn't there before, 0 otherwise
+// and 2 for bad arguments or a file that can't be read, so a typo can't
+// pass for a regression.
+func diffResultsMain(args []string) int {
+	flags := flag.NewFlagSet("diff-results", flag.ExitOnError)
+	format := flags.String("format", "text", "Output format: text or json")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s diff-results [-format json] <before.json> <after.json>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 || (*format != "text" && *format != "json") {
+		flags.Usage()
+		return 2
+	}
+
+	before, err := loadResults(flags.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	after, err := loadResults(flags.Arg(1))
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+
+	report := diffResults(before, after)
+	if *format == "json" {
+		writeJSON(report)
+	} else {
+		printDiff(report)
+	}
+	return report.Verdict.exitCode()
+}
+
+// loadResults reads a saved single domain Result or a batch report.
+func loadResults(path string) ([]*Result, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var batch struct {
+		Results []*Result `json:"results"`
+	}
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if batch.Results != nil {
+		return batch.Results, nil
+	}
+	var result Result
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if result.Domain == "" {
+		return nil, fmt.Errorf("%s: not a StrictMTATest JSON result", path)
+	}
+	return []*Result{&result}, nil
+}
+
+// diffResults aligns the results by domain and diffs each pair.
+func diffResults(before, after []*Result) *diffReport {
+	byDomain := func(results []*Result) map[string]*Result {
+		m := make(map[string]*Result)
+		for _, result := range results {
+			m[normalizeDomain(result.Domain)] = result
+		}
+		return m
+	}
+	beforeByDomain, afterByDomain := byDomain(before), byDomain(after)
+
+	var domains []string
+	for domain := range beforeByDomain {
+		domains = append(domains, domain)
+	}
+	for domain := range afterByDomain {
+		if beforeByDomain[domain] == nil {
+			domains = append(domains, domain)
+		}
+	}
+	sort.Strings(domains)
+
+	report := &diffReport{Domains: []*resultDiff{}}
+	verdict := &Verdict{Status: verdictPass}
+	for _, domain := range domains {
+		var diff *resultDiff
+		switch {
+		case afterByDomain[domain] == nil:
+			diff = &resultDiff{Domain: beforeByDomain[domain].Domain, Status: "removed"}
+		case beforeByDomain[domain] == nil:
+			diff = diffResult(&Result{}, afterByDomain[domain])
+			diff.Status = "added"
+		default:
+			diff = diffResult(beforeByDomain[domain], afterByDomain[domain])
+		}
+		report.Domains = append(report.Domains, diff)
+		verdict.Reasons = append(verdict.Reasons, diff.Regressions...)
+	}
+
+	if len(verdict.Reasons) > 0 {
+		verdict.Status = verdictFail
+		verdict.Summary = fmt.Sprintf("%s: %s", plural(len(verdict.Reasons), "new error"), strings.Join(verdict.Reasons, ", "))
+	} else {
+		verdict.Summary = fmt.Sprintf("no new errors across %s", plural(len(domains), "domain"))
+	}
+	report.Verdict = verdict
+	return report
+}
+
+// findingKey identifies a finding across runs. Messages carry details like
+// day counts that change from run to run, so they are not part of it.
+func findingKey(f Finding) string {
+	return f.Code + " " + f.Subject
+}
+
+// diffResult compares two results of the same domain. Suppressed findings
+// are accepted risks and left out. An error that is new, or a finding that
+// became an error, is a regression.
+func diffResult(before, after *Result) *resultDiff {
+	diff := &resultDiff{Domain: after.Domain}
+
+	findings := func(result *Result) (map[string]Finding, []string) {
+		m := make(map[string]Finding)
+		counts := make(map[string]int)
+		var keys []string
+		for _, f := range result.Findings {
+			if f.Suppressed {
+				continue
+			}
+			// A code can be reported more than once for a subject, as
+			// in a TXT record with several broken fields.
+			key := findingKey(f)
+			if counts[key]++; counts[key] > 1 {
+				key += fmt.Sprintf(" #%d", counts[key])
+			}
+			m[key] = f
+			keys = append(keys, key)
+		}
+		return m, keys
+	}
+	oldFindings, oldKeys := findings(before)
+	newFindings, newKeys := findings(after)
+
+	for _, key := range newKeys {
+		f := newFindings[key]
+		previous, ok := oldFindings[key]
+		switch {
+		case !ok:
+			diff.Appeared = append(diff.Appeared, f)
+		case previous.Severity != f.Severity:
+			diff.Changed = append(diff.Changed, findingChange{Code: f.Code, Subject: f.Subject, Before: previous.Severity, After: f.Severity})
+		default:
+			continue
+		}
+		if f.Severity == SeverityError && (!ok || previous.Severity != SeverityError) {
+			diff.Regressions = append(diff.Regressions, strings.TrimSpace(after.Domain+" "+key))
+		}
+	}
+	for _, key := range oldKeys {
+		if _, ok := newFindings[key]; !ok {
+			diff.Disappeared = append(diff.Disappeared, oldFindings[key])
+		}
+	}
+
+	field := func(name, a, b string) {
+		if a != b {
+			diff.Fields = append(diff.Fields, fieldChange{Field: name, Before: display(a), After: display(b)})
+		}
+	}
+	field("verdict", verdictStatus(before), verdictStatus(after))
+	field("deployment state", before.DeploymentState, after.DeploymentState)
+	field("sts record", before.STSRecord, after.STSRecord)
+	field("policy mode", before.Mode, after.Mode)
+	field("max_age", before.MaxAge, after.MaxAge)
+	field("mx patterns", sortedJoin(before.PolicyMX), sortedJoin(after.PolicyMX))
+	field("tlsrpt record", before.TLSRPTRecord, after.TLSRPTRecord)
+	field("policy cert sha256", certFingerprint(before.PolicyCert), certFingerprint(after.PolicyCert))
+	field("policy cert expiry", certExpiry(before.PolicyCert), certExpiry(after.PolicyCert))
+
+	oldMX := make(map[string]MXResult)
+	var hosts []string
+	for _, mx := range before.MX {
+		oldMX[mx.Host] = mx
+		hosts = append(hosts, mx.Host)
+	}
+	newMX := make(map[string]MXResult)
+	for _, mx := range after.MX {
+		newMX[mx.Host] = mx
+		if _, ok := oldMX[mx.Host]; !ok {
+			hosts = append(hosts, mx.Host)
+		}
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		a, inOld := oldMX[host]
+		b, inNew := newMX[host]
+		switch {
+		case !inNew:
+			field("MX "+host, a.Status(), "")
+		case !inOld:
+			field("MX "+host, "", b.Status())
+		default:
+			field("MX "+host, a.Status(), b.Status())
+			field("MX "+host+" cert sha256", certFingerprint(a.Cert), certFingerprint(b.Cert))
+			field("MX "+host+" cert expiry", certExpiry(a.Cert), certExpiry(b.Cert))
+		}
+	}
+	return diff
+}
+
+func verdictStatus(result *Result) string {
+	if result.Verdict == nil {
+		return ""
+	}
+	return result.Verdict.Status
+}
+
+func certFingerprint(cert *CertInfo) string {
+	if cert == nil {
+		return ""
+	}
+	return cert.Fingerprint
+}
+
+func certExpiry(cert *CertInfo) string {
+	if cert == nil {
+		return ""
+	}
+	return cert.NotAfter.UTC().Format("2006-01-02")
+}
+
+// printDiff renders the changes per domain: + for findings that appeared,
+// - for those that disappeared and ~ for severity changes.
+func printDiff(report *diffReport) {
+	changed := 0
+	for _, diff := range report.Domains {
+		if diff.empty() {
+			continue
+		}
+		changed++
+		switch diff.Status {
+		case "":
+			fmt.Printf("%s:\n", diff.Domain)
+		default:
+			fmt.Printf("%s (%s):\n", diff.Domain, diff.Status)
+		}
+		for _, f := range diff.Appeared {
+			fmt.Printf("\t+ %s\n", f)
+		}
+		for _, f := range diff.Disappeared {
+			fmt.Printf("\t- %s\n", f)
+		}
+		for _, c := range diff.Changed {
+			fmt.Printf("\t~ [%s] %s: %s -> %s\n", c.Code, c.Subject, c.Before, c.After)
+		}
+		for _, c := range diff.Fields {
+			fmt.Printf("\t%s: %s -> %s\n", c.Field, c.Before, c.After)
+		}
+		fmt.Println()
+	}
+	if changed == 0 {
+		fmt.Printf("No changes\n\n")
+	}
+	fmt.Println(report.Verdict.line("diff"))
+}
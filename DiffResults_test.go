@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiffResultsUsageExitCode(t *testing.T) {
+	saved := filepath.Join(t.TempDir(), "result.json")
+	if err := os.WriteFile(saved, []byte(`{"domain": "example.com"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"one file", []string{saved}, 2},
+		{"unknown format", []string{"-format", "xml", saved, saved}, 2},
+		{"unreadable file", []string{saved, saved + ".missing"}, 2},
+		{"unchanged", []string{saved, saved}, 0},
+	}
+	for _, test := range tests {
+		if got := diffResultsMain(test.args); got != test.want {
+			t.Errorf("%s: exit code %d, want %d", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// dnsTimeout bounds every DNS lookup the tool makes itself, see
+// -timeout-dns. Zero leaves it to the resolver, which retries for up to
+// about 20 seconds with the default resolv.conf settings. Names resolved
+// while dialing the MX or the policy host are governed by the dial
+// timeouts instead.
+var dnsTimeout time.Duration
+
+// dnsResolver is the source of the MX, TXT and CNAME records a run
+// validates: DNS, or a zone file with -zonefile. *net.Resolver implements
+// it.
+type dnsResolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	// LookupCNAME returns the target of the alias name, or name itself
+	// when it is not one.
+	LookupCNAME(ctx context.Context, name string) (string, error)
+}
+
+var resolver dnsResolver = net.DefaultResolver
+
+// dnsContext returns the context for one DNS lookup.
+func dnsContext() (context.Context, context.CancelFunc) {
+	if dnsTimeout > 0 {
+		return context.WithTimeout(context.Background(), dnsTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// isDNSTimeout reports whether a lookup failed by running out of time, as
+// opposed to a negative or failed answer.
+func isDNSTimeout(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsTimeout {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// maxCNAMEHops is how many aliases cnameChain follows before giving up.
+const maxCNAMEHops = 8
+
+// cnameChain follows the aliases of host one lookup at a time and returns
+// every name after host, the canonical name last. It is empty when host is
+// not an alias. A chain that loops or runs past maxCNAMEHops is returned as
+// far as it was followed, with an error saying why it stopped; a failed
+// lookup just ends it. The system resolver follows a chain itself and
+// answers with where it ends, so through it a long chain shows as one hop.
+func cnameChain(host string) ([]string, error) {
+	var chain []string
+	current := normalizeDomain(host)
+	seen := map[string]bool{current: true}
+	for {
+		ctx, cancel := dnsContext()
+		cname, err := resolver.LookupCNAME(ctx, current)
+		cancel()
+		next := normalizeDomain(cname)
+		if err != nil || next == "" || next == current {
+			return chain, nil
+		}
+		if seen[next] {
+			return chain, fmt.Errorf("the chain loops back to %s", next)
+		}
+		if len(chain) == maxCNAMEHops {
+			return chain, fmt.Errorf("the chain is longer than %d hops and was not followed further", maxCNAMEHops)
+		}
+		seen[next] = true
+		chain = append(chain, next)
+		current = next
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+// ednsBufferSize is the UDP payload size advertised with EDNS, the 1232
+// bytes of DNS Flag Day 2020 that most resolvers now use. Answers over 512
+// bytes need EDNS at all, answers over this need TCP.
+const (
+	ednsBufferSize = 1232
+	classicUDPSize = 512
+)
+
+// dnsSizeProbe is the size of the _mta-sts answer of one nameserver, part
+// of -check-ns-consistency. TCPSize is only measured when the UDP answer
+// was truncated, TCPError is why that failed.
+type dnsSizeProbe struct {
+	Nameserver string `json:"nameserver"`
+	UDPSize    int    `json:"udp_size,omitempty"`
+	Truncated  bool   `json:"truncated"`
+	TCPSize    int    `json:"tcp_size,omitempty"`
+	TCPError   string `json:"tcp_error,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// size is the full size of the answer, over TCP when UDP truncated it.
+func (p dnsSizeProbe) size() int {
+	if p.TCPSize > 0 {
+		return p.TCPSize
+	}
+	return p.UDPSize
+}
+
+// txtQuery builds a recursion-desired TXT query for name with an EDNS OPT
+// record advertising ednsBufferSize.
+func txtQuery(id uint16, name string) ([]byte, error) {
+	msg := make([]byte, 12, 64)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], 0x0100) // RD
+	binary.BigEndian.PutUint16(msg[4:], 1)      // QDCOUNT
+	binary.BigEndian.PutUint16(msg[10:], 1)     // ARCOUNT, the OPT record
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" || len(label) > 63 {
+			return nil, fmt.Errorf("%q is not a valid query name", name)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, 0, 16, 0, 1) // root, TXT, IN
+	msg = append(msg, 0, 0, 41)       // root, OPT
+	msg = binary.BigEndian.AppendUint16(msg, ednsBufferSize)
+	msg = append(msg, 0, 0, 0, 0, 0, 0) // extended RCODE and flags, no options
+	return msg, nil
+}
+
+// measureTXTResponse asks server for the TXT records of name over UDP and,
+// when the answer comes back truncated, again over TCP to learn its full
+// size.
+func measureTXTResponse(server string, nameserver string, name string) dnsSizeProbe {
+	probe := dnsSizeProbe{Nameserver: nameserver}
+	timeout := 10 * time.Second
+	if dnsTimeout > 0 {
+		timeout = dnsTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	id := uint16(rand.Intn(1 << 16))
+	query, err := txtQuery(id, name)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	answer, err := exchangeUDP(ctx, server, query)
+	if err == nil {
+		err = checkAnswer(answer, id)
+	}
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	probe.UDPSize = len(answer)
+	probe.Truncated = answer[2]&0x02 != 0
+	if !probe.Truncated {
+		return probe
+	}
+
+	answer, err = exchangeTCP(ctx, server, query)
+	if err == nil {
+		err = checkAnswer(answer, id)
+	}
+	if err != nil {
+		probe.TCPError = err.Error()
+		return probe
+	}
+	probe.TCPSize = len(answer)
+	return probe
+}
+
+func exchangeUDP(ctx context.Context, server string, query []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(server, "53"))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func exchangeTCP(ctx context.Context, server string, query []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(server, "53"))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	answer := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// checkAnswer makes sure answer is a response to the query with id.
+func checkAnswer(answer []byte, id uint16) error {
+	if len(answer) < 12 {
+		return errors.New("short DNS answer")
+	}
+	if binary.BigEndian.Uint16(answer) != id || answer[2]&0x80 == 0 {
+		return errors.New("the answer does not match the query")
+	}
+	return nil
+}
+
+// checkSTSResponseSize reports the largest _mta-sts answer measured by
+// compareNameservers. Resolvers and firewalls that drop fragmented UDP or
+// DNS over TCP fail on large answers, so growing ones are a warning.
+func checkSTSResponseSize(result *Result, stsName string) {
+	var largest *dnsSizeProbe
+	for i := range result.STSResponseSizes {
+		probe := &result.STSResponseSizes[i]
+		if probe.Error == "" && (largest == nil || probe.size() > largest.size()) {
+			largest = probe
+		}
+	}
+	switch {
+	case largest == nil:
+		return
+	case largest.Truncated && largest.TCPSize == 0:
+		result.warnf("STS-DNS-TRUNCATED", stsName, "the answer of %s is truncated over UDP at %d bytes and retrying over TCP failed, so resolvers may not get the record: %s",
+			largest.Nameserver, largest.UDPSize, largest.TCPError)
+	case largest.Truncated:
+		result.warnf("STS-DNS-TRUNCATED", stsName, "the answer of %s is %d bytes, more than the %d byte EDNS buffer, so it is truncated over UDP and resolvers must retry over TCP",
+			largest.Nameserver, largest.size(), ednsBufferSize)
+	case largest.size() > classicUDPSize:
+		result.warnf("STS-DNS-RESPONSE-LARGE", stsName, "the answer of %s is %d bytes, more than %d, so resolvers without EDNS get it truncated",
+			largest.Nameserver, largest.size(), classicUDPSize)
+	default:
+		result.infof("STS-DNS-RESPONSE-SIZE", stsName, "the answer of %s is %d bytes over UDP, not truncated", largest.Nameserver, largest.size())
+	}
+}
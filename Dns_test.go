@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeResolver answers from maps. A name in errs fails with that error for
+// every type; any other name without records is not found.
+type fakeResolver struct {
+	mx    map[string][]*net.MX
+	txt   map[string][]string
+	cname map[string]string
+	errs  map[string]error
+}
+
+func (f *fakeResolver) lookup(name string) error {
+	if err := f.errs[normalizeDomain(name)]; err != nil {
+		return err
+	}
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (f *fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	if records := f.mx[normalizeDomain(name)]; len(records) > 0 {
+		return records, nil
+	}
+	return nil, f.lookup(name)
+}
+
+func (f *fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if records := f.txt[normalizeDomain(name)]; len(records) > 0 {
+		return records, nil
+	}
+	return nil, f.lookup(name)
+}
+
+// LookupCNAME returns the next name of a chain, or name itself when it is
+// not an alias.
+func (f *fakeResolver) LookupCNAME(ctx context.Context, name string) (string, error) {
+	if err := f.errs[normalizeDomain(name)]; err != nil {
+		return "", err
+	}
+	if target := f.cname[normalizeDomain(name)]; target != "" {
+		return target + ".", nil
+	}
+	return normalizeDomain(name) + ".", nil
+}
+
+// useResolver makes r the resolver for the rest of the test.
+func useResolver(t *testing.T, r dnsResolver) {
+	t.Helper()
+	saved := resolver
+	resolver = r
+	t.Cleanup(func() { resolver = saved })
+}
+
+// Errors the resolver returns for a failed and an unanswered lookup.
+var (
+	errServFail = &net.DNSError{Err: "server misbehaving", Name: "example.com", IsTemporary: true}
+	errTimeout  = &net.DNSError{Err: "i/o timeout", Name: "example.com", IsTimeout: true}
+)
+
+func TestCNAMEChain(t *testing.T) {
+	long := map[string]string{}
+	for i := 0; i < maxCNAMEHops+2; i++ {
+		long[fmt.Sprintf("hop%d.example.com", i)] = fmt.Sprintf("hop%d.example.com", i+1)
+	}
+	tests := []struct {
+		name    string
+		cname   map[string]string
+		host    string
+		want    string
+		wantErr string
+	}{
+		{"not an alias", nil, "mx.example.com", "", ""},
+		{"one hop", map[string]string{"mx.example.com": "mail.provider.net"}, "mx.example.com", "mail.provider.net", ""},
+		{"chain", map[string]string{"mx.example.com": "mx.cdn.net", "mx.cdn.net": "edge.cdn.net"}, "MX.example.com.", "mx.cdn.net -> edge.cdn.net", ""},
+		{"loop", map[string]string{"mx.example.com": "a.example.net", "a.example.net": "mx.example.com"}, "mx.example.com", "a.example.net", "loops back to mx.example.com"},
+		{"too long", long, "hop0.example.com", "hop1.example.com", "longer than 8 hops"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useResolver(t, &fakeResolver{cname: test.cname})
+			chain, err := cnameChain(test.host)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("error %v, want %q", err, test.wantErr)
+				}
+				if len(chain) == 0 || chain[0] != test.want {
+					t.Errorf("chain = %q, want it to start with %s", chain, test.want)
+				}
+				if test.name == "too long" && len(chain) != maxCNAMEHops {
+					t.Errorf("%d hops followed, want %d", len(chain), maxCNAMEHops)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(chain, " -> "); got != test.want {
+				t.Errorf("chain = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestZoneCNAMEChain(t *testing.T) {
+	useResolver(t, &zoneResolver{path: "example.com.zone", cname: map[string]string{"mx.example.com": "mx.cdn.net", "mx.cdn.net": "edge.cdn.net"}})
+	chain, err := cnameChain("mx.example.com")
+	if got := strings.Join(chain, " -> "); err != nil || got != "mx.cdn.net -> edge.cdn.net" {
+		t.Errorf("chain through the zone file = %q, %v, want every hop", got, err)
+	}
+}
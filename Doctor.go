@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The references doctor probes. They are large, long-lived deployments, so
+// a failure says more about this machine than about them.
+var (
+	doctorMXDomains = []string{"gmail.com", "outlook.com"}
+	doctorTXTName   = "_mta-sts.gmail.com"
+	doctorDoHURL    = "https://dns.google/resolve"
+	doctorHTTPSURL  = "https://mta-sts.gmail.com/.well-known/mta-sts.txt"
+)
+
+// doctorProbe is the outcome of one environment probe. Affects names the
+// class of check that can't be trusted from this machine when it fails.
+// Skipped means a prerequisite failed, so the probe says nothing either
+// way.
+type doctorProbe struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Detail  string `json:"detail"`
+	Affects string `json:"affects"`
+	Advice  string `json:"advice,omitempty"`
+}
+
+// The classes of check doctor vouches for.
+var doctorClasses = []string{"SMTP", "DNS", "policy fetch"}
+
+// doctorReport is the JSON output of doctor. Classes maps each class of
+// check to "trustworthy", "untrustworthy" or "untested".
+type doctorReport struct {
+	Probes  []doctorProbe     `json:"probes"`
+	Classes map[string]string `json:"classes"`
+}
+
+// doctorMain implements `doctor`. It checks whether this machine can run the
+// validation at all: outbound port 25, a resolver that answers truthfully
+// and HTTPS without an intercepting proxy. It exits 1 when a class of check
+// can't be trusted from here.
+func doctorMain(args []string) int {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	format := flags.String("format", "text", "Output format: text or json")
+	caFile := flags.String("ca-file", "", caFileUsage)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s doctor [-format json] [-ca-file roots.pem]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 0 || (*format != "text" && *format != "json") {
+		flags.Usage()
+		return 1
+	}
+	if err := installRoots(*caFile); err != nil {
+		fmt.Fprintf(flags.Output(), "Invalid -ca-file: %v\n\n", err)
+		flags.PrintDefaults()
+		return 1
+	}
+
+	var probes []doctorProbe
+	for _, domain := range doctorMXDomains {
+		probes = append(probes, probePort25(domain))
+	}
+	probes = append(probes, probeNXDOMAIN(), probeDoH(), probeHTTPS())
+
+	report := &doctorReport{Probes: probes, Classes: make(map[string]string)}
+	for _, class := range doctorClasses {
+		report.Classes[class] = "untested"
+	}
+	for _, probe := range probes {
+		switch {
+		case probe.Skipped:
+		case !probe.OK:
+			report.Classes[probe.Affects] = "untrustworthy"
+		case report.Classes[probe.Affects] == "untested":
+			report.Classes[probe.Affects] = "trustworthy"
+		}
+	}
+
+	if *format == "json" {
+		writeJSON(report)
+	} else {
+		printDoctor(report)
+	}
+	for _, state := range report.Classes {
+		if state == "untrustworthy" {
+			return 1
+		}
+	}
+	return 0
+}
+
+// probePort25 connects to the first MX of a reference domain and waits for
+// its greeting. Residential ISPs and most cloud providers block outbound
+// port 25, which makes every MX look unreachable.
+func probePort25(domain string) doctorProbe {
+	probe := doctorProbe{Name: "port 25 to the MX of " + domain, Affects: "SMTP",
+		Advice: "run from a host with outbound port 25 open, cloud providers often unblock it on request; until then every MX reads as SMTP-CONNECT-TIMEOUT and only -policy-file linting is meaningful"}
+	ctx, cancel := dnsContext()
+	mxs, err := net.DefaultResolver.LookupMX(ctx, domain)
+	cancel()
+	if err != nil || len(mxs) == 0 {
+		probe.Skipped = true
+		probe.Detail = fmt.Sprintf("not tested, the MX lookup failed: %v", err)
+		return probe
+	}
+	host := normalizeDomain(mxs[0].Host)
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, "25"), 10*time.Second)
+	if err != nil {
+		probe.Detail = fmt.Sprintf("%s: %v", host, err)
+		return probe
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	greeting, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || !strings.HasPrefix(greeting, "220") {
+		// Some blocks accept the connection and then stay silent.
+		probe.Detail = fmt.Sprintf("%s accepted the connection but sent no SMTP greeting: %v", host, err)
+		return probe
+	}
+	probe.OK = true
+	probe.Detail = fmt.Sprintf("%s greeted with %s", host, shorten(strings.TrimSpace(greeting), 60))
+	return probe
+}
+
+// probeNXDOMAIN looks up a random name that can't exist. Some resolvers
+// answer with the address of a search or ad page instead of NXDOMAIN,
+// which makes missing records look present.
+func probeNXDOMAIN() doctorProbe {
+	probe := doctorProbe{Name: "NXDOMAIN is passed through", Affects: "DNS",
+		Advice: "use a resolver that does not rewrite negative answers, for example by pointing /etc/resolv.conf at a public resolver"}
+	label := make([]byte, 8)
+	rand.Read(label)
+	name := "strictmtatest-" + hex.EncodeToString(label) + ".example.com"
+
+	ctx, cancel := dnsContext()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, name)
+	cancel()
+	switch {
+	case err == nil:
+		probe.Detail = fmt.Sprintf("%s resolved to %s instead of NXDOMAIN", name, strings.Join(addrs, ", "))
+	case isNotFound(err):
+		probe.OK = true
+		probe.Detail = name + " does not exist, as expected"
+	default:
+		probe.Detail = fmt.Sprintf("lookup of %s failed: %v", name, err)
+	}
+	return probe
+}
+
+// probeDoH compares the system resolver's answer for a known record with
+// the answer of a DNS over HTTPS resolver, which a local resolver or
+// middlebox can't tamper with.
+func probeDoH() doctorProbe {
+	probe := doctorProbe{Name: "resolver agrees with DNS over HTTPS", Affects: "DNS",
+		Advice: "the local resolver returns different records than public DNS, from a stale cache or split-horizon DNS, so results from here differ from what senders see; -zonefile validates records you supply instead"}
+
+	ctx, cancel := dnsContext()
+	local, err := net.DefaultResolver.LookupTXT(ctx, doctorTXTName)
+	cancel()
+	if err != nil {
+		probe.Detail = fmt.Sprintf("system resolver: %v", err)
+		probe.Advice = "the resolver of this machine does not resolve public names, so every lookup will fail; check /etc/resolv.conf"
+		return probe
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Get(doctorDoHURL + "?type=TXT&name=" + url.QueryEscape(doctorTXTName))
+	if err != nil {
+		probe.Skipped = true
+		probe.Detail = fmt.Sprintf("not tested, DNS over HTTPS failed: %v", err)
+		return probe
+	}
+	defer response.Body.Close()
+	var answer struct {
+		Answer []struct {
+			Type int    `json:"type"`
+			Data string `json:"data"`
+		}
+	}
+	if err := json.NewDecoder(response.Body).Decode(&answer); err != nil {
+		probe.Skipped = true
+		probe.Detail = fmt.Sprintf("not tested, unreadable DNS over HTTPS answer: %v", err)
+		return probe
+	}
+	var remote []string
+	for _, record := range answer.Answer {
+		if record.Type == 16 {
+			// Character strings come quoted, joined with spaces.
+			remote = append(remote, strings.Trim(strings.ReplaceAll(record.Data, `" "`, ""), `"`))
+		}
+	}
+
+	sort.Strings(local)
+	sort.Strings(remote)
+	if strings.Join(local, "\n") != strings.Join(remote, "\n") {
+		probe.Detail = fmt.Sprintf("%s: system resolver answers %s, DNS over HTTPS %s",
+			doctorTXTName, strings.Join(quoteAll(local), ", "), strings.Join(quoteAll(remote), ", "))
+		return probe
+	}
+	probe.OK = true
+	probe.Detail = fmt.Sprintf("%s: both answer %s", doctorTXTName, strings.Join(quoteAll(local), ", "))
+	return probe
+}
+
+// probeHTTPS fetches a known policy the way the policy fetch does, proxy
+// settings included, and checks who issued the certificate. An
+// intercepting proxy presents a certificate of its own, which verifies
+// here when its CA is installed but not for senders.
+func probeHTTPS() doctorProbe {
+	probe := doctorProbe{Name: "HTTPS without interception", Affects: "policy fetch",
+		Advice: "the policy host certificate seen from here is not the one senders see; unset HTTPS_PROXY or run outside the intercepting network, or lint a copy of the policy with -policy-file"}
+	response, err := fetchPolicy(policyClient, doctorHTTPSURL)
+	if response == nil || response.TLS == nil {
+		probe.Detail = fmt.Sprintf("%s: %v", doctorHTTPSURL, err)
+		probe.Advice = "HTTPS fetches fail from this machine, so every policy fetch will; check the network and any HTTPS_PROXY setting, or lint a copy of the policy with -policy-file"
+		return probe
+	}
+	if len(response.TLS.PeerCertificates) == 0 {
+		probe.Detail = doctorHTTPSURL + ": no certificate presented"
+		return probe
+	}
+	// The fetch verified the chain against the roots of this machine. An
+	// intercepting proxy's CA is installed there, but it is not among the
+	// public roots of the embedded bundle.
+	chain := response.TLS.PeerCertificates
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := chain[0].Verify(x509.VerifyOptions{Roots: embeddedPool(), Intermediates: intermediates}); err != nil {
+		probe.Detail = fmt.Sprintf("%s presented a chain issued by %s that verifies against the roots of this machine but not against the public roots: %v",
+			doctorHTTPSURL, chain[len(chain)-1].Issuer, err)
+		return probe
+	}
+	probe.OK = true
+	probe.Detail = fmt.Sprintf("certificate issued by %s, verified against the public roots", chain[0].Issuer)
+	req, _ := http.NewRequest(http.MethodGet, doctorHTTPSURL, nil)
+	if proxy, _ := http.ProxyFromEnvironment(req); proxy != nil {
+		probe.Detail += ", through the proxy " + proxy.Host
+	}
+	return probe
+}
+
+// printDoctor renders the probes and which classes of check can be trusted.
+func printDoctor(report *doctorReport) {
+	for _, probe := range report.Probes {
+		if probe.OK {
+			fmt.Printf("✔  %s: %s\n", probe.Name, probe.Detail)
+		} else if probe.Skipped {
+			fmt.Printf("-  %s: %s\n", probe.Name, probe.Detail)
+		} else {
+			fmt.Printf("\x1b[31;1m✘\x1b[0m  %s: %s\n", probe.Name, probe.Detail)
+			fmt.Printf("\tHint: %s\n", probe.Advice)
+		}
+	}
+
+	fmt.Println()
+	for _, class := range doctorClasses {
+		switch report.Classes[class] {
+		case "trustworthy":
+			fmt.Printf("%s checks are trustworthy from this machine\n", class)
+		case "untested":
+			fmt.Printf("%s checks could not be tested from this machine\n", class)
+		default:
+			fmt.Printf("\x1b[31;1m%s checks are NOT trustworthy from this machine\x1b[0m\n", class)
+		}
+	}
+}
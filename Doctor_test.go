@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProbeHTTPSInterception(t *testing.T) {
+	// The test server plays an intercepting proxy whose CA this machine
+	// trusts but the public roots don't include.
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(policyOf("version: STSv1", "mode: enforce", "mx: gmail-smtp-in.l.google.com", "max_age: 86400")))
+	}))
+	defer server.Close()
+
+	savedURL, savedPool, savedSource := doctorHTTPSURL, rootPool, rootSource
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	useRoots(pool, "test")
+	doctorHTTPSURL = server.URL + "/.well-known/mta-sts.txt"
+	t.Cleanup(func() {
+		doctorHTTPSURL = savedURL
+		policyClient.Transport.(*http.Transport).CloseIdleConnections()
+		useRoots(savedPool, savedSource)
+	})
+
+	probe := probeHTTPS()
+	if probe.OK || !strings.Contains(probe.Detail, "verifies against the roots of this machine but not against the public roots") {
+		t.Errorf("probe OK %v: %s, want the locally trusted chain reported as interception", probe.OK, probe.Detail)
+	}
+}
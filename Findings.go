@@ -0,0 +1,358 @@
+package main
+
+// findingInfo documents a finding code: why it matters and where the
+// requirement comes from.
+type findingInfo struct {
+	Explanation string
+	Reference   string
+}
+
+// findingCodes lists every finding code the tool can emit. New codes must be
+// added here so -explain can describe them.
+var findingCodes = map[string]findingInfo{
+	"DOMAIN-IS-POLICY-HOST": {
+		"MTA-STS is discovered for the mail domain, the part of an address after the @: senders look up _mta-sts.<domain> and fetch the policy from mta-sts.<domain>. Validating the policy host or record name itself would look for mta-sts.mta-sts.<domain>.",
+		"RFC 8461 §3.1, §3.3",
+	},
+	"MX-LOOKUP-FAILED": {
+		"Without the MX records there is nothing to deliver to, and the policy cannot be checked against the live mail servers.",
+		"RFC 8461 §4.1",
+	},
+	"DNS-TIMEOUT": {
+		"The lookup got no answer in time, so it is unknown whether the record exists; senders with a similar timeout will see the same and fall back to no policy or defer mail.",
+		"RFC 8461 §3.1, §4.1",
+	},
+	"MX-POINTS-TO-CNAME": {
+		"An MX target must be a host name with address records, not an alias. Senders connect to the alias and check the certificate and the policy mx patterns against it, not against the name it points to.",
+		"RFC 2181 §10.3, RFC 5321 §5.1, RFC 8461 §4.1",
+	},
+	"MX-NAME-INVALID": {
+		"The MX host breaks the DNS name length limits (253 characters, 63 per label), so no sender can resolve or connect to it.",
+		"RFC 1035 §2.3.4",
+	},
+	"MX-IP-LITERAL": {
+		"The exchange of an MX record must be a host name. Senders resolve an IP address written there as a name, which normally fails, and neither the TLS certificate nor the policy mx patterns can identify an address.",
+		"RFC 5321 §5.1, RFC 8461 §4.1",
+	},
+	"SMTP-CONNECT-FAILED": {
+		"Senders could not reach this MX on port 25, so mail to it is deferred or routed to another MX.",
+		"RFC 8461 §5",
+	},
+	"SMTP-CONNECTION-REFUSED": {
+		"The MX answered the connection attempt with a reset: the host is up but no MTA listens on port 25, so senders defer the mail or route it to another MX.",
+		"RFC 5321 §5.1",
+	},
+	"SMTP-CONNECT-TIMEOUT": {
+		"The connection attempt went unanswered. A firewall dropping the packets does this, at the MX or, very often, on the network the tool runs on, since many providers block outbound port 25.",
+		"RFC 5321 §5.1",
+	},
+	"SMTP-UNREACHABLE": {
+		"An ICMP unreachable or a missing route ended the connection attempt, so senders on this network can't reach the MX at all.",
+		"RFC 5321 §5.1",
+	},
+	"SMTP-IPV6-UNTESTABLE": {
+		"The MX has only IPv6 addresses and this machine can't reach IPv6 hosts, so the connection failure is a limitation of where the tool runs, not evidence against the MX. Its STARTTLS and certificate remain unchecked.",
+		"RFC 8461 §5",
+	},
+	"SMTP-NO-GREETING": {
+		"The MX accepted the connection but never sent its 220 greeting; senders hang until their own timeout and defer the mail, the hallmark of a tarpit or a wedged listener.",
+		"RFC 5321 §4.5.3.2.1",
+	},
+	"STARTTLS-FAILED": {
+		"MTA-STS requires a TLS session with every MX; a host that cannot negotiate STARTTLS is treated as a delivery failure under enforce.",
+		"RFC 8461 §4.2",
+	},
+	"CHAIN-OUT-OF-ORDER": {
+		"The certificates are not sent leaf first with each one signed by the next. Go and browsers reorder them, but OpenSSL in strict modes and several MTAs do not, so some senders fail to verify the chain.",
+		"RFC 8446 §4.4.2, RFC 5246 §7.4.2",
+	},
+	"CHAIN-EXTRANEOUS-CERT": {
+		"The server sends a certificate that is not on any path from the leaf to a root, often a stale intermediate left in the bundle. Strict verifiers may reject the chain, and every handshake carries the extra bytes.",
+		"RFC 8446 §4.4.2",
+	},
+	"CHAIN-ROOT-INCLUDED": {
+		"The chain includes its root. Senders trust the root from their own store, so sending it is unnecessary; it is harmless but makes every handshake larger.",
+		"RFC 8446 §4.4.2",
+	},
+	"SMTP-NAME-MISMATCH": {
+		"The name an MX announces in its banner or EHLO greeting differs from its DNS name or certificate; harmless by itself but often the cause of certificate mismatches or a sign of which backend answered.",
+		"RFC 5321 §4.1.1.1, §4.2",
+	},
+	"SMTP-ANTI-PIPELINING": {
+		"The MX rejected the session for issuing commands too soon after the greeting. Real senders wait for each reply, but some are fast enough to trip the same defense and have their mail deferred.",
+		"RFC 5321 §4.3.1, RFC 2920 §3.1",
+	},
+	"SMTP-PRE-TLS-DELAY": {
+		"STARTTLS failed on the first attempt, so the probe was repeated with the -pre-tls-delay pause before STARTTLS; the message says whether that made a difference.",
+		"RFC 3207 §4",
+	},
+	"TLS-VERSION-LOW": {
+		"The connection negotiated a TLS version below the acceptable minimum; MTA-STS requires TLS 1.2 or higher.",
+		"RFC 8461 §3.3, §4.2",
+	},
+	"TLS-RESUMPTION-FAILED": {
+		"A handshake failed during the -probe-resumption reconnect. Some TLS terminators only break on resumed sessions, which senders reusing sessions will hit.",
+		"RFC 8446 §2.2",
+	},
+	"TLS-RESUMPTION-UNSUPPORTED": {
+		"The server did not resume the TLS session; not required, but senders that open many connections pay for a full handshake each time.",
+		"RFC 8446 §2.2, RFC 5077",
+	},
+	"CERT-MISSING": {
+		"The server completed a handshake without presenting a certificate, so its identity cannot be validated.",
+		"RFC 8461 §4.2",
+	},
+	"CERT-EXPIRED": {
+		"Senders MUST NOT deliver to an MX whose certificate is expired when the policy is enforced.",
+		"RFC 8461 §4.2",
+	},
+	"CERT-EXPIRING": {
+		"The certificate will expire soon; once it does, enforcing senders will refuse to deliver to this MX.",
+		"RFC 8461 §4.2",
+	},
+	"CERT-PIN-MISMATCH": {
+		"The MX presented a certificate outside the pinned set; either the certificate was rotated without updating the pins or the connection was intercepted.",
+		"StrictMTATest",
+	},
+	"CERT-SHARED": {
+		"Several MX hosts present the same certificate, typically a wildcard or a shared TLS terminator; when it expires every one of them fails at once and there is no fallback MX.",
+		"RFC 8461 §4.2",
+	},
+	"CERT-WILDCARD": {
+		"The certificate is valid for a wildcard name; fine for MTA-STS, but its private key is usually spread over many servers.",
+		"RFC 6125 §6.4.3",
+	},
+	"CERT-CHAIN-INVALID": {
+		"The certificate MUST chain to a root CA trusted by the sender; self-signed or incomplete chains fail validation.",
+		"RFC 8461 §4.2",
+	},
+	"CERT-HOSTNAME-MISMATCH": {
+		"The certificate MUST have a SAN dNSName matching the MX host name, otherwise senders cannot authenticate the server.",
+		"RFC 8461 §4.2",
+	},
+	"CERT-WILDCARD-MATCH": {
+		"The certificate covers the MX host with a wildcard. A wildcard stands for exactly one leftmost label, so it keeps covering the host only as long as the host stays one label below it.",
+		"RFC 6125 §6.4.3",
+	},
+	"STS-TXT-MISSING": {
+		"Senders discover a policy through the _mta-sts TXT record; without a valid record no policy is ever fetched.",
+		"RFC 8461 §3.1",
+	},
+	"STS-TXT-LOOKUP-FAILED": {
+		"The _mta-sts TXT lookup itself failed, so it is unknown whether a policy is published; senders treat this as no policy.",
+		"RFC 8461 §3.1",
+	},
+	"STS-TXT-INVALID": {
+		"The record must carry an id, which senders compare to decide whether to refetch the policy; RFC 8461 limits it to 1-32 alphanumeric characters and fields must not repeat.",
+		"RFC 8461 §3.1",
+	},
+	"STS-TXT-MULTIPLE": {
+		"With more than one record starting with v=STSv1 senders must discard them all and behave as if the domain had no policy.",
+		"RFC 8461 §3.1",
+	},
+	"TXT-DUPLICATE-FIELD": {
+		"A record repeating v or id is ambiguous: different senders pick different ids, so an id change meant to refresh cached policies may go unnoticed.",
+		"RFC 8461 §3.1",
+	},
+	"STS-TXT-POLICY-KEY": {
+		"The TXT record only carries v and id; policy settings like mode or mx in it are ignored, so the policy senders apply is whatever the policy file says.",
+		"RFC 8461 §3.1, §3.2",
+	},
+	"STS-TXT-UNKNOWN-KEY": {
+		"The record has an extension field no spec defines. It is allowed and ignored by senders, but often a typo of id.",
+		"RFC 8461 §3.1",
+	},
+	"STS-NS-LOOKUP-FAILED": {
+		"The nameservers of the domain could not be looked up, so their answers for the STS record could not be compared.",
+		"RFC 8461 §3.1",
+	},
+	"STS-NS-INCONSISTENT": {
+		"Senders get whichever answer their resolver reaches; nameservers returning different STS records mean an id change hasn't propagated and some senders keep a stale policy.",
+		"RFC 8461 §3.1",
+	},
+	"POLICY-CHANGED-ID-UNCHANGED": {
+		"Senders refetch a cached policy only when the id in the _mta-sts record changes. A policy changed under the same id stays unseen by every sender that cached the old one, for up to its max_age, the most common MTA-STS operational mistake.",
+		"RFC 8461 §3.3",
+	},
+	"STS-ID-CHANGED": {
+		"The id in the _mta-sts record changed since the previous run, which makes senders refetch the policy.",
+		"RFC 8461 §3.1",
+	},
+	"STS-DNS-TRUNCATED": {
+		"The _mta-sts answer does not fit a UDP response, so resolvers must retry over TCP. Resolvers and firewalls that block DNS over TCP lose the record, and senders then see no MTA-STS at all.",
+		"RFC 7766 §1",
+	},
+	"STS-DNS-RESPONSE-LARGE": {
+		"The _mta-sts answer is larger than 512 bytes, which needs EDNS; old resolvers and middleboxes that strip EDNS get a truncated answer.",
+		"RFC 6891 §6.2.5",
+	},
+	"STS-DNS-RESPONSE-SIZE": {
+		"The size of the _mta-sts answer, which fits a single UDP response.",
+		"RFC 6891 §6.2.5",
+	},
+	"POLICY-FETCH-FAILED": {
+		"The policy MUST be served over HTTPS from the mta-sts host at /.well-known/mta-sts.txt with a valid certificate.",
+		"RFC 8461 §3.3",
+	},
+	"POLICY-HOST-UNREACHABLE": {
+		"The policy host name resolves but nothing accepts HTTPS connections on it, typically a DNS record created before the web server was set up. Senders find no policy.",
+		"RFC 8461 §3.3",
+	},
+	"POLICY-REDIRECT": {
+		"HTTP 3xx redirects MUST NOT be followed when fetching the policy, so a redirecting policy host serves no policy at all.",
+		"RFC 8461 §3.3",
+	},
+	"DEPLOYMENT-DNS-WITHOUT-POLICY": {
+		"Senders that see the TXT record attempt to fetch the policy; when that fails they fall back to cached state and may defer mail. This half-deployed state is the most dangerous one.",
+		"RFC 8461 §3.3, §5.1",
+	},
+	"DEPLOYMENT-POLICY-WITHOUT-DNS": {
+		"Senders only fetch the policy after finding the _mta-sts TXT record; without it the policy is never applied even though it is live.",
+		"RFC 8461 §3.1, §5.1",
+	},
+	"POLICY-TLS-RETRIED": {
+		"The policy host only completed the TLS handshake on a retry. Senders that give up after one failed handshake see no policy, and a flapping edge can make that happen to any of them.",
+		"RFC 8461 §3.3",
+	},
+	"POLICY-CONTENT-ENCODED": {
+		"The policy is a text/plain resource; senders that don't ask for compression may not decompress it and then fail to parse the policy.",
+		"RFC 8461 §3.3",
+	},
+	"POLICYHOST-SLOW": {
+		"Some senders fetch the policy synchronously while delivering, so a slow policy host delays mail even though the fetch works.",
+		"RFC 8461 §3.3",
+	},
+	"POLICY-CERT-NAME-MISMATCH": {
+		"The policy host MUST present a certificate valid for mta-sts.<domain>; senders will not accept a policy served under any other name.",
+		"RFC 8461 §3.3",
+	},
+	"POLICY-FAMILY-FETCH-FAILED": {
+		"The policy host resolves over both IPv4 and IPv6 but one family could not serve the policy; senders using that family cannot fetch it.",
+		"RFC 8461 §3.3",
+	},
+	"POLICY-RECHECK-FAILED": {
+		"Some of the repeated fetches of the policy failed although the first one succeeded; senders that hit the same backend or moment get no policy.",
+		"RFC 8461 §3.3",
+	},
+	"POLICY-INCONSISTENT": {
+		"Repeated fetches returned different policies, so the backends behind the policy host disagree, typically a partly rolled out update; senders apply whichever one they happen to fetch.",
+		"RFC 8461 §3.3",
+	},
+	"POLICY-ORIGIN-MISMATCH": {
+		"Senders get the policy the CDN edge serves; when it differs from the origin's, an update made at the origin hasn't reached senders, or the CDN rewrites the body.",
+		"RFC 8461 §3.3",
+	},
+	"POLICY-ORIGIN-FETCH-FAILED": {
+		"The origin named by -policy-origin could not be fetched from, so the edge copy could not be compared with it. Senders are unaffected.",
+		"RFC 8461 §3.3",
+	},
+	"POLICY-ORIGIN-MATCH": {
+		"The CDN edge serves the same policy as the origin behind it.",
+		"RFC 8461 §3.3",
+	},
+	"POLICY-DUAL-STACK-MISMATCH": {
+		"Senders may fetch over either address family; different bodies mean different senders apply different policies, typically a half-deployed update.",
+		"RFC 8461 §3.3",
+	},
+	"POLICY-LINE-INVALID": {
+		"Every policy line is a key, a colon and a value; a line that isn't makes the policy invalid for strict parsers, which then ignore it entirely.",
+		"RFC 8461 §3.2",
+	},
+	"POLICY-NOT-LINE-DELIMITED": {
+		"The policy is one line holding several keys, or one very long line: the server strips or replaces the newlines. Senders parse the policy line by line and reject it.",
+		"RFC 8461 §3.2",
+	},
+	"POLICY-MIXED-LINE-ENDINGS": {
+		"Policy lines may end in CRLF or LF, but a mix points at a broken editing or serving pipeline, and strict parsers that expect one kind see stray CR characters in values.",
+		"RFC 8461 §3.2",
+	},
+	"POLICY-VERSION-MISSING": {
+		"The version field is required; a policy without it is invalid.",
+		"RFC 8461 §3.2",
+	},
+	"POLICY-VERSION-INVALID": {
+		"The only defined version is STSv1; any other value makes the policy invalid.",
+		"RFC 8461 §3.2",
+	},
+	"POLICY-MODE-INVALID": {
+		"mode MUST be one of the defined values, otherwise senders cannot tell how to apply the policy.",
+		"RFC 8461 §3.2",
+	},
+	"POLICY-MODE-DEPRECATED": {
+		"The draft specification called the non-enforcing mode report; RFC 8461 renamed it testing. Senders built against the RFC may not recognize the old name.",
+		"RFC 8461 §3.2",
+	},
+	"POLICY-MAX-AGE-MISSING": {
+		"max_age is required and tells senders how long to cache the policy.",
+		"RFC 8461 §3.2",
+	},
+	"POLICY-MAX-AGE-INVALID": {
+		"max_age must be a plain number of seconds; RFC 8461 caps it at 31557600 (about one year).",
+		"RFC 8461 §3.2",
+	},
+	"POLICY-UNKNOWN-KEY": {
+		"Senders ignore fields they do not understand, so an unknown key is usually a typo of a required one.",
+		"RFC 8461 §3.2",
+	},
+	"POLICY-PROFILE-DEVIATION": {
+		"The policy is valid but breaks the profile chosen with -profile, which operators use to keep their policies lean and unambiguous, for instance free of repeated keys and extensions that senders ignore.",
+		"RFC 8461 §3.2",
+	},
+	"POLICY-PROFILE-MATCH": {
+		"The keys of the policy are exactly those the -profile allows.",
+		"RFC 8461 §3.2",
+	},
+	"POLICY-MX-DUPLICATE": {
+		"Repeating an mx pattern has no effect and usually indicates a copy-paste error in the policy.",
+		"RFC 8461 §3.2",
+	},
+	"POLICY-MX-TRAILING-DOT": {
+		"mx values are host names, not zone file names; the trailing dot is tolerated here but other implementations may not match it.",
+		"RFC 8461 §3.2",
+	},
+	"POLICY-MX-WILDCARD-SYNTAX": {
+		"RFC 8461 writes wildcards as *.example.com while the draft used .example.com; senders following one spec treat the other form as a literal name that matches nothing.",
+		"RFC 8461 §3.2, draft-ietf-uta-mta-sts-10 §3.2",
+	},
+	"STS-MX-UNDECLARED": {
+		"Every MX must match an mx pattern in the policy; under enforce, senders will not deliver to an MX that is not listed.",
+		"RFC 8461 §4.1",
+	},
+	"POLICY-VALUE-EMPTY": {
+		"Every policy field needs a value; senders treat a key with nothing after the colon as invalid and may reject the whole policy.",
+		"RFC 8461 §3.2",
+	},
+	"POLICY-MX-INVALID-SYNTAX": {
+		"mx values are host names, optionally with a leading wildcard label; IP addresses, URLs, ports or invalid labels never match an MX host, so senders ignore the line.",
+		"RFC 8461 §3.2, RFC 1123 §2.1",
+	},
+	"IDNA-INVALID": {
+		"Internationalized names are compared in A-label (xn--) form; a name that cannot be converted is compared byte for byte and may not match its other form.",
+		"RFC 5890 §2.3.2.1, RFC 3492, UTS #46",
+	},
+	"POLICY-MX-UNUSED": {
+		"A pattern that matches no live MX host is usually left over from a migration or mistyped, a sign the policy isn't being maintained.",
+		"RFC 8461 §4.1",
+	},
+	"ANNOTATION-UNKNOWN-CODE": {
+		"An mtasts-ignore annotation names a code the tool never emits, so it suppresses nothing; usually a typo.",
+		"StrictMTATest",
+	},
+	"TLSRPT-MISSING": {
+		"Without a TLSRPT record senders have nowhere to report failed TLS deliveries, so problems go unnoticed.",
+		"RFC 8460 §3",
+	},
+	"TLSRPT-LOOKUP-FAILED": {
+		"The TLSRPT TXT lookup itself failed, so it is unknown whether reporting is set up; senders that see the same failure have nowhere to send reports.",
+		"RFC 8460 §3",
+	},
+}
+
+// explainFindings attaches the explanation and reference to each finding.
+func explainFindings(result *Result) {
+	for i, finding := range result.Findings {
+		info := findingCodes[finding.Code]
+		result.Findings[i].Explanation = info.Explanation
+		result.Findings[i].Reference = info.Reference
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+// emittedCodes scans the sources of the package for the finding codes
+// passed to errorf, warnf, infof and add, and returns the severities each
+// is emitted with. A severity add gets at run time counts as an error.
+// Codes that aren't string literals can't be seen here.
+func emittedCodes(t *testing.T) map[string][]Severity {
+	t.Helper()
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	severities := map[string]Severity{"errorf": SeverityError, "warnf": SeverityWarning, "infof": SeverityInfo}
+	constants := map[string]string{"SeverityError": "errorf", "SeverityWarning": "warnf", "SeverityInfo": "infof"}
+	codes := make(map[string][]Severity)
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			selector, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			arg := call.Args[0]
+			severity, ok := severities[selector.Sel.Name]
+			if selector.Sel.Name == "add" && len(call.Args) > 1 {
+				arg, severity, ok = call.Args[1], SeverityError, true
+				if ident, isIdent := call.Args[0].(*ast.Ident); isIdent && constants[ident.Name] != "" {
+					severity = severities[constants[ident.Name]]
+				}
+			}
+			if !ok {
+				return true
+			}
+			literal, ok := arg.(*ast.BasicLit)
+			if !ok || literal.Kind != token.STRING {
+				return true
+			}
+			code, err := strconv.Unquote(literal.Value)
+			if err != nil {
+				t.Fatalf("%s: %v", fset.Position(literal.Pos()), err)
+			}
+			codes[code] = append(codes[code], severity)
+			return true
+		})
+	}
+	if len(codes) == 0 {
+		t.Fatal("found no finding codes in the sources")
+	}
+	return codes
+}
+
+func TestEmittedCodesAreDocumented(t *testing.T) {
+	for code, severities := range emittedCodes(t) {
+		info, ok := findingCodes[code]
+		if !ok {
+			t.Errorf("%s is emitted but not in findingCodes", code)
+		} else if info.Explanation == "" || info.Reference == "" {
+			t.Errorf("%s has no explanation or reference in findingCodes", code)
+		}
+
+		// Informational findings need no next step.
+		needsHint := false
+		for _, severity := range severities {
+			needsHint = needsHint || severity != SeverityInfo
+		}
+		if _, ok := hintTemplates[code]; needsHint && !ok {
+			t.Errorf("%s is emitted as an error or warning but has no hint in hintTemplates", code)
+		}
+	}
+}
+
+func TestDocumentedCodesAreEmitted(t *testing.T) {
+	emitted := emittedCodes(t)
+	for code := range findingCodes {
+		if _, ok := emitted[code]; !ok {
+			t.Errorf("%s is in findingCodes but never emitted", code)
+		}
+	}
+}
+
+func TestHintsBelongToCodes(t *testing.T) {
+	for code, text := range hintTemplates {
+		if _, ok := findingCodes[code]; !ok {
+			t.Errorf("hintTemplates has %s, which is not in findingCodes", code)
+		}
+		if _, err := template.New(code).Funcs(hintFuncs).Parse(text); err != nil {
+			t.Errorf("hint of %s: %v", code, err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Codes a rewritten policy resolves.
+var policyFixCodes = map[string]bool{
+	"POLICY-FETCH-FAILED":           true,
+	"POLICY-HOST-UNREACHABLE":       true,
+	"POLICY-REDIRECT":               true,
+	"DEPLOYMENT-DNS-WITHOUT-POLICY": true,
+	"POLICY-LINE-INVALID":           true,
+	"POLICY-NOT-LINE-DELIMITED":     true,
+	"POLICY-MIXED-LINE-ENDINGS":     true,
+	"POLICY-VERSION-MISSING":        true,
+	"POLICY-VERSION-INVALID":        true,
+	"POLICY-MODE-INVALID":           true,
+	"POLICY-MODE-DEPRECATED":        true,
+	"POLICY-MAX-AGE-MISSING":        true,
+	"POLICY-MAX-AGE-INVALID":        true,
+	"POLICY-MX-WILDCARD-SYNTAX":     true,
+	"POLICY-UNKNOWN-KEY":            true,
+	"POLICY-MX-DUPLICATE":           true,
+	"POLICY-PROFILE-DEVIATION":      true,
+	"POLICY-MX-TRAILING-DOT":        true,
+	"POLICY-MX-INVALID-SYNTAX":      true,
+	"POLICY-VALUE-EMPTY":            true,
+	"STS-MX-UNDECLARED":             true,
+	"POLICY-MX-UNUSED":              true,
+}
+
+// fixScriptText turns the findings of a run into a shell script of suggested
+// corrective actions, see -fix-script. Nothing in it touches DNS or the
+// servers: DNS records are listed as comments and the suggested policy is
+// only written to ./mta-sts.txt for review.
+func fixScriptText(result *Result) string {
+	var buf bytes.Buffer
+	domain := result.Domain
+	id := generateID()
+
+	fmt.Fprintf(&buf, "#!/bin/sh\n")
+	fmt.Fprintf(&buf, "# Suggested fixes for %s, generated by StrictMTATest on %s.\n", domain, time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(&buf, "#\n# THESE ARE SUGGESTIONS. Review every step before applying it. Running this\n")
+	fmt.Fprintf(&buf, "# script only writes the suggested policy to ./mta-sts.txt; it changes no DNS\n# records and no servers.\n")
+
+	var findings []Finding
+	rewritePolicy, publishTXT, publishRPT := false, false, false
+	for _, f := range result.Findings {
+		if f.Suppressed || (f.Severity == SeverityInfo && !policyFixCodes[f.Code]) {
+			continue
+		}
+		findings = append(findings, f)
+		switch {
+		case policyFixCodes[f.Code]:
+			rewritePolicy = true
+		case f.Code == "STS-TXT-MISSING" || f.Code == "STS-TXT-INVALID" || f.Code == "STS-TXT-POLICY-KEY" ||
+			f.Code == "STS-TXT-MULTIPLE" || f.Code == "TXT-DUPLICATE-FIELD" || f.Code == "DEPLOYMENT-POLICY-WITHOUT-DNS":
+			publishTXT = true
+		case f.Code == "TLSRPT-MISSING":
+			publishRPT = true
+		}
+	}
+	if len(findings) == 0 {
+		fmt.Fprintf(&buf, "\n# Nothing to fix: the run produced no findings.\n")
+		return buf.String()
+	}
+
+	fmt.Fprintf(&buf, "\n# Findings addressed:\n")
+	for i, f := range findings {
+		subject := ""
+		if f.Subject != "" {
+			subject = " " + f.Subject
+		}
+		fmt.Fprintf(&buf, "#  %d. [%s]%s: %s\n", i+1, f.Code, subject, f.Message)
+		if f.Hint != "" {
+			fmt.Fprintf(&buf, "#     -> %s\n", f.Hint)
+		}
+	}
+
+	// A changed policy is only picked up by senders once the id changes.
+	if rewritePolicy || publishTXT || publishRPT {
+		fmt.Fprintf(&buf, "\n# DNS records to publish with your DNS provider (zone file syntax):\n")
+		if rewritePolicy || publishTXT {
+			fmt.Fprintf(&buf, "#   %s\n", stsRecord(domain, id))
+		}
+		if publishRPT {
+			fmt.Fprintf(&buf, "#   _smtp._tls.%s. IN TXT \"v=TLSRPTv1; rua=mailto:tlsrpt@%s\"\n", domain, domain)
+		}
+	}
+
+	if rewritePolicy {
+		fmt.Fprintf(&buf, "\n# Suggested policy, to be served at https://mta-sts.%s/.well-known/mta-sts.txt.\n", domain)
+		fmt.Fprintf(&buf, "# Check the mx lines against every host that legitimately receives mail.\n")
+		if len(result.MX) == 0 {
+			fmt.Fprintf(&buf, "# No MX hosts were found, so add an mx line for each of your mail servers.\n")
+		}
+		fmt.Fprintf(&buf, "cat > mta-sts.txt <<'EOF'\n%sEOF\n", suggestedPolicy(result))
+		fmt.Fprintf(&buf, "echo \"Wrote the suggested policy to ./mta-sts.txt, review it before deploying.\"\n")
+	}
+	return buf.String()
+}
+
+// suggestedPolicy builds a policy covering the live MX hosts. Patterns of
+// the current policy that match a live host are kept, an invalid or
+// deprecated mode becomes testing and a missing or invalid max_age becomes
+// one week.
+func suggestedPolicy(result *Result) string {
+	mode := result.Mode
+	if !contains(validModes(result.Spec), mode) {
+		mode = testingMode(result.Spec)
+	}
+
+	maxAge := result.MaxAge
+	if seconds, err := strconv.Atoi(maxAge); err != nil || seconds <= 0 || seconds > maxMaxAge {
+		maxAge = "604800"
+	}
+
+	var mxs []string
+	seen := make(map[string]bool)
+	for _, mx := range result.MX {
+		value := mxMatch(result.Spec, result.PolicyMX, mx.Host)
+		if value == "" {
+			value = mx.Host
+		}
+		value = normalizeDomain(value)
+		if !seen[value] {
+			seen[value] = true
+			mxs = append(mxs, value)
+		}
+	}
+
+	lines := []string{"version: STSv1", "mode: " + mode}
+	for _, mx := range mxs {
+		lines = append(lines, "mx: "+mx)
+	}
+	lines = append(lines, "max_age: "+maxAge)
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// formatGHA renders findings as GitHub Actions workflow commands, which the
+// runner turns into annotations on the workflow run.
+const formatGHA = "gha"
+
+// ghaCommands maps severities to workflow commands.
+var ghaCommands = map[Severity]string{
+	SeverityError:   "error",
+	SeverityWarning: "warning",
+	SeverityInfo:    "notice",
+}
+
+// printGHA renders result for a GitHub Actions log: details prints the
+// regular report without its findings inside a group named after the
+// domain, then every unsuppressed finding becomes an annotation. Findings
+// of a -policy-file run in the workspace are attached to their line.
+func printGHA(result *Result, details func()) {
+	fmt.Printf("::group::%s\n", ghaData(result.Domain))
+	details()
+	fmt.Println("::endgroup::")
+
+	file, inWorkspace := workspacePath(result.PolicyFile)
+	for _, f := range result.Findings {
+		if f.Suppressed {
+			continue
+		}
+		params := []string{"title=" + ghaProperty(result.Domain+": "+f.Code)}
+		if inWorkspace {
+			params = append(params, "file="+ghaProperty(file))
+			if f.Line > 0 {
+				params = append(params, fmt.Sprintf("line=%d", f.Line))
+			}
+		}
+		message := f.Message
+		if f.Subject != "" {
+			message = f.Subject + ": " + message
+		}
+		if f.Hint != "" {
+			message += "\nHint: " + f.Hint
+		}
+		fmt.Printf("::%s %s::%s\n", ghaCommands[f.Severity], strings.Join(params, ","), ghaData(message))
+	}
+	fmt.Println(statusLine(result))
+	fmt.Println(result.Verdict.line(result.Domain))
+}
+
+// workspacePath returns path relative to the workspace of the workflow run,
+// or the working directory outside one, and whether it lies inside it.
+// Annotations only attach to files of the checked out repository.
+func workspacePath(path string) (string, bool) {
+	if path == "" {
+		return "", false
+	}
+	root := os.Getenv("GITHUB_WORKSPACE")
+	if root == "" {
+		root = "."
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// ghaData escapes the message of a workflow command.
+func ghaData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// ghaProperty escapes a parameter value of a workflow command.
+func ghaProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// getFieldMain prints the value of one policy key of domain, or of the
+// local policy file, for use in shell scripts: the value alone on stdout,
+// one line per value for mx, and everything else on stderr. It exits 1
+// when the policy can't be had or doesn't have the key.
+func getFieldMain(domain string, policyFile string, field string, opts *options) int {
+	var policy string
+	if policyFile != "" {
+		data, err := ioutil.ReadFile(policyFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		policy, _ = stripAnnotations(string(data))
+	} else {
+		domain, _ = mailDomainOf(domain)
+		url := "https://mta-sts." + domain + "/.well-known/mta-sts.txt"
+		response, _, err := queryHTTPSRecord(url, opts.retries)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Fetching %s failed: %v\n", url, err)
+			return 1
+		}
+		policy = response.Body
+	}
+
+	// The colon keeps mode from matching a key like modes.
+	rows := strings.Split(policy, "\n")
+	var values []string
+	if field == "mx" {
+		for _, value := range valuesForKey(rows, field+":") {
+			if value != "" {
+				values = append(values, value)
+			}
+		}
+	} else if value := valueForKey(rows, field+":"); value != "" {
+		values = append(values, value)
+	}
+	if len(values) == 0 {
+		fmt.Fprintf(os.Stderr, "The policy has no %s\n", field)
+		return 1
+	}
+	fmt.Println(strings.Join(values, "\n"))
+	return 0
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+	"time"
+)
+
+// hintTemplates holds a concrete next step for each finding code. The
+// templates are executed with a hintData built from the run.
+var hintTemplates = map[string]string{
+	"DOMAIN-IS-POLICY-HOST":         "pass the mail domain, -domain {{.Domain}}",
+	"MX-LOOKUP-FAILED":              "check that {{.Domain}} publishes MX records and that they resolve",
+	"DNS-TIMEOUT":                   "check that the authoritative nameservers of {{.Domain}} answer promptly, or raise -timeout-dns",
+	"MX-NAME-INVALID":               "fix the MX records of {{.Domain}} so they point at a valid host name",
+	"MX-IP-LITERAL":                 "replace {{.Subject}} in the MX records of {{.Domain}} with a host name that has an A record for the address, like mx.{{.Domain}}",
+	"MX-POINTS-TO-CNAME":            "point the MX record at the canonical host name, or keep {{.Subject}} in the certificate and the policy mx patterns",
+	"SMTP-CONNECT-FAILED":           "make sure {{.Subject}} accepts connections on port 25 from the internet",
+	"SMTP-CONNECTION-REFUSED":       "start the MTA on {{.Subject}} or make it listen on port 25 on every address the MX resolves to",
+	"SMTP-CONNECT-TIMEOUT":          "run StrictMTATest doctor to see whether outbound port 25 is blocked from here; if it isn't, open port 25 to {{.Subject}} in its firewall",
+	"SMTP-UNREACHABLE":              "check the routing to {{.Subject}}, and for IPv6 addresses whether this machine has IPv6 connectivity",
+	"SMTP-IPV6-UNTESTABLE":          "run the validation again from a machine with IPv6 connectivity to check {{.Subject}}",
+	"SMTP-NO-GREETING":              "check the MTA on {{.Subject}}: it must greet promptly, and a tarpit must not apply to every client",
+	"STARTTLS-FAILED":               "enable STARTTLS on {{.Subject}} with a certificate from a publicly trusted CA",
+	"CHAIN-OUT-OF-ORDER":            "serve the leaf certificate first, followed by each intermediate in order, like the fullchain.pem of most ACME clients",
+	"CHAIN-EXTRANEOUS-CERT":         "remove the certificates that are not part of the chain from the bundle {{.Subject}} serves",
+	"CHAIN-ROOT-INCLUDED":           "drop the root certificate from the bundle {{.Subject}} serves",
+	"SMTP-NAME-MISMATCH":            "configure {{.Subject}} to announce the name it is published under and make sure its certificate covers that name",
+	"SMTP-ANTI-PIPELINING":          "relax the greeting delay or pipelining checks on {{.Subject}} for clients that wait for each reply",
+	"SMTP-PRE-TLS-DELAY":            "if the pause helped, look at the connection rate and timing defenses of {{.Subject}}",
+	"TLS-VERSION-LOW":               "enable TLS 1.2 and 1.3 on {{.Subject}} and disable older protocol versions",
+	"TLS-RESUMPTION-FAILED":         "check the TLS terminator in front of {{.Subject}} for bugs handling session tickets or resumed handshakes",
+	"TLS-RESUMPTION-UNSUPPORTED":    "enable TLS session tickets on {{.Subject}} if it receives many connections",
+	"CERT-MISSING":                  "configure a certificate for {{.Subject}} on the SMTP listener",
+	"CERT-EXPIRED":                  "renew the certificate for {{.Subject}}",
+	"CERT-EXPIRING":                 "renew the certificate for {{.Subject}} before it expires",
+	"CERT-PIN-MISMATCH":             "if {{.Subject}} was rotated on purpose add the new fingerprint to the pins, otherwise investigate the connection",
+	"CERT-SHARED":                   "track the expiry of this certificate closely or give the MX hosts certificates that expire at different times",
+	"CERT-WILDCARD":                 "consider certificates for the individual MX names to limit where the key is deployed",
+	"CERT-CHAIN-INVALID":            "install a certificate for {{.Subject}} from a publicly trusted CA and serve the full intermediate chain",
+	"CERT-HOSTNAME-MISMATCH":        "reissue the certificate for {{.Subject}} or add it to the SAN list",
+	"STS-TXT-MISSING":               `publish the TXT record: _mta-sts.{{.Domain}}. IN TXT "v=STSv1; id={{.ID}}"`,
+	"STS-TXT-LOOKUP-FAILED":         "check that the nameservers for {{.Domain}} answer TXT queries for {{.Subject}}",
+	"STS-TXT-INVALID":               `publish a well-formed record: {{stsRecord .Domain .ID}}`,
+	"STS-TXT-MULTIPLE":              `keep a single record at _mta-sts.{{.Domain}}: {{stsRecord .Domain .ID}}`,
+	"TXT-DUPLICATE-FIELD":           `publish the record with each field once: {{stsRecord .Domain .ID}}`,
+	"STS-TXT-POLICY-KEY":            `move the setting to the policy file and publish only v and id: {{stsRecord .Domain .ID}}`,
+	"STS-TXT-UNKNOWN-KEY":           "remove the field from the _mta-sts.{{.Domain}} record unless a sender you care about uses it",
+	"STS-NS-LOOKUP-FAILED":          "check that NS records for {{.Domain}} resolve",
+	"STS-DNS-TRUNCATED":             "remove stale or oversized TXT records at {{.Subject}} so the answer fits in UDP, and make sure the nameservers answer over TCP",
+	"STS-DNS-RESPONSE-LARGE":        "remove unrelated or duplicate TXT records at {{.Subject}} to keep the answer under 512 bytes",
+	"POLICY-CHANGED-ID-UNCHANGED":   `publish a new id so senders refetch the policy: {{stsRecord .Domain .ID}}`,
+	"STS-NS-INCONSISTENT":           "wait for the zone to propagate or check zone transfers to the lagging nameservers",
+	"POLICY-HOST-UNREACHABLE":       "set up a web server on {{.Subject}} that accepts HTTPS on port 443 from the internet and serves https://{{.Subject}}/.well-known/mta-sts.txt",
+	"POLICY-NOT-LINE-DELIMITED":     "serve the policy as a text file with one key: value pair per line, each ending in LF or CRLF",
+	"POLICY-LINE-INVALID":           "fix or remove the line {{.Subject}} so every line of the policy reads key: value",
+	"POLICY-MIXED-LINE-ENDINGS":     "save the policy with one kind of line ending throughout, LF or CRLF",
+	"POLICY-FETCH-FAILED":           "serve the policy at https://mta-sts.{{.Domain}}/.well-known/mta-sts.txt with a valid certificate for mta-sts.{{.Domain}}",
+	"POLICY-REDIRECT":               "serve the file directly at /.well-known/mta-sts.txt; conforming senders do not follow redirects",
+	"DEPLOYMENT-POLICY-WITHOUT-DNS": `publish the TXT record: {{stsRecord .Domain .ID}}`,
+	"DEPLOYMENT-DNS-WITHOUT-POLICY": "either serve the policy at https://mta-sts.{{.Domain}}/.well-known/mta-sts.txt or remove the _mta-sts.{{.Domain}} TXT record until it is",
+	"POLICY-TLS-RETRIED":            "check every edge node serving {{.Subject}} for failing handshakes, stale session ticket keys or an old certificate",
+	"POLICY-CONTENT-ENCODED":        "disable compression for /.well-known/mta-sts.txt on the web server or CDN and serve it as text/plain",
+	"POLICYHOST-SLOW":               "serve mta-sts.txt as a static file from {{.Subject}} or put it behind a CDN",
+	"POLICY-CERT-NAME-MISMATCH":     "install a certificate for {{.Subject}} on the policy host; on shared hosting make sure the name is added to the site so SNI selects it",
+	"POLICY-FAMILY-FETCH-FAILED":    "make sure every A and AAAA address of {{.Subject}} serves the policy over HTTPS",
+	"POLICY-RECHECK-FAILED":         "check the health of every backend behind {{.Subject}}",
+	"POLICY-ORIGIN-MISMATCH":        "purge the CDN cache for https://{{.Subject}}/.well-known/mta-sts.txt and make sure the CDN passes the body through unchanged",
+	"POLICY-ORIGIN-FETCH-FAILED":    "check that the -policy-origin address is reachable from here and serves {{.Subject}} over HTTPS",
+	"POLICY-INCONSISTENT":           "deploy the same mta-sts.txt to every backend behind {{.Subject}} and purge any CDN cache",
+	"POLICY-DUAL-STACK-MISMATCH":    "deploy the same mta-sts.txt to the IPv4 and IPv6 backends of {{.Subject}}",
+	"POLICY-VERSION-MISSING":        `add the line "version: STSv1" to the policy`,
+	"POLICY-VERSION-INVALID":        `set the first line of the policy to "version: STSv1"`,
+	"POLICY-MODE-INVALID":           `set "mode:" to one of enforce, testing or none (enforce, report or none with -spec draft10)`,
+	"POLICY-MODE-DEPRECATED":        `replace "mode: report" with "mode: testing", then publish a new id: {{stsRecord .Domain .ID}}`,
+	"POLICY-MAX-AGE-MISSING":        `add a max_age line, e.g. "max_age: 604800" (one week)`,
+	"POLICY-MAX-AGE-INVALID":        `set max_age to a number of seconds no larger than 31557600, e.g. "max_age: 604800" (one week)`,
+	"POLICY-UNKNOWN-KEY":            "remove {{.Subject}} from the policy or correct its spelling",
+	"POLICY-MX-DUPLICATE":           "remove the repeated mx lines from the policy",
+	"POLICY-PROFILE-DEVIATION":      "add, remove or merge the {{.Subject}} lines of the policy as the message says",
+	"POLICY-MX-TRAILING-DOT":        "remove the trailing dot from \"mx: {{.Subject}}\"",
+	"POLICY-MX-WILDCARD-SYNTAX":     "rewrite the wildcard in the syntax of the spec your senders implement, see the message",
+	"STS-MX-UNDECLARED":             `add "mx: {{.Subject}}" (or a wildcard covering it) to the policy, then publish a new id: {{stsRecord .Domain .ID}}`,
+	"POLICY-VALUE-EMPTY":            "give {{.Subject}} a value or remove the line from the policy",
+	"POLICY-MX-INVALID-SYNTAX":      "replace \"mx: {{.Subject}}\" with the host name of the MX server, without scheme, port or address",
+	"IDNA-INVALID":                  "write {{.Subject}} in its A-label (xn--) form or correct the misspelled label",
+	"POLICY-MX-UNUSED":              "remove \"mx: {{.Subject}}\" from the policy if it is no longer used, then publish a new id",
+	"ANNOTATION-UNKNOWN-CODE":       "correct the code in the mtasts-ignore comment at {{.Subject}} or remove it",
+	"TLSRPT-MISSING":                `publish the TXT record: {{.Subject}}. IN TXT "v=TLSRPTv1; rua=mailto:tlsrpt@{{.Domain}}"`,
+	"TLSRPT-LOOKUP-FAILED":          "check that the nameservers for {{.Domain}} answer TXT queries for {{.Subject}}",
+}
+
+// hintData is what hint templates can refer to.
+type hintData struct {
+	Domain  string
+	Subject string
+	ID      string
+}
+
+// stsRecord is the _mta-sts TXT record to publish for domain, in zone file
+// syntax.
+func stsRecord(domain string, id string) string {
+	return fmt.Sprintf(`_mta-sts.%s. IN TXT "v=STSv1; id=%s"`, domain, id)
+}
+
+var hintFuncs = template.FuncMap{"stsRecord": stsRecord}
+
+// generateID returns a fresh policy id suitable for the _mta-sts TXT record.
+func generateID() string {
+	return time.Now().UTC().Format("20060102150405")
+}
+
+// addHints fills in the remediation hint of every finding that has one.
+// A single id is generated per run so all hints agree.
+func addHints(result *Result) {
+	id := generateID()
+	for i, finding := range result.Findings {
+		text, ok := hintTemplates[finding.Code]
+		if !ok {
+			continue
+		}
+		tmpl, err := template.New(finding.Code).Funcs(hintFuncs).Parse(text)
+		if err != nil {
+			continue
+		}
+		var buf bytes.Buffer
+		data := hintData{Domain: result.Domain, Subject: finding.Subject, ID: id}
+		if tmpl.Execute(&buf, data) == nil {
+			result.Findings[i].Hint = buf.String()
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DNS limits from RFC 1035 §2.3.4.
+const (
+	maxHostnameLength = 253
+	maxLabelLength    = 63
+)
+
+// hostnameLengthError describes which DNS length limit host breaks, or
+// returns "" when it is within the limits.
+func hostnameLengthError(host string) string {
+	name := strings.TrimSuffix(host, ".")
+	if len(name) > maxHostnameLength {
+		return fmt.Sprintf("name is %d characters, longer than the %d character limit", len(name), maxHostnameLength)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) > maxLabelLength {
+			return fmt.Sprintf("label %q is %d characters, longer than the %d character label limit", shorten(label, 20), len(label), maxLabelLength)
+		}
+		if label == "" {
+			return "name contains an empty label"
+		}
+	}
+	return ""
+}
+
+// isIPLiteral reports whether host is an IP address rather than a name,
+// bare or in the brackets of an SMTP address literal.
+func isIPLiteral(host string) bool {
+	name := strings.TrimSuffix(host, ".")
+	if strings.HasPrefix(name, "[") && strings.HasSuffix(name, "]") {
+		name = name[1 : len(name)-1]
+		// The tag is case-insensitive, and the MX names arrive lowercased.
+		if len(name) > 5 && strings.EqualFold(name[:5], "IPv6:") {
+			name = name[5:]
+		}
+	}
+	return net.ParseIP(name) != nil
+}
+
+// policyHostPrefixes are the labels that turn a mail domain into the name
+// of its policy host or STS record.
+var policyHostPrefixes = []string{"mta-sts.", "_mta-sts."}
+
+// mailDomainOf returns the mail domain when domain is the policy host or STS
+// record name of one, like example.com for mta-sts.example.com, and whether
+// it stripped a prefix. A prefix followed by a bare TLD is left alone. An
+// internationalized domain is returned in A-label form, which the lookups,
+// the policy URL and its SNI all need.
+func mailDomainOf(domain string) (string, bool) {
+	name := normalizeDomain(domain)
+	for _, prefix := range policyHostPrefixes {
+		if rest := strings.TrimPrefix(name, prefix); rest != name && strings.Contains(rest, ".") {
+			return rest, true
+		}
+	}
+	if !isASCII(domain) {
+		if ascii, err := toASCII(domain); err == nil {
+			return ascii, false
+		}
+	}
+	return domain, false
+}
+
+// shorten truncates s to n characters for display.
+func shorten(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHostnameLengthError(t *testing.T) {
+	label63 := strings.Repeat("a", 63)
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"mx1.example.com", ""},
+		{"mx1.example.com.", ""},
+		{label63 + ".example.com", ""},
+		{strings.Repeat("a", 64) + ".example.com",
+			`label "aaaaaaaaaaaaaaaaaaaa..." is 64 characters, longer than the 63 character label limit`},
+		{strings.Repeat(label63+".", 3) + strings.Repeat("b", 61), ""},
+		{strings.Repeat(label63+".", 3) + strings.Repeat("b", 62),
+			"name is 254 characters, longer than the 253 character limit"},
+		{"mx1..example.com", "name contains an empty label"},
+	}
+	for _, test := range tests {
+		if got := hostnameLengthError(test.host); got != test.want {
+			t.Errorf("hostnameLengthError(%q) = %q, want %q", shorten(test.host, 40), got, test.want)
+		}
+	}
+}
+
+func TestOverlongMXNotProbed(t *testing.T) {
+	long := strings.Repeat("x", 70) + ".example.com"
+	useResolver(t, &fakeResolver{mx: map[string][]*net.MX{"example.com": {{Host: long + ".", Pref: 10}}}})
+
+	result, names := mailPhase("example.com", &options{})
+	findings := findingsOf(result, "MX-NAME-INVALID")
+	if len(findings) != 1 || !strings.Contains(findings[0].Message, "70 characters, longer than the 63 character label limit") {
+		t.Errorf("MX-NAME-INVALID = %v, want one about the 70 character label", findings)
+	}
+	if len(result.MX) != 0 {
+		t.Errorf("the over-long MX was probed: %v", result.MX)
+	}
+	if len(names) != 1 || names[0] != long {
+		t.Errorf("mailPhase names = %q, want the over-long host for the coverage check", names)
+	}
+}
+
+func TestMailDomainOf(t *testing.T) {
+	tests := []struct {
+		domain   string
+		want     string
+		stripped bool
+	}{
+		{"example.com", "example.com", false},
+		{"mta-sts.example.com", "example.com", true},
+		{"MTA-STS.Example.com.", "example.com", true},
+		{"_mta-sts.example.com", "example.com", true},
+		{"mta-sts.mail.example.co.uk", "mail.example.co.uk", true},
+		{"mta-sts.com", "mta-sts.com", false},
+		{"mta-stsexample.com", "mta-stsexample.com", false},
+		{"mail.mta-sts.example.com", "mail.mta-sts.example.com", false},
+	}
+	for _, test := range tests {
+		got, stripped := mailDomainOf(test.domain)
+		if got != test.want || stripped != test.stripped {
+			t.Errorf("mailDomainOf(%q) = %q, %v, want %q, %v", test.domain, got, stripped, test.want, test.stripped)
+		}
+	}
+}
+
+func TestValidatePolicyHost(t *testing.T) {
+	// .invalid never resolves, so the policy fetch fails at once.
+	useResolver(t, &fakeResolver{txt: map[string][]string{"_mta-sts.example.invalid": {"v=STSv1; id=20240101"}}})
+
+	result := validate("mta-sts.example.invalid", &options{spec: specRFC8461})
+	if result.Domain != "example.invalid" || result.RequestedDomain != "mta-sts.example.invalid" {
+		t.Errorf("Domain = %q, RequestedDomain = %q, want the mail domain validated", result.Domain, result.RequestedDomain)
+	}
+	if result.STSRecord != "v=STSv1; id=20240101" || result.PolicyHost != "mta-sts.example.invalid" {
+		t.Errorf("STSRecord = %q, PolicyHost = %q, want those of the mail domain", result.STSRecord, result.PolicyHost)
+	}
+	want := "mta-sts.example.invalid is the policy host or record name of example.invalid, not a mail domain; validated example.invalid instead"
+	if got := messagesOf(result, "DOMAIN-IS-POLICY-HOST"); len(got) != 1 || got[0] != want {
+		t.Errorf("DOMAIN-IS-POLICY-HOST = %q, want %q", got, want)
+	}
+}
+
+func TestIDNPolicyHost(t *testing.T) {
+	const aLabel = "mta-sts.xn--80a1acny.invalid"
+	useResolver(t, &fakeResolver{txt: map[string][]string{"_mta-sts.xn--80a1acny.invalid": {"v=STSv1; id=20240101"}}})
+
+	cert := testCertificate(t, time.Now().Add(24*time.Hour), aLabel)
+	var serverName, hostHeader, dialed string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hostHeader = r.Host
+		w.Write([]byte(policyOf("version: STSv1", "mode: testing", "mx: mail.xn--80a1acny.invalid", "max_age: 86400")))
+	}))
+	server.TLS = &tls.Config{GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		serverName = hello.ServerName
+		return &cert, nil
+	}}
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	// Every dial of the policy client reaches the server, whatever the name.
+	transport := policyClient.Transport.(*http.Transport)
+	savedDial := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = addr
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+	savedPool, savedSource := rootPool, rootSource
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	useRoots(pool, "test")
+	t.Cleanup(func() {
+		transport.DialContext = savedDial
+		transport.CloseIdleConnections()
+		useRoots(savedPool, savedSource)
+	})
+
+	result := validate("почта.invalid", &options{spec: specRFC8461})
+	if result.Domain != "xn--80a1acny.invalid" || result.PolicyHost != aLabel || result.PolicyHostUnicode != "mta-sts.почта.invalid" {
+		t.Errorf("Domain %q, PolicyHost %q, PolicyHostUnicode %q, want the A-label validated and both forms of the policy host",
+			result.Domain, result.PolicyHost, result.PolicyHostUnicode)
+	}
+	if dialed != aLabel+":443" || serverName != aLabel || hostHeader != aLabel {
+		t.Errorf("dialed %q with SNI %q and Host %q, want the A-label for all three", dialed, serverName, hostHeader)
+	}
+	if result.Policy == "" || result.PolicyCert == nil || result.PolicyCert.HostnameError != "" || result.PolicyCert.ChainError != "" {
+		t.Errorf("policy %q, certificate %+v, want the policy fetched from a host whose certificate matches the A-label", result.Policy, result.PolicyCert)
+	}
+
+	ascii := validate("example.invalid", &options{spec: specRFC8461})
+	if ascii.PolicyHost != "mta-sts.example.invalid" || ascii.PolicyHostUnicode != "" {
+		t.Errorf("PolicyHost %q, PolicyHostUnicode %q, want no second form for an ASCII domain", ascii.PolicyHost, ascii.PolicyHostUnicode)
+	}
+}
+
+func TestIsIPLiteral(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"192.0.2.25", true},
+		{"192.0.2.25.", true},
+		{"[192.0.2.25]", true},
+		{"2001:db8::25", true},
+		{"[2001:db8::25]", true},
+		{"[IPv6:2001:db8::25]", true},
+		{"[ipv6:2001:db8::25]", true},
+		{"mx.example.com", false},
+		{"192.0.2.25.example.com", false},
+		{"25.2.0.192.in-addr.arpa", false},
+		{"192.0.2", false},
+		{"[mx.example.com]", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := isIPLiteral(test.host); got != test.want {
+			t.Errorf("isIPLiteral(%q) = %v, want %v", test.host, got, test.want)
+		}
+	}
+}
+
+func TestMXIPLiteral(t *testing.T) {
+	tests := []struct {
+		name string
+		host string
+	}{
+		{"IPv4", "192.0.2.25."},
+		{"IPv4 in brackets", "[192.0.2.25]."},
+		{"IPv6", "2001:db8::25."},
+		{"IPv6 address literal", "[IPv6:2001:db8::25]."},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useResolver(t, &fakeResolver{mx: map[string][]*net.MX{"example.com": {{Host: test.host, Pref: 10}}}})
+
+			result, names := mailPhase("example.com", &options{})
+			want := "MX host must be a host name, not an IP address: " + normalizeDomain(test.host)
+			if got := messagesOf(result, "MX-IP-LITERAL"); len(got) != 1 || got[0] != want {
+				t.Errorf("MX-IP-LITERAL = %q, want %q", got, want)
+			}
+			if len(result.MX) != 0 || len(names) != 0 {
+				t.Errorf("the address was probed or kept for the coverage check: %v, %q", result.MX, names)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// idChange compares the policy and the _mta-sts id with those of the
+// previous run of the domain. Senders only refetch a cached policy when
+// the id changes, so a policy that changed under the same id stays unseen
+// until the cached copy expires after max_age.
+type idChange struct {
+	PreviousRun   time.Time `json:"previous_run"`
+	PreviousID    string    `json:"previous_id"`
+	CurrentID     string    `json:"current_id"`
+	IDChanged     bool      `json:"id_changed"`
+	PolicyChanged bool      `json:"policy_changed"`
+	ChangedKeys   []string  `json:"changed_keys,omitempty"`
+}
+
+// recordID is the id of an STS record, the first one when it repeats.
+func recordID(record string) string {
+	for _, field := range strings.Split(record, ";") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(field), "="); ok && key == "id" {
+			return value
+		}
+	}
+	return ""
+}
+
+// changedKeys lists the keys whose values differ between two canonical
+// policies.
+func changedKeys(before string, after string) []string {
+	values := func(policy string) map[string]string {
+		m := make(map[string]string)
+		for _, line := range strings.Split(policy, "\n") {
+			if key, value, ok := strings.Cut(line, ": "); ok {
+				m[key] += value + "\n"
+			}
+		}
+		return m
+	}
+	a, b := values(before), values(after)
+	var keys []string
+	for key := range a {
+		if a[key] != b[key] {
+			keys = append(keys, key)
+		}
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// verifyIDChange compares result with previous, the summary of the last
+// run, nil when there is none. The policy of previous is the first one
+// seen with its id, so a policy changed under the same id fails on every
+// run until the id changes. Policies are compared in canonical form, so
+// reformatting a policy does not count as a change.
+func verifyIDChange(result *Result, previous *runSummary) {
+	id := recordID(result.STSRecord)
+	switch {
+	case previous == nil:
+		result.skipCheck(checkIDChange, "no previous run of %s", result.Domain)
+		return
+	case previous.STSID == "" || previous.Policy == "":
+		result.skipCheck(checkIDChange, "the previous run found no STS record or no policy")
+		return
+	case id == "" || result.Policy == "":
+		result.skipCheck(checkIDChange, "no STS record or no policy")
+		return
+	}
+
+	mark := result.beginCheck()
+	defer func() { result.endCheck(checkIDChange, mark) }()
+	policy := canonicalPolicy(result.Policy)
+	change := &idChange{PreviousRun: previous.Checked, PreviousID: previous.STSID, CurrentID: id,
+		IDChanged: id != previous.STSID, PolicyChanged: policy != previous.Policy}
+	change.ChangedKeys = changedKeys(previous.Policy, policy)
+	result.IDChange = change
+
+	name := "_mta-sts." + result.Domain
+	since := previous.Checked.Format("2006-01-02 15:04 MST")
+	switch {
+	case change.PolicyChanged && !change.IDChanged:
+		// Caches written before policy_since have only the time of the run.
+		seen := previous.PolicySince
+		if seen.IsZero() {
+			seen = previous.Checked
+		}
+		result.errorf("POLICY-CHANGED-ID-UNCHANGED", name, "the policy changed since the run of %s (%s) but the id is still %s; senders with a cached policy keep the old one until it expires",
+			seen.Format("2006-01-02 15:04 MST"), strings.Join(change.ChangedKeys, ", "), id)
+	case change.PolicyChanged:
+		result.infof("STS-ID-CHANGED", name, "the id changed from %s to %s since the run of %s, together with the policy (%s)",
+			previous.STSID, id, since, strings.Join(change.ChangedKeys, ", "))
+	case change.IDChanged:
+		result.infof("STS-ID-CHANGED", name, "the id changed from %s to %s since the run of %s but the policy did not; senders refetch an identical policy",
+			previous.STSID, id, since)
+	}
+}
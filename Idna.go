@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Internationalized host names can appear either as U-labels (почта) or as
+// their A-label form (xn--80a1acny). Policies are written by hand and often
+// use the former while DNS always returns the latter, so names are compared
+// in A-label form. The conversion is the UTS #46 lookup processing senders
+// use, which also maps compatibility forms, so fullwidth ａｂｃ is abc, and
+// normalizes to NFC. It is applied per label; wildcard labels are left
+// alone.
+
+const acePrefix = "xn--"
+
+// toASCII converts every U-label of name to its A-label form and lowercases
+// the rest. An error names the label that cannot be converted.
+func toASCII(name string) (string, error) {
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if label == "" || label == "*" {
+			continue
+		}
+		lower := strings.ToLower(label)
+		if isASCII(label) && !strings.HasPrefix(lower, acePrefix) {
+			// Plain labels are taken as they are, including the
+			// underscores of service names like _mta-sts.
+			labels[i] = lower
+			continue
+		}
+		encoded, err := idna.Lookup.ToASCII(label)
+		if err != nil {
+			if isASCII(label) {
+				return name, fmt.Errorf("label %q is not a valid A-label: %v", label, err)
+			}
+			return name, fmt.Errorf("label %q is not allowed in a host name: %v", label, err)
+		}
+		if len(encoded) > maxLabelLength {
+			return name, fmt.Errorf("label %q is %d characters in A-label form, longer than the %d character label limit", label, len(encoded), maxLabelLength)
+		}
+		labels[i] = encoded
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// toUnicode converts every A-label of name to its U-label form for display.
+// Labels that don't decode are kept as they are.
+func toUnicode(name string) string {
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if !strings.HasPrefix(strings.ToLower(label), acePrefix) {
+			continue
+		}
+		if decoded, err := idna.Lookup.ToUnicode(label); err == nil {
+			labels[i] = decoded
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+// displayName shows name in both forms when it is internationalized, like
+// "xn--80a1acny.example (почта.example)", so operators recognize their own
+// names whichever form the tool happened to see.
+func displayName(name string) string {
+	ascii, err := toASCII(name)
+	if err != nil {
+		return name
+	}
+	unicodeForm := toUnicode(ascii)
+	if unicodeForm == ascii {
+		return name
+	}
+	return ascii + " (" + unicodeForm + ")"
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+func TestToASCII(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"mx1.example.com", "mx1.example.com", false},
+		{"MX1.Example.COM", "mx1.example.com", false},
+		{"_mta-sts.example.com", "_mta-sts.example.com", false},
+		{"mail.почта.example", "mail.xn--80a1acny.example", false},
+		{"*.почта.example", "*.xn--80a1acny.example", false},
+		{"ａｂｃ.com", "abc.com", false},
+		{"ＭＸ.example.com", "mx.example.com", false},
+		{"b\u00fccher.example", "xn--bcher-kva.example", false},
+		{"bu\u0308cher.example", "xn--bcher-kva.example", false},
+		{"mail.xn--80a1acny.example", "mail.xn--80a1acny.example", false},
+		{"XN--80A1ACNY.example", "xn--80a1acny.example", false},
+		{"xn--zz-.example", "", true},
+		{"mail x.example", "", true},
+	}
+	for _, test := range tests {
+		got, err := toASCII(test.name)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("toASCII(%q) = %q, want an error", test.name, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("toASCII(%q) = %q, %v, want %q", test.name, got, err, test.want)
+		}
+	}
+}
+
+func TestIDNAMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		host    string
+		want    bool
+	}{
+		{"mail.почта.example", "mail.xn--80a1acny.example.", true},
+		{"mail.xn--80a1acny.example", "mail.почта.example", true},
+		{"*.почта.example", "mx1.xn--80a1acny.example", true},
+		{"ａｂｃ.com", "abc.com", true},
+		{"mail.почта.example", "mail.xn--80a1acnz.example", false},
+	}
+	for _, test := range tests {
+		if got := mxHasMatch(specRFC8461, []string{test.pattern}, test.host); got != test.want {
+			t.Errorf("mxHasMatch(%q, %q) = %v, want %v", test.pattern, test.host, got, test.want)
+		}
+	}
+}
+
+func TestDisplayName(t *testing.T) {
+	tests := []struct{ name, want string }{
+		{"mx1.example.com", "mx1.example.com"},
+		{"xn--80a1acny.example", "xn--80a1acny.example (почта.example)"},
+		{"почта.example", "xn--80a1acny.example (почта.example)"},
+	}
+	for _, test := range tests {
+		if got := displayName(test.name); got != test.want {
+			t.Errorf("displayName(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// The prefix of an inline suppression in a local policy file, like
+//
+//	# mtasts-ignore: POLICY-UNKNOWN-KEY, POLICY-MX-DUPLICATE
+const ignoreAnnotation = "mtasts-ignore:"
+
+// policyAnnotations are the finding codes suppressed inline, mapped to the
+// line they were declared on.
+type policyAnnotations map[string]int
+
+// stripAnnotations removes comment lines from a local policy file and
+// collects the mtasts-ignore annotations among them. Comments are not part
+// of the policy format, so they would otherwise be reported as keys.
+func stripAnnotations(content string) (string, policyAnnotations) {
+	annotations := make(policyAnnotations)
+	var rows []string
+	for i, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "#") {
+			rows = append(rows, line)
+			continue
+		}
+		comment := strings.TrimSpace(strings.TrimPrefix(trimmed, "#"))
+		if !strings.HasPrefix(comment, ignoreAnnotation) {
+			continue
+		}
+		for _, code := range strings.Split(strings.TrimPrefix(comment, ignoreAnnotation), ",") {
+			code = strings.ToUpper(strings.Replace(strings.TrimSpace(code), "_", "-", -1))
+			if code != "" {
+				annotations[code] = i + 1
+			}
+		}
+	}
+	return strings.Join(rows, "\n"), annotations
+}
+
+// findingLine returns the line of the policy file a finding is about, or 0
+// when it isn't about one line. Findings on version, mode and max_age are
+// found by their code, unknown keys and mx values by their subject.
+func findingLine(content string, f Finding) int {
+	key, value, wantEmpty := "", "", false
+	switch {
+	case strings.HasPrefix(f.Code, "POLICY-VERSION-"):
+		key = "version"
+	case strings.HasPrefix(f.Code, "POLICY-MODE-"):
+		key = "mode"
+	case strings.HasPrefix(f.Code, "POLICY-MAX-AGE-"):
+		key = "max_age"
+	case f.Code == "POLICY-UNKNOWN-KEY":
+		key = f.Subject
+	case f.Code == "POLICY-VALUE-EMPTY":
+		key, wantEmpty = f.Subject, true
+	case f.Code == "POLICY-LINE-INVALID":
+		for i, line := range strings.Split(content, "\n") {
+			if shorten(strings.TrimSpace(line), 64) == f.Subject {
+				return i + 1
+			}
+		}
+		return 0
+	case (strings.HasPrefix(f.Code, "POLICY-MX-") || f.Code == "IDNA-INVALID") && f.Subject != "":
+		key, value = "mx", f.Subject
+	default:
+		return 0
+	}
+	for i, line := range strings.Split(content, "\n") {
+		colon := strings.Index(line, ":")
+		if colon < 0 || strings.TrimSpace(line[:colon]) != key {
+			continue
+		}
+		v := strings.TrimSpace(line[colon+1:])
+		if (wantEmpty && v == "") || (!wantEmpty && (value == "" || v == value)) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// applyAnnotations suppresses the findings named by inline annotations and
+// reports annotations naming codes the tool doesn't know.
+func applyAnnotations(result *Result, path string, annotations policyAnnotations) {
+	for i, finding := range result.Findings {
+		if line, ok := annotations[finding.Code]; ok {
+			result.Findings[i].Suppressed = true
+			result.Findings[i].SuppressedBy = fmt.Sprintf("annotation on line %d", line)
+		}
+	}
+	for code, line := range annotations {
+		if _, ok := findingCodes[code]; !ok {
+			result.warnf("ANNOTATION-UNKNOWN-CODE", fmt.Sprintf("%s:%d", path, line), "mtasts-ignore names unknown finding code %s", code)
+		}
+	}
+}
+
+// lintPolicyMain validates a local policy file without any DNS, HTTPS or
+// SMTP work. Inline mtasts-ignore annotations are honored here only, never
+// for live validation.
+func lintPolicyMain(path string, opts *options) int {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	result := &Result{Domain: path, Spec: opts.spec, PolicyFile: path}
+	body, annotations := stripAnnotations(string(data))
+	result.Policy = body
+	for _, name := range allChecks {
+		switch name {
+		case checkSyntax:
+			validatePolicy(result, nil)
+		case checkMXCoverage:
+			// Recorded by validatePolicy.
+		case checkProfile:
+			checkPolicyProfile(result, opts.profile)
+		default:
+			result.skipCheck(name, "-policy-file")
+		}
+	}
+	applyAnnotations(result, path, annotations)
+	annotate(result, opts)
+	for i, finding := range result.Findings {
+		result.Findings[i].Line = findingLine(string(data), finding)
+	}
+	// There is no live domain involved, so no deployment state either.
+	result.DeploymentState = ""
+
+	if opts.format == "json" {
+		writeJSON(result)
+	} else if opts.format == formatSARIF {
+		writeJSON(sarifReport([]*Result{result}))
+	} else if opts.format == formatGHA {
+		printGHA(result, func() {
+			fmt.Printf("Linting %s against %s\n", path, specNames[result.Spec])
+			if opts.verbose {
+				printChecks(result)
+			}
+		})
+	} else if isDocumentFormat(opts.format) {
+		fmt.Print(renderDocument(result, opts.format))
+	} else {
+		fmt.Printf("Linting %s against %s\n\n", path, specNames[result.Spec])
+		printFindings(result, opts.verbose)
+		for _, finding := range result.Findings {
+			if strings.HasPrefix(finding.SuppressedBy, "annotation") {
+				fmt.Printf("Suppressed by %s: %s\n", finding.SuppressedBy, finding.Code)
+			}
+		}
+		if opts.verbose {
+			fmt.Println()
+			printChecks(result)
+		}
+		fmt.Println()
+		fmt.Println(statusLine(result))
+		fmt.Println(result.Verdict.line(path))
+	}
+
+	return result.Verdict.exitCode()
+}
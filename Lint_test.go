@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnnotations(t *testing.T) {
+	tests := []struct {
+		name       string
+		annotation string
+		suppressed string // "annotation on line N" or "" when POLICY-UNKNOWN-KEY stays
+		unknown    []string
+	}{
+		{"recognized code", "# mtasts-ignore: POLICY-UNKNOWN-KEY", "annotation on line 1", nil},
+		{"recognized code in another spelling", "#mtasts-ignore: policy_unknown_key", "annotation on line 1", nil},
+		{"one of several codes", "# mtasts-ignore: POLICY-MX-DUPLICATE, POLICY-UNKNOWN-KEY", "annotation on line 1", nil},
+		{"unrecognized code", "# mtasts-ignore: NO-SUCH-CODE", "", []string{"policy.txt:1"}},
+		{"plain comment", "# POLICY-UNKNOWN-KEY is fine here", "", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := strings.Join([]string{test.annotation, "version: STSv1", "mode: enforce", "mx: mx1.example.com", "max_age: 604800", "colour: blue", ""}, "\n")
+			body, annotations := stripAnnotations(content)
+			if strings.Contains(body, "#") {
+				t.Fatalf("comment left in the policy: %q", body)
+			}
+			result := &Result{Domain: "policy.txt", Policy: body}
+			validatePolicy(result, nil)
+			applyAnnotations(result, "policy.txt", annotations)
+
+			findings := findingsOf(result, "POLICY-UNKNOWN-KEY")
+			if len(findings) != 1 {
+				t.Fatalf("%d POLICY-UNKNOWN-KEY findings, want 1", len(findings))
+			}
+			if got := findings[0].SuppressedBy; got != test.suppressed {
+				t.Errorf("SuppressedBy = %q, want %q", got, test.suppressed)
+			}
+			if findings[0].Suppressed != (test.suppressed != "") {
+				t.Errorf("Suppressed = %v, want %v", findings[0].Suppressed, test.suppressed != "")
+			}
+			var unknown []string
+			for _, f := range findingsOf(result, "ANNOTATION-UNKNOWN-CODE") {
+				unknown = append(unknown, f.Subject)
+			}
+			if strings.Join(unknown, ",") != strings.Join(test.unknown, ",") {
+				t.Errorf("ANNOTATION-UNKNOWN-CODE subjects = %q, want %q", unknown, test.unknown)
+			}
+		})
+	}
+}
+
+func TestFindingLine(t *testing.T) {
+	content := "# mtasts-ignore: POLICY-MX-DUPLICATE\nversion: STSv1\nmode: enforce\nmx: mx1.example.com\nmx: mx1.example.com\ncolour:\nmax_age: 604800\n"
+	tests := []struct {
+		finding Finding
+		want    int
+	}{
+		{Finding{Code: "POLICY-MODE-INVALID"}, 3},
+		{Finding{Code: "POLICY-MAX-AGE-INVALID"}, 7},
+		{Finding{Code: "POLICY-MX-TRAILING-DOT", Subject: "mx1.example.com"}, 4},
+		{Finding{Code: "POLICY-VALUE-EMPTY", Subject: "colour"}, 6},
+		{Finding{Code: "TLSRPT-MISSING"}, 0},
+	}
+	for _, test := range tests {
+		if got := findingLine(content, test.finding); got != test.want {
+			t.Errorf("findingLine(%s %q) = %d, want %d", test.finding.Code, test.finding.Subject, got, test.want)
+		}
+	}
+}
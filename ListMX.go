@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// mxInventory is the -list-mx output: the MX hosts of a domain with their
+// addresses and TLS posture, and nothing about MTA-STS.
+type mxInventory struct {
+	Domain string           `json:"domain"`
+	Error  string           `json:"error,omitempty"`
+	MX     []mxInventoryRow `json:"mx"`
+}
+
+type mxInventoryRow struct {
+	Host        string     `json:"host"`
+	Preference  uint16     `json:"preference"`
+	Addresses   []string   `json:"addresses"`
+	StartTLS    bool       `json:"starttls"`
+	TLSVersion  string     `json:"tls_version,omitempty"`
+	CertExpires *time.Time `json:"cert_expires,omitempty"`
+	OK          bool       `json:"ok"`
+	Summary     string     `json:"summary"`
+}
+
+// inventoryRow summarizes the probe of an MX in one line.
+func inventoryRow(host string, preference uint16, mx MXResult) mxInventoryRow {
+	row := mxInventoryRow{Host: host, Preference: preference, Addresses: mx.Candidates, StartTLS: mx.StartTLS, TLSVersion: mx.TLSVersion}
+	if row.Addresses == nil {
+		row.Addresses = []string{}
+	}
+	switch {
+	case !mx.Connected:
+		row.Summary = mx.Status() + ": " + mx.Error
+		return row
+	case !mx.StartTLS:
+		row.Summary = "STARTTLS failed: " + mx.Error
+		return row
+	case mx.Cert == nil:
+		row.Summary = mx.TLSVersion + ", no certificate"
+		return row
+	}
+	expires := mx.Cert.NotAfter
+	row.CertExpires = &expires
+	row.OK = mx.TLSOK
+	var problems []string
+	if mx.Cert.expired() {
+		problems = append(problems, "expired")
+	}
+	if mx.Cert.ChainError != "" {
+		problems = append(problems, "untrusted chain")
+	}
+	if mx.Cert.HostnameError != "" {
+		problems = append(problems, "name mismatch")
+	}
+	row.Summary = fmt.Sprintf("%s, cert expires %s (%d days), ", mx.TLSVersion, expires.Format("2006-01-02"), mx.Cert.daysLeft())
+	if row.OK {
+		row.Summary += "OK"
+	} else {
+		row.Summary += "FAIL: " + strings.Join(problems, ", ")
+	}
+	return row
+}
+
+// listMXMain implements -list-mx. It exits 1 when the MX lookup fails or
+// any MX lacks valid TLS.
+func listMXMain(domain string, opts *options) int {
+	domain, _ = mailDomainOf(domain)
+	inventory := &mxInventory{Domain: domain, MX: []mxInventoryRow{}}
+	code := 0
+	mxs, err := lookupMX(domain)
+	if err != nil {
+		inventory.Error = err.Error()
+		code = 1
+	}
+	for _, mx := range mxs {
+		host := normalizeDomain(mx.Host)
+		row := inventoryRow(host, mx.Pref, tlsTest(host, "25", opts))
+		if !row.OK {
+			code = 1
+		}
+		inventory.MX = append(inventory.MX, row)
+	}
+
+	if opts.format == "json" {
+		writeJSON(inventory)
+		return code
+	}
+	fmt.Printf("MX hosts of %s\n", domain)
+	if inventory.Error != "" {
+		fmt.Printf("MX lookup failed: %s\n", inventory.Error)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, row := range inventory.MX {
+		fmt.Fprintf(w, "\t%d\t%s\t%s\t%s\n", row.Preference, displayName(row.Host), display(strings.Join(row.Addresses, ", ")), row.Summary)
+	}
+	w.Flush()
+	return code
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// directResolver returns a resolver that sends every query to server
+// instead of the system resolver.
+func directResolver(server string) *net.Resolver {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, net.JoinHostPort(server, "53"))
+		},
+	}
+}
+
+// compareNameservers asks each authoritative nameserver of domain for the
+// _mta-sts record directly. Differing answers mean a change hasn't
+// propagated to every nameserver yet.
+func compareNameservers(result *Result, domain string) {
+	mark := result.beginCheck()
+	defer func() { result.endCheck(checkNSConsistency, mark) }()
+
+	ctx, cancel := dnsContext()
+	nameservers, err := net.DefaultResolver.LookupNS(ctx, domain)
+	cancel()
+	if err != nil {
+		result.warnf("STS-NS-LOOKUP-FAILED", domain, "could not look up the nameservers: %v", err)
+		return
+	}
+
+	stsName := "_mta-sts." + domain
+	result.NSRecords = make(map[string]string)
+	for _, ns := range nameservers {
+		host := normalizeDomain(ns.Host)
+		ctx, cancel := dnsContext()
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		cancel()
+		if err != nil || len(addrs) == 0 {
+			result.NSRecords[host] = "error: nameserver does not resolve"
+			continue
+		}
+
+		timeout := 10 * time.Second
+		if dnsTimeout > 0 {
+			timeout = dnsTimeout
+		}
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+		txt, err := directResolver(addrs[0]).LookupTXT(ctx, stsName)
+		cancel()
+		switch {
+		case err != nil && !isNotFound(err):
+			result.NSRecords[host] = "error: " + err.Error()
+		default:
+			result.NSRecords[host] = findSTSRecord(txt)
+		}
+		result.STSResponseSizes = append(result.STSResponseSizes, measureTXTResponse(addrs[0], host, stsName))
+	}
+	checkSTSResponseSize(result, stsName)
+
+	answers := make(map[string][]string)
+	for ns, answer := range result.NSRecords {
+		answers[answer] = append(answers[answer], ns)
+	}
+	if len(answers) > 1 {
+		var parts []string
+		for answer, servers := range answers {
+			sort.Strings(servers)
+			if answer == "" {
+				answer = "no record"
+			}
+			parts = append(parts, strings.Join(servers, ", ")+" answer "+answer)
+		}
+		sort.Strings(parts)
+		result.warnf("STS-NS-INCONSISTENT", stsName, "nameservers disagree on the STS record, propagation is incomplete: %s", strings.Join(parts, "; "))
+	}
+}
+
+func isNotFound(err error) bool {
+	dnsErr, ok := err.(*net.DNSError)
+	return ok && dnsErr.IsNotFound
+}
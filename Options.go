@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// checkFlags are the flags that decide how a domain is checked, as opposed
+// to what is done with the result. The main command and compare both
+// register them, so a comparison takes the same settings with the same
+// defaults as a run of each domain.
+type checkFlags struct {
+	opts        *options
+	ignore      string
+	severityMap string
+	pins        string
+	minTLS      string
+	caFile      string
+}
+
+// addCheckFlags registers the check flags on flags. The options they set
+// are complete once flags is parsed and complete has succeeded.
+func addCheckFlags(flags *flag.FlagSet) *checkFlags {
+	f := &checkFlags{opts: &options{}}
+	opts := f.opts
+	flags.StringVar(&f.severityMap, "severity-map", "", "File of finding codes with the severity each gets instead of the built-in one, one \"CODE error|warning|info\" per line")
+	flags.StringVar(&f.ignore, "ignore", "", "Comma separated finding codes to suppress, like CERT-EXPIRING,TLSRPT-MISSING")
+	flags.BoolVar(&opts.failOnMissingTLSRPT, "fail-on-missing-tlsrpt", false, "Treat a missing TLSRPT record as an error instead of a warning")
+	flags.StringVar(&opts.profile, "profile", "", "Also require the policy to follow this profile, failing on every deviation: "+strings.Join(profileNames(), ", "))
+	flags.BoolVar(&opts.assertNoUnknownKeys, "assert-no-unknown-keys", false, "Treat unknown policy keys as errors that fail the run instead of warnings")
+	flags.IntVar(&opts.certExpiryWarnDays, "warn-cert-expiry-days", certExpiryWarnDays, "Warn when an MX certificate expires in fewer than this many days")
+	flags.IntVar(&opts.certExpiryFailDays, "fail-on-cert-expiry-days", 0, "Fail the run when an MX certificate expires in fewer than this many days, 0 to disable")
+	flags.BoolVar(&opts.checkNSConsistency, "check-ns-consistency", false, "Ask each nameserver of the domain for the _mta-sts record and report disagreement")
+	flags.IntVar(&opts.maxRedirectsShown, "max-redirects-shown", 5, "How many hops of a blocked policy redirect to trace and report")
+	flags.BoolVar(&opts.probeResumption, "probe-resumption", false, "Reconnect to each MX after STARTTLS and report whether the TLS session is resumed")
+	flags.StringVar(&opts.spec, "spec", specRFC8461, "Specification to validate against: rfc8461 or draft10")
+	flags.DurationVar(&opts.policyLatencyWarn, "policy-latency-warn", 2*time.Second, "Warn when fetching the policy takes longer than this, 0 to disable")
+	flags.StringVar(&policyUserAgent, "user-agent", policyUserAgent, "User-Agent header sent when fetching the policy")
+	flags.DurationVar(&opts.preTLSDelay, "pre-tls-delay", 0, "When STARTTLS fails, retry each MX once pausing this long before STARTTLS, for servers that reject fast clients")
+	flags.IntVar(&opts.recheckCount, "recheck-count", 0, "Fetch the policy this many times, each on a fresh connection, and fail when the bodies differ")
+	flags.StringVar(&opts.policyOrigin, "policy-origin", "", "Also fetch the policy from the origin server behind the CDN at host:port and compare it with the policy the CDN serves")
+	flags.IntVar(&opts.retries, "retries", 1, "How many times to retry the policy fetch on a fresh connection when the TLS handshake fails")
+	flags.StringVar(&f.pins, "pin-fingerprints", "", "Comma separated SHA-256 fingerprints, or a file with one per line, of the only certificates the MX hosts may present")
+	flags.DurationVar(&greetingTimeout, "timeout-greeting", greetingTimeout, "Time to wait for the SMTP greeting after connecting to an MX, and for each later reply and TLS handshake step")
+	flags.DurationVar(&dnsTimeout, "timeout-dns", 0, "Time limit for each DNS lookup, like 3s (default: the resolver's own retries)")
+	flags.StringVar(&f.caFile, "ca-file", "", caFileUsage)
+	flags.StringVar(&f.minTLS, "min-tls", "", "Minimum acceptable TLS version, 1.2 or 1.3. Connections below it are errors (default: warn below 1.2)")
+	return f
+}
+
+// complete checks the parsed check flags and fills in the options that
+// are derived from them, and installs the root certificates. The error
+// names the offending flag.
+func (f *checkFlags) complete() error {
+	if err := installRoots(f.caFile); err != nil {
+		return fmt.Errorf("Invalid -ca-file: %v", err)
+	}
+	opts := f.opts
+	opts.ignore = parseIgnore(f.ignore)
+	if _, ok := policyProfiles[opts.profile]; opts.profile != "" && !ok {
+		return fmt.Errorf("Unknown -profile %q, must be one of %s", opts.profile, strings.Join(profileNames(), ", "))
+	}
+	if f.severityMap != "" {
+		var err error
+		if opts.severities, err = readSeverityMap(f.severityMap); err != nil {
+			return fmt.Errorf("Invalid -severity-map: %v", err)
+		}
+	}
+	if f.minTLS != "" {
+		version, ok := tlsVersions[f.minTLS]
+		if !ok {
+			return fmt.Errorf("Unsupported -min-tls %q, must be 1.2 or 1.3", f.minTLS)
+		}
+		opts.minTLS = version
+	}
+	if f.pins != "" {
+		var err error
+		if opts.pins, err = parsePins(f.pins); err != nil {
+			return fmt.Errorf("Invalid -pin-fingerprints: %v", err)
+		}
+	}
+	if opts.recheckCount < 0 {
+		return fmt.Errorf("-recheck-count must not be negative")
+	}
+	if opts.policyOrigin != "" {
+		if _, _, err := net.SplitHostPort(opts.policyOrigin); err != nil {
+			opts.policyOrigin = net.JoinHostPort(strings.Trim(opts.policyOrigin, "[]"), "443")
+		}
+	}
+	if opts.retries < 0 {
+		return fmt.Errorf("-retries must not be negative")
+	}
+	if opts.certExpiryWarnDays < 0 || opts.certExpiryFailDays < 0 {
+		return fmt.Errorf("-warn-cert-expiry-days and -fail-on-cert-expiry-days must not be negative")
+	}
+	if _, ok := specNames[opts.spec]; !ok {
+		return fmt.Errorf("Unknown -spec %q, must be rfc8461 or draft10", opts.spec)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckFlagsCAFile(t *testing.T) {
+	savedPool, savedSource := rootPool, rootSource
+	t.Cleanup(func() { useRoots(savedPool, savedSource) })
+
+	dir := t.TempDir()
+	roots := filepath.Join(dir, "roots.pem")
+	cert := testCertificate(t, time.Now().Add(24*time.Hour), "Test Root")
+	if err := ioutil.WriteFile(roots, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(empty, []byte("not a certificate\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{"roots file", []string{"-ca-file", roots, "example.com", "example.org"}, ""},
+		{"no certificates", []string{"-ca-file", empty}, "Invalid -ca-file: " + empty + " contains no PEM certificates"},
+		{"missing file", []string{"-ca-file", filepath.Join(dir, "missing.pem")}, "Invalid -ca-file: open "},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("compare", flag.ContinueOnError)
+			checks := addCheckFlags(flags)
+			if err := flags.Parse(test.args); err != nil {
+				t.Fatal(err)
+			}
+			err := checks.complete()
+			if test.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.wantErr) {
+					t.Errorf("complete() = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rootSource != "-ca-file "+roots {
+				t.Errorf("roots in use: %s, want those of -ca-file", rootSource)
+			}
+		})
+	}
+}
+
+func TestCheckFlagsMinTLS(t *testing.T) {
+	savedPool, savedSource := rootPool, rootSource
+	t.Cleanup(func() { useRoots(savedPool, savedSource) })
+
+	tests := []struct {
+		value   string
+		want    uint16
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"1.2", tls.VersionTLS12, false},
+		{"1.3", tls.VersionTLS13, false},
+		{"1.0", 0, true},
+		{"1.1", 0, true},
+		{"TLS1.2", 0, true},
+	}
+	for _, test := range tests {
+		flags := flag.NewFlagSet("check", flag.ContinueOnError)
+		checks := addCheckFlags(flags)
+		if err := flags.Parse([]string{"-min-tls", test.value}); err != nil {
+			t.Fatal(err)
+		}
+		err := checks.complete()
+		if test.wantErr {
+			if want := fmt.Sprintf("Unsupported -min-tls %q, must be 1.2 or 1.3", test.value); err == nil || err.Error() != want {
+				t.Errorf("-min-tls %s: %v, want %q", test.value, err, want)
+			}
+			continue
+		}
+		if err != nil || checks.opts.minTLS != test.want {
+			t.Errorf("-min-tls %q: floor %x, %v, want %x", test.value, checks.opts.minTLS, err, test.want)
+		}
+	}
+}
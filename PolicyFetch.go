@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxPolicySize bounds the policy body, before and after decompression. The
+// spec sets no limit, but a policy is a few hundred bytes and senders cap
+// it too; anything larger is either a mistake or an attempt to exhaust the
+// memory of whoever fetches it.
+const maxPolicySize = 64 << 10
+
+// policyResponse is what the policy host returned. TTFB is the time until
+// the first response byte and Total the time until the body was read, both
+// measured from the start of the request.
+type policyResponse struct {
+	Body       string
+	StatusCode int
+	Header     http.Header
+	TLS        *tls.ConnectionState
+	TTFB       time.Duration
+	Total      time.Duration
+
+	// Address is the IP address the response came from.
+	Address string
+
+	// TLSDebug describes the handshake, for -tls-debug.
+	TLSDebug *tlsDebug
+
+	// ContentEncoding is the encoding the body was served with, already
+	// undone in Body.
+	ContentEncoding string
+
+	// Raw is the body as it was received, before decoding.
+	Raw []byte
+}
+
+// redirectError is returned when the policy host answers with a redirect.
+// Senders MUST NOT follow redirects, so neither does the fetch.
+type redirectError struct {
+	StatusCode int
+	Location   string
+}
+
+func (e *redirectError) Error() string {
+	return fmt.Sprintf("HTTP %d redirect to %s", e.StatusCode, e.Location)
+}
+
+// noRedirect makes a client return 3xx responses instead of following them.
+func noRedirect(req *http.Request, via []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// policyClient fetches the policy. Old TLS versions are allowed so they can
+// be reported by checkTLSVersion instead of failing the handshake.
+// Compression is disabled, like a sender would not ask for it, so a host
+// that compresses anyway is seen by fetchPolicy instead of being undone
+// silently by the transport.
+var policyClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:              http.ProxyFromEnvironment,
+		DialContext:        dialPolicyHost,
+		TLSClientConfig:    &tls.Config{MinVersion: tls.VersionTLS10},
+		DisableCompression: true,
+	},
+	CheckRedirect: noRedirect,
+}
+
+// dialPolicyHost connects to the policy host the way a sender picks its
+// address, see addressSelection.
+func dialPolicyHost(ctx context.Context, network string, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, addresses, err := dialInOrder(ctx, network, host, port)
+	if err != nil && len(addresses) > 0 {
+		return nil, &connectError{addresses, err}
+	}
+	return conn, err
+}
+
+// connectError marks a policy host whose name resolved but that accepted no
+// connection on any of its addresses, as opposed to a DNS failure.
+type connectError struct {
+	addresses []string
+	err       error
+}
+
+func (e *connectError) Error() string { return e.err.Error() }
+
+func (e *connectError) Unwrap() error { return e.err }
+
+// policyUserAgent is sent with every request to the policy host, see
+// -user-agent. Identifying the tool lets operators allow it through a WAF
+// and find it in their logs.
+var policyUserAgent = "StrictMTATest/" + version
+
+// queryHTTPSRecord fetches the policy, retrying up to retries times when the
+// TLS handshake fails. Edges flap and session tickets go stale, so before
+// the policy host is declared untrusted it gets another chance on a fresh
+// connection; policyClient keeps no session cache, so nothing is resumed.
+// It returns the number of handshake attempts made.
+func queryHTTPSRecord(url string, retries int) (*policyResponse, int, error) {
+	attempts := 0
+	for {
+		attempts++
+		policyClient.CloseIdleConnections()
+		response, err := fetchPolicy(policyClient, url)
+		if err == nil || !isHandshakeError(err) || attempts > retries {
+			return response, attempts, err
+		}
+	}
+}
+
+// handshakeError marks a fetch that failed in the TLS handshake, including
+// certificate verification, as opposed to DNS, TCP or HTTP errors.
+type handshakeError struct {
+	err   error
+	debug *tlsDebug
+}
+
+func (e *handshakeError) Error() string { return e.err.Error() }
+
+func (e *handshakeError) Unwrap() error { return e.err }
+
+func isHandshakeError(err error) bool {
+	var handshake *handshakeError
+	return errors.As(err, &handshake)
+}
+
+// familyClient returns a client that only connects over network, tcp4 or
+// tcp6. It never uses a proxy since that would hide the address family.
+func familyClient(network string) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+			TLSClientConfig:    &tls.Config{MinVersion: tls.VersionTLS10, RootCAs: rootPool},
+			DisableCompression: true,
+		},
+		CheckRedirect: noRedirect,
+	}
+}
+
+// policyFetchTimeout bounds one request to the policy host, from dialing
+// to the end of the body: the connect timeout, plus -timeout-greeting for
+// the TLS handshake and the response, like an MX gets for each step.
+func policyFetchTimeout() time.Duration {
+	return connectTimeout + greetingTimeout
+}
+
+// fetchPolicy GETs the policy. Anything but a 200 is an error; the response
+// is still returned so the caller can look at the TLS state and headers.
+// The request gives up after policyFetchTimeout, whatever client is used.
+func fetchPolicy(client *http.Client, url string) (*policyResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), policyFetchTimeout())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", policyUserAgent)
+	start := time.Now()
+	var ttfb time.Duration
+	var tlsErr error
+	var address string
+	var handshakeStart time.Time
+	var debug *tlsDebug
+	trace := &httptrace.ClientTrace{
+		GotConn:              func(info httptrace.GotConnInfo) { address = remoteIP(info.Conn) },
+		GotFirstResponseByte: func() { ttfb = time.Since(start) },
+		TLSHandshakeStart:    func() { handshakeStart = time.Now() },
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			tlsErr = err
+			debug = newTLSDebug(req.URL.Hostname(), offeredProtocols(client), &state, time.Since(handshakeStart), err)
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	response, err := client.Do(req)
+	if err != nil {
+		if tlsErr != nil {
+			return nil, &handshakeError{err, debug}
+		}
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	policy := &policyResponse{StatusCode: response.StatusCode, Header: response.Header, TLS: response.TLS, TTFB: ttfb, Address: address, TLSDebug: debug}
+	if response.StatusCode >= 300 && response.StatusCode < 400 {
+		return policy, &redirectError{StatusCode: response.StatusCode, Location: response.Header.Get("Location")}
+	}
+	if response.StatusCode != http.StatusOK {
+		return policy, fmt.Errorf("HTTP status %s", response.Status)
+	}
+
+	responseData, err := ioutil.ReadAll(io.LimitReader(response.Body, maxPolicySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(responseData) > maxPolicySize {
+		return policy, fmt.Errorf("the policy is larger than %d KiB", maxPolicySize>>10)
+	}
+	policy.Raw = responseData
+	policy.ContentEncoding, responseData, err = decodeBody(response.Header.Get("Content-Encoding"), responseData)
+	if err != nil {
+		return policy, err
+	}
+	policy.Body = string(responseData)
+	policy.Total = time.Since(start)
+	return policy, nil
+}
+
+// offeredProtocols returns the ALPN protocols client offers, which net/http
+// fills in on the transport's TLS config.
+func offeredProtocols(client *http.Client) []string {
+	if transport, ok := client.Transport.(*http.Transport); ok && transport.TLSClientConfig != nil {
+		return transport.TLSClientConfig.NextProtos
+	}
+	return nil
+}
+
+// decodeBody undoes a gzip content encoding, including a gzip body sent
+// without the header. It returns the encoding found, "" for a plain body.
+func decodeBody(encoding string, body []byte) (string, []byte, error) {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	if encoding == "" || encoding == "identity" {
+		if !bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+			return "", body, nil
+		}
+		encoding = "gzip (without Content-Encoding header)"
+	} else if encoding != "gzip" && encoding != "x-gzip" {
+		return encoding, body, fmt.Errorf("policy served with unsupported Content-Encoding %s", encoding)
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return encoding, body, fmt.Errorf("policy served with Content-Encoding %s but does not decompress: %v", encoding, err)
+	}
+	decoded, err := ioutil.ReadAll(io.LimitReader(reader, maxPolicySize+1))
+	if err != nil {
+		return encoding, body, fmt.Errorf("policy served with Content-Encoding %s but does not decompress: %v", encoding, err)
+	}
+	if len(decoded) > maxPolicySize {
+		return encoding, body, fmt.Errorf("policy served with Content-Encoding %s decompresses to more than %d KiB", encoding, maxPolicySize>>10)
+	}
+	return encoding, decoded, nil
+}
+
+// traceRedirects records where a blocked redirect was heading: each hop is
+// requested only to read its Location header, up to max hops. The bodies
+// are never used as a policy.
+func traceRedirects(from string, first *redirectError, max int) []string {
+	chain := []string{fmt.Sprintf("%d %s", first.StatusCode, first.Location)}
+	current, err := url.Parse(from)
+	if err != nil {
+		return chain
+	}
+	location := first.Location
+	for len(chain) < max {
+		next, err := current.Parse(location)
+		if err != nil {
+			break
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), policyFetchTimeout())
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, next.String(), nil)
+		if err != nil {
+			cancel()
+			break
+		}
+		req.Header.Set("User-Agent", policyUserAgent)
+		response, err := policyClient.Do(req)
+		cancel()
+		if err != nil {
+			chain = append(chain, "error: "+err.Error())
+			break
+		}
+		response.Body.Close()
+		location = response.Header.Get("Location")
+		if response.StatusCode < 300 || response.StatusCode >= 400 || location == "" {
+			chain = append(chain, fmt.Sprintf("%d (end of chain)", response.StatusCode))
+			break
+		}
+		chain = append(chain, fmt.Sprintf("%d %s", response.StatusCode, location))
+		current = next
+	}
+	return chain
+}
+
+// inspectPolicyCert connects to the policy host with SNI set and returns the
+// certificate it serves without verifying it, so a wrong certificate can be
+// described instead of just failing the fetch.
+func inspectPolicyCert(host string) (*CertInfo, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	config := &tls.Config{ServerName: host, InsecureSkipVerify: true, MinVersion: tls.VersionTLS10}
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, "443"), config)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	return inspectCert(host, state.PeerCertificates), nil
+}
+
+// checkPolicyCert verifies that the certificate served by the policy host
+// is for mta-sts.<domain>. Shared hosting that lacks a certificate for the
+// name typically serves its own default certificate instead.
+func checkPolicyCert(result *Result, host string, response *policyResponse) {
+	var cert *CertInfo
+	if response != nil && response.TLS != nil {
+		cert = inspectCert(host, response.TLS.PeerCertificates)
+	} else {
+		inspected, err := inspectPolicyCert(host)
+		if err != nil {
+			return
+		}
+		cert = inspected
+	}
+	if cert == nil {
+		return
+	}
+	result.PolicyCert = cert
+
+	if cert.HostnameError != "" {
+		result.errorf("POLICY-CERT-NAME-MISMATCH", host, "served a certificate for %s instead of %s, often a shared hosting default certificate",
+			certNames(cert), host)
+	}
+	addChainFindings(result, host, cert)
+}
+
+// checkPolicyLatency reports a policy host slower than the -policy-latency-warn
+// threshold. Some senders fetch the policy in the delivery path, so a slow
+// host delays mail even though the fetch succeeds.
+func checkPolicyLatency(result *Result, host string, response *policyResponse, threshold time.Duration) {
+	result.PolicyTTFBMillis = response.TTFB.Milliseconds()
+	result.PolicyFetchMillis = response.Total.Milliseconds()
+	if threshold > 0 && response.Total > threshold {
+		result.warnf("POLICYHOST-SLOW", host, "fetching the policy took %s (first byte after %s), above the %s threshold",
+			response.Total.Round(time.Millisecond), response.TTFB.Round(time.Millisecond), threshold)
+	}
+}
+
+// certNames lists the names a certificate is valid for.
+func certNames(cert *CertInfo) string {
+	if len(cert.DNSNames) == 0 {
+		return "[" + cert.Subject + "]"
+	}
+	return "[" + strings.Join(cert.DNSNames, ", ") + "]"
+}
+
+// compareDualStack fetches the policy once over IPv4 and once over IPv6 when
+// the policy host has both, and verifies the bodies are byte-identical.
+// Dual-stack CDNs sometimes serve different backends per family.
+func compareDualStack(result *Result, host string, url string) {
+	ctx, cancel := dnsContext()
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	cancel()
+	if err != nil {
+		result.skipCheck(checkDualStack, "policy host does not resolve")
+		return
+	}
+	var has4, has6 bool
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			has4 = true
+		} else {
+			has6 = true
+		}
+	}
+	if !has4 || !has6 {
+		result.skipCheck(checkDualStack, "policy host is not dual-stack")
+		return
+	}
+
+	mark := result.beginCheck()
+	result.PolicyHashes = make(map[string]string)
+	for _, family := range []struct{ name, network string }{{"ipv4", "tcp4"}, {"ipv6", "tcp6"}} {
+		response, err := fetchPolicy(familyClient(family.network), url)
+		if err != nil {
+			result.warnf("POLICY-FAMILY-FETCH-FAILED", host, "fetching the policy over %s failed: %v", family.name, err)
+			continue
+		}
+		result.PolicyHashes[family.name] = sha256Hex(response.Body)
+	}
+
+	v4, v6 := result.PolicyHashes["ipv4"], result.PolicyHashes["ipv6"]
+	if v4 != "" && v6 != "" && v4 != v6 {
+		result.errorf("POLICY-DUAL-STACK-MISMATCH", host, "the policy served over IPv4 (sha256 %s) differs from the one served over IPv6 (sha256 %s)",
+			shorten(v4, 16), shorten(v6, 16))
+	}
+	result.endCheck(checkDualStack, mark)
+}
+
+// policyVariant is one distinct policy body seen by -recheck-count, how
+// often and from which addresses.
+type policyVariant struct {
+	SHA256    string   `json:"sha256"`
+	Count     int      `json:"count"`
+	Addresses []string `json:"addresses,omitempty"`
+	Body      string   `json:"body"`
+}
+
+// recheckPolicy fetches the policy count times in all, first included, each
+// on a fresh connection, and reports when the bodies differ. A pool of
+// backends behind the policy host that is only partly updated serves
+// different policies to different senders.
+func recheckPolicy(result *Result, host string, url string, count int, first *policyResponse) {
+	mark := result.beginCheck()
+	defer func() { result.endCheck(checkRecheck, mark) }()
+
+	variants := make(map[string]*policyVariant)
+	var order []string
+	record := func(response *policyResponse) {
+		hash := sha256Hex(response.Body)
+		variant, ok := variants[hash]
+		if !ok {
+			variant = &policyVariant{SHA256: hash, Body: response.Body}
+			variants[hash] = variant
+			order = append(order, hash)
+		}
+		variant.Count++
+		if response.Address != "" && !contains(variant.Addresses, response.Address) {
+			variant.Addresses = append(variant.Addresses, response.Address)
+		}
+	}
+	record(first)
+
+	failed := 0
+	var lastErr error
+	for i := 1; i < count; i++ {
+		policyClient.CloseIdleConnections()
+		response, err := fetchPolicy(policyClient, url)
+		if err != nil {
+			failed++
+			lastErr = err
+			continue
+		}
+		record(response)
+	}
+
+	for _, hash := range order {
+		result.PolicyVariants = append(result.PolicyVariants, *variants[hash])
+	}
+	if failed > 0 {
+		result.warnf("POLICY-RECHECK-FAILED", host, "%d of %d fetches of the policy failed, last: %v", failed, count, lastErr)
+	}
+	if len(order) > 1 {
+		var seen []string
+		for _, variant := range result.PolicyVariants {
+			seen = append(seen, fmt.Sprintf("sha256 %s %s from %s", shorten(variant.SHA256, 16), plural(variant.Count, "time"),
+				display(strings.Join(variant.Addresses, ", "))))
+		}
+		result.errorf("POLICY-INCONSISTENT", host, "%d fetches returned %d different policies, an inconsistent backend pool: %s",
+			count-failed, len(order), strings.Join(seen, "; "))
+	}
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// gzipped returns text compressed with gzip.
+func gzipped(t *testing.T, text string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write([]byte(text)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFetchEncodedPolicy(t *testing.T) {
+	policy := policyOf("version: STSv1", "mode: enforce", "mx: mx.example.com", "max_age: 86400")
+	tests := []struct {
+		name         string
+		header       string
+		body         []byte
+		wantEncoding string
+		wantErr      string
+	}{
+		{"plain", "", []byte(policy), "", ""},
+		{"gzip", "gzip", gzipped(t, policy), "gzip", ""},
+		{"x-gzip", "x-gzip", gzipped(t, policy), "x-gzip", ""},
+		{"gzip without header", "", gzipped(t, policy), "gzip (without Content-Encoding header)", ""},
+		{"gzip header on plain body", "gzip", []byte(policy), "gzip", "does not decompress"},
+		{"unsupported encoding", "br", []byte(policy), "br", "unsupported Content-Encoding br"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				if test.header != "" {
+					w.Header().Set("Content-Encoding", test.header)
+				}
+				w.Write(test.body)
+			}))
+			defer server.Close()
+			client := server.Client()
+			client.Transport.(*http.Transport).DisableCompression = true
+
+			got, err := fetchPolicy(client, server.URL+"/.well-known/mta-sts.txt")
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Body != policy {
+				t.Errorf("Body = %q, want %q", got.Body, policy)
+			}
+			if got.ContentEncoding != test.wantEncoding {
+				t.Errorf("ContentEncoding = %q, want %q", got.ContentEncoding, test.wantEncoding)
+			}
+			if !bytes.Equal(got.Raw, test.body) {
+				t.Errorf("Raw is not the body as served")
+			}
+		})
+	}
+}
+
+func TestPolicyHostRefusesConnections(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	response, attempts, err := queryHTTPSRecord("https://"+address+"/.well-known/mta-sts.txt", 2)
+	var connect *connectError
+	if !errors.As(err, &connect) {
+		t.Fatalf("error %v (%T), want a connectError", err, err)
+	}
+	if strings.Join(connect.addresses, ",") != "127.0.0.1" || !strings.Contains(connect.err.Error(), "refused") {
+		t.Errorf("connectError of %q: %v, want the address that refused", connect.addresses, connect.err)
+	}
+	if isHandshakeError(err) || attempts != 1 || response != nil {
+		t.Errorf("a refused connection was taken for a handshake failure: %d attempts", attempts)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// policyProfile is a named set of rules on the keys of a policy, checked by
+// -profile on top of what the spec requires. Keys lists the allowed keys in
+// the order they are reported; a key not listed is a deviation.
+type policyProfile struct {
+	Description string
+	Keys        []profileKey
+}
+
+// profileKey is how often a key may appear, Max 0 meaning any number of
+// times.
+type profileKey struct {
+	Name     string
+	Min, Max int
+}
+
+// policyProfiles are the profiles -profile accepts by name.
+var policyProfiles = map[string]policyProfile{
+	"strict-minimal": {
+		Description: "exactly one version, mode and max_age, at least one mx and no other keys",
+		Keys: []profileKey{
+			{Name: "version", Min: 1, Max: 1},
+			{Name: "mode", Min: 1, Max: 1},
+			{Name: "max_age", Min: 1, Max: 1},
+			{Name: "mx", Min: 1},
+		},
+	},
+}
+
+// profileNames lists the profiles for the usage and error messages.
+func profileNames() []string {
+	var names []string
+	for name := range policyProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// times spells out a count of lines.
+func times(n int) string {
+	switch n {
+	case 0:
+		return "none"
+	case 1:
+		return "once"
+	}
+	return fmt.Sprintf("%d times", n)
+}
+
+// checkPolicyProfile reports every way the keys of the policy deviate from
+// the named profile. Keys repeated by mistake and keys of extensions are
+// deviations even where the spec tolerates them.
+func checkPolicyProfile(result *Result, name string) {
+	if name == "" {
+		result.skipCheck(checkProfile, "-profile not set")
+		return
+	}
+	rows := strings.Split(result.Policy, "\n")
+	if notLineDelimited(rows) != "" {
+		result.skipCheck(checkProfile, "policy is not newline-delimited")
+		return
+	}
+	profile := policyProfiles[name]
+	mark := result.beginCheck()
+	defer func() { result.endCheck(checkProfile, mark) }()
+	result.Profile = name
+
+	allowed := make(map[string]bool)
+	for _, key := range profile.Keys {
+		allowed[key.Name] = true
+	}
+	counts := make(map[string]int)
+	var extra []string
+	for _, key := range allKeys(rows) {
+		if key == "" {
+			continue
+		}
+		if counts[key] == 0 && !allowed[key] {
+			extra = append(extra, key)
+		}
+		counts[key]++
+	}
+	deviations := 0
+	for _, key := range profile.Keys {
+		n := counts[key.Name]
+		switch {
+		case n < key.Min && key.Min == 1:
+			result.errorf("POLICY-PROFILE-DEVIATION", key.Name, "the %s profile requires a %s line, the policy has none", name, key.Name)
+		case n < key.Min:
+			result.errorf("POLICY-PROFILE-DEVIATION", key.Name, "the %s profile requires at least %d %s lines, the policy has %s", name, key.Min, key.Name, times(n))
+		case key.Max > 0 && n > key.Max:
+			result.errorf("POLICY-PROFILE-DEVIATION", key.Name, "the %s profile allows %s only %s, the policy has it %s", name, key.Name, times(key.Max), times(n))
+		default:
+			continue
+		}
+		deviations++
+	}
+	for _, key := range extra {
+		result.errorf("POLICY-PROFILE-DEVIATION", key, "the %s profile allows no %s key", name, key)
+		deviations++
+	}
+	if deviations == 0 {
+		result.infof("POLICY-PROFILE-MATCH", name, "the policy has %s", profile.Description)
+	}
+}
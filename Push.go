@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pushOptions says where to push the metrics of a run, see -pushgateway.
+type pushOptions struct {
+	gateway   string
+	job       string
+	basicAuth string
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricsText renders the outcome of a run in the Prometheus text
+// exposition format. The domain is not a label here; it is part of the
+// Pushgateway grouping key.
+func metricsText(result *Result) string {
+	var buf bytes.Buffer
+	gauge := func(name, help string) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+
+	gauge("mtasts_verdict_pass", "1 if the domain passed validation, 0 if it failed.")
+	pass := 0
+	if result.Verdict.Status == verdictPass {
+		pass = 1
+	}
+	fmt.Fprintf(&buf, "mtasts_verdict_pass %d\n", pass)
+
+	gauge("mtasts_findings", "Unsuppressed findings by severity.")
+	counts := map[Severity]int{}
+	for _, f := range result.Findings {
+		if !f.Suppressed {
+			counts[f.Severity]++
+		}
+	}
+	for _, severity := range []Severity{SeverityError, SeverityWarning, SeverityInfo} {
+		fmt.Fprintf(&buf, "mtasts_findings{severity=\"%s\"} %d\n", severity, counts[severity])
+	}
+
+	gauge("mtasts_deployment_state", "1 for the current deployment state of the domain.")
+	fmt.Fprintf(&buf, "mtasts_deployment_state{state=\"%s\"} 1\n", result.DeploymentState)
+
+	gauge("mtasts_mx_tls_ok", "1 if the MX host passed STARTTLS and certificate checks.")
+	for _, mx := range result.MX {
+		ok := 0
+		if mx.TLSOK {
+			ok = 1
+		}
+		fmt.Fprintf(&buf, "mtasts_mx_tls_ok{host=\"%s\"} %d\n", labelEscaper.Replace(mx.Host), ok)
+	}
+
+	gauge("mtasts_mx_cert_expiry_timestamp_seconds", "NotAfter of the certificate presented by the MX host.")
+	for _, mx := range result.MX {
+		if mx.Cert != nil {
+			fmt.Fprintf(&buf, "mtasts_mx_cert_expiry_timestamp_seconds{host=\"%s\"} %d\n", labelEscaper.Replace(mx.Host), mx.Cert.NotAfter.Unix())
+		}
+	}
+
+	if maxAge, err := strconv.Atoi(result.MaxAge); err == nil {
+		gauge("mtasts_policy_max_age_seconds", "max_age of the published policy.")
+		fmt.Fprintf(&buf, "mtasts_policy_max_age_seconds %d\n", maxAge)
+	}
+
+	if result.Policy != "" {
+		gauge("mtasts_policy_fetch_seconds", "Time to fetch the policy, until the first byte and until the whole body.")
+		fmt.Fprintf(&buf, "mtasts_policy_fetch_seconds{phase=\"first_byte\"} %.3f\n", float64(result.PolicyTTFBMillis)/1000)
+		fmt.Fprintf(&buf, "mtasts_policy_fetch_seconds{phase=\"total\"} %.3f\n", float64(result.PolicyFetchMillis)/1000)
+	}
+
+	gauge("mtasts_last_run_timestamp_seconds", "When the domain was last validated.")
+	fmt.Fprintf(&buf, "mtasts_last_run_timestamp_seconds %d\n", time.Now().Unix())
+	return buf.String()
+}
+
+// pushMetrics replaces the group of the domain on the Pushgateway with the
+// metrics of this run. A failed push is retried once, then reported on
+// stderr; it never changes the exit code.
+func pushMetrics(result *Result, push *pushOptions) {
+	target := strings.TrimSuffix(push.gateway, "/") + "/metrics/job/" + url.PathEscape(push.job) +
+		"/domain/" + url.PathEscape(result.Domain)
+	body := metricsText(result)
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = putMetrics(target, body, push.basicAuth); err == nil {
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Warning: pushing metrics to %s failed: %v\n", push.gateway, err)
+}
+
+func putMetrics(target string, body string, basicAuth string) error {
+	req, err := http.NewRequest(http.MethodPut, target, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	if basicAuth != "" {
+		user, pass, _ := strings.Cut(basicAuth, ":")
+		req.SetBasicAuth(user, pass)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("HTTP status %s", response.Status)
+	}
+	return nil
+}
@@ -3,7 +3,8 @@
 * SMTP MTA Strict Transport Security (MTA-STS)
 *
 *
-* This code validates against Draft v10
+* This code validates against RFC 8461, or Draft v10 with -spec draft10
+*     https://tools.ietf.org/html/rfc8461
 *     https://tools.ietf.org/html/draft-ietf-uta-mta-sts-10
 *
 **/
@@ -12,109 +13,997 @@ package main
 import (
 	"bytes"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"log"
 	"net"
-	"net/http"
-	"net/smtp"
 	"os"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
 )
 
+// version identifies this build, for instance in the policy fetch
+// User-Agent.
+const version = "1.0"
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		os.Exit(compareMain(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "diff-results" {
+		os.Exit(diffResultsMain(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		os.Exit(doctorMain(os.Args[2:]))
+	}
+
 	domain := flag.String("domain", "gmail.com", "The domain to validate. Like gmail.com or comcast.net")
+	domainsFile := flag.String("domains-file", "", "Validate every domain listed in this file, one per line, - for stdin")
+	zoneFile := flag.String("zonefile", "", "Read the MX, TXT and CNAME records of -domain from this BIND zone file instead of DNS")
+	policyFile := flag.String("policy-file", "", "Lint a local mta-sts.txt policy file instead of validating a live domain")
+	compareDraft := flag.Bool("compare-draft", false, "Validate -domain under both RFC 8461 and draft-ietf-uta-mta-sts-10 and show where the results differ")
+	canonicalize := flag.Bool("canonicalize", false, "Print the policy of -domain, or of -policy-file, in a normalized form for storing and diffing instead of validating it")
+	getField := flag.String("get-field", "", "Print only the value of this key of the policy of -domain, or of -policy-file: version, mode, max_age or mx, one mx pattern per line")
+	output := flag.String("output", "", "With -canonicalize, write the policy to this file instead of stdout")
+	fixScript := flag.Bool("fix-script", false, "Print a shell script of suggested fixes for the findings instead of the report; it changes nothing by itself")
+	trace := flag.Bool("trace-acquisition", false, "Narrate the policy discovery, fetch and MX selection of a compliant sender for -domain, step by step with the RFC 8461 section of each and the final delivery decision")
+	listMX := flag.Bool("list-mx", false, "Only list the MX hosts of -domain with their addresses and a summary of their TLS, without any MTA-STS checks")
+	certOnly := flag.String("cert-only", "", "Only test the TLS certificate of the SMTP server at host:port, skipping all DNS and policy checks")
+	checks := addCheckFlags(flag.CommandLine)
+	opts := checks.opts
+	flag.BoolVar(&opts.explain, "explain", false, "Explain why each finding matters and cite the RFC section it comes from")
+	flag.StringVar(&opts.format, "format", "text", "Output format: text, json, markdown, html, sarif or gha")
+	flag.BoolVar(&opts.quiet, "quiet", false, "Do not print remediation hints")
+	flag.BoolVar(&opts.verbose, "verbose", false, "Show more detail, including suppressed findings")
+	flag.StringVar(&opts.push.gateway, "pushgateway", "", "Push the metrics of the run to this Prometheus Pushgateway, like http://host:9091")
+	flag.StringVar(&opts.push.job, "push-job", "mtasts", "Job name to push the metrics under")
+	flag.StringVar(&opts.push.basicAuth, "push-basic-auth", "", "user:pass for a Pushgateway behind basic auth")
+	flag.BoolVar(&opts.smtpDebug, "smtp-debug", false, "Record the SMTP dialogue with each MX and show it under hosts that fail, and in the JSON output")
+	flag.BoolVar(&opts.includeRaw, "include-raw", false, "Keep the verbatim policy body and the EHLO replies in the raw section of the JSON output")
+	flag.BoolVar(&opts.tlsDebug, "tls-debug", false, "Show the TLS details of each MX and policy host connection: ALPN, version, cipher, key exchange, chain, timing and what ended a failed handshake")
+	saveCertsDir := flag.String("save-certs", "", "Write every certificate presented by the MX and policy hosts into this directory as PEM, with a JSON file of where each was seen")
+	flag.BoolVar(&opts.exitZero, "exit-zero", false, "With -domains-file, always exit 0, for report-only pipelines")
+	flag.BoolVar(&opts.onlyFailures, "only-failures", false, "With -domains-file, only show domains with errors or warnings; passing domains still count in the summary")
+	flag.IntVar(&opts.maxFailures, "max-failures", 0, "With -domains-file, tolerate up to this many failing domains before exiting non-zero")
+	flag.StringVar(&opts.outputDir, "output-dir", "", "With -domains-file, write one report per domain in the -format into this directory")
+	sample := flag.String("sample", "", "With -domains-file, validate a random subset of N domains, or N% of them, and estimate the posture of the whole list")
+	flag.Int64Var(&opts.sampleSeed, "sample-seed", 0, "Seed for -sample, to draw the same sample again (default: random, printed with the estimate)")
+	flag.StringVar(&opts.resume, "resume", "", "With -domains-file, save the progress to this state file and, when it exists, continue the interrupted run it records")
+	flag.BoolVar(&opts.changedOnly, "changed-only", false, "Print nothing when the findings and deployment state are those of the previous run; an unchanged failure exits 4")
+	flag.BoolVar(&opts.checkIDChange, "check-id-change", false, "Compare the policy and the _mta-sts id with the previous run and fail when the policy changed but the id did not")
+	cacheDir := flag.String("cache-dir", "", "Where -changed-only and -check-id-change keep the previous run of each domain (default: StrictMTATest in the user cache directory)")
+	benchmark := flag.Int("benchmark", 0, "Measure N STARTTLS handshakes against each MX of -domain, or the -cert-only host, and report the latency percentiles instead of validating")
+	flag.DurationVar(&opts.benchmarkGap, "benchmark-interval", time.Second, "Pause between -benchmark handshakes to the same host, doubled after each failure")
+	showVersion := flag.Bool("version", false, "Print the version and the root certificates in use, then exit")
 	flag.Parse()
 
+	if err := checks.complete(); err != nil {
+		fmt.Printf("%v\n\n", err)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if *showVersion {
+		printVersion()
+		os.Exit(0)
+	}
+	if *saveCertsDir != "" {
+		opts.certs = newCertStore(*saveCertsDir)
+	}
+	if opts.resume != "" && (*domainsFile == "" || opts.changedOnly) {
+		fmt.Printf("-resume needs -domains-file and doesn't combine with -changed-only\n\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if *sample != "" {
+		if *domainsFile == "" {
+			fmt.Printf("-sample needs -domains-file\n\n")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		var err error
+		if opts.sample, err = parseSample(*sample); err != nil {
+			fmt.Printf("Invalid -sample: %v\n\n", err)
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		if opts.sampleSeed == 0 {
+			opts.sampleSeed = time.Now().UnixNano()
+		}
+	}
+	if opts.changedOnly || opts.checkIDChange {
+		if *certOnly != "" || *policyFile != "" || *compareDraft || *canonicalize || *benchmark > 0 || *listMX {
+			fmt.Printf("-changed-only and -check-id-change apply to a single -domain or -domains-file\n\n")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		var err error
+		if opts.cache, err = newRunCache(*cacheDir); err != nil {
+			fmt.Printf("-changed-only and -check-id-change need a cache directory: %v\n\n", err)
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+	}
+	if opts.format != "text" && opts.format != "json" && opts.format != formatSARIF && opts.format != formatGHA && !isDocumentFormat(opts.format) {
+		fmt.Printf("Unknown format %q\n\n", opts.format)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *trace && (*domain == "" || *domainsFile != "" || *certOnly != "" || *policyFile != "" || *listMX || (opts.format != "text" && opts.format != "json")) {
+		fmt.Printf("-trace-acquisition needs -domain and prints text or json\n\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if *listMX && (*domain == "" || *domainsFile != "" || *certOnly != "" || *policyFile != "" || (opts.format != "text" && opts.format != "json")) {
+		fmt.Printf("-list-mx needs -domain and prints text or json\n\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if *zoneFile != "" && (*domainsFile != "" || *certOnly != "" || *policyFile != "") {
+		fmt.Printf("-zonefile only applies to a single -domain\n\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if opts.outputDir != "" && (*domainsFile == "" || opts.format == "text" || opts.format == formatSARIF || opts.format == formatGHA) {
+		fmt.Printf("-output-dir needs -domains-file and a -format of json, markdown or html\n\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if *domainsFile != "" && opts.outputDir == "" && isDocumentFormat(opts.format) {
+		fmt.Printf("-format %s with -domains-file needs -output-dir\n\n", opts.format)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *benchmark < 0 || opts.benchmarkGap < 0 {
+		fmt.Printf("-benchmark and -benchmark-interval must not be negative\n\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if *benchmark > 0 && (*domainsFile != "" || *policyFile != "" || *zoneFile != "") {
+		fmt.Printf("-benchmark applies to a single -domain or -cert-only host\n\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if *benchmark > 0 {
+		os.Exit(benchmarkMain(*domain, *certOnly, *benchmark, opts))
+	}
+
+	if *output != "" && !*canonicalize {
+		fmt.Printf("-output needs -canonicalize\n\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if *canonicalize {
+		if *domain == "" && *policyFile == "" {
+			fmt.Printf("-canonicalize needs -domain or -policy-file\n\n")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		os.Exit(canonicalizeMain(*domain, *policyFile, *output, opts))
+	}
+	if *getField != "" {
+		if !contains(canonicalKeys, *getField) || (*domain == "" && *policyFile == "") {
+			fmt.Printf("-get-field needs -domain or -policy-file and one of %s\n\n", strings.Join(canonicalKeys, ", "))
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		os.Exit(getFieldMain(*domain, *policyFile, *getField, opts))
+	}
+
+	if *certOnly != "" {
+		os.Exit(certOnlyMain(*certOnly, opts))
+	}
+
+	if *policyFile != "" {
+		os.Exit(lintPolicyMain(*policyFile, opts))
+	}
+
+	if *domainsFile != "" {
+		os.Exit(batchMain(*domainsFile, opts))
+	}
+
 	if *domain == "" {
 		fmt.Println("Domain is a required field\n\n ")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	mxRecords := mxRecords(*domain)
+	if *zoneFile != "" {
+		// The zone is that of the mail domain even when -domain names its
+		// policy host, which validate reports.
+		mail, _ := mailDomainOf(*domain)
+		zone, err := loadZoneFile(*zoneFile, mail)
+		if err != nil {
+			fmt.Printf("Invalid -zonefile: %v\n", err)
+			os.Exit(1)
+		}
+		if !zone.covers(mail) {
+			fmt.Printf("Invalid -zonefile: %s has no MX or TXT records for %s\n", *zoneFile, mail)
+			os.Exit(1)
+		}
+		resolver = zone
+	}
+
+	if *compareDraft {
+		os.Exit(compareDraftMain(*domain, opts))
+	}
+	if *listMX {
+		os.Exit(listMXMain(*domain, opts))
+	}
+	if *trace {
+		os.Exit(traceMain(*domain, opts))
+	}
+
+	if *fixScript {
+		// The script is built from the hints.
+		opts.quiet = false
+	}
+	result := validate(*domain, opts)
+	annotate(result, opts)
+	opts.certs.observe(result)
+	saveCerts(opts.certs)
+	if opts.cache != nil && !opts.cache.changed(result) && opts.changedOnly {
+		if opts.push.gateway != "" {
+			pushMetrics(result, &opts.push)
+		}
+		os.Exit(unchangedExitCode(result.Verdict.exitCode()))
+	}
+	if *fixScript {
+		fmt.Print(fixScriptText(result))
+	} else if opts.format == "json" {
+		writeJSON(result)
+	} else if opts.format == formatSARIF {
+		writeJSON(sarifReport([]*Result{result}))
+	} else if opts.format == formatGHA {
+		printGHA(result, func() { printResult(result, opts) })
+	} else if isDocumentFormat(opts.format) {
+		fmt.Print(renderDocument(result, opts.format))
+	} else {
+		printResult(result, opts)
+	}
+	if opts.push.gateway != "" {
+		pushMetrics(result, &opts.push)
+	}
+	os.Exit(result.Verdict.exitCode())
+}
+
+// options holds the command line settings that affect a run.
+type options struct {
+	explain bool
+	format  string
+	quiet   bool
+	verbose bool
+	ignore  map[string]bool
+	minTLS  uint16
+	spec    string
+
+	severities          severityMap
+	failOnMissingTLSRPT bool
+	checkNSConsistency  bool
+	maxRedirectsShown   int
+	probeResumption     bool
+	policyLatencyWarn   time.Duration
+	pins                map[string]bool
+	preTLSDelay         time.Duration
+	retries             int
+	assertNoUnknownKeys bool
+	profile             string
+	certExpiryWarnDays  int
+	certExpiryFailDays  int
+	exitZero            bool
+	onlyFailures        bool
+	smtpDebug           bool
+	tlsDebug            bool
+	includeRaw          bool
+	certs               *certStore
+	cache               *runCache
+	changedOnly         bool
+	checkIDChange       bool
+	resume              string
+	sample              sampleSpec
+	sampleSeed          int64
+	recheckCount        int
+	policyOrigin        string
+	benchmarkGap        time.Duration
+	maxFailures         int
+	outputDir           string
+	push                pushOptions
+}
+
+// validate runs every check against domain and collects the outcome.
+// Nothing is printed here, see printResult.
+//
+// The SMTP probes, the DNS lookups and the policy fetch don't depend on each
+// other, so they run concurrently, each collecting into a Result of its own.
+// The partial results are merged in a fixed order before the cross-checks
+// run, which keeps findings and checks in the same order on every run.
+func validate(domain string, opts *options) *Result {
+	var (
+		wg                           sync.WaitGroup
+		mail, sts, policy, dual, rpt *Result
+		mxRecords                    []string
+	)
+	requested := domain
+	domain, stripped := mailDomainOf(domain)
+	wg.Add(4)
+	go func() { defer wg.Done(); mail, mxRecords = mailPhase(domain, opts) }()
+	go func() { defer wg.Done(); sts = stsPhase(domain, opts) }()
+	go func() { defer wg.Done(); policy, dual = policyPhase(domain, opts) }()
+	go func() { defer wg.Done(); rpt = tlsrptPhase(domain, opts) }()
+	wg.Wait()
+
+	result := &Result{Domain: domain, Spec: opts.spec, AddressSelection: addressSelection, PolicyHost: "mta-sts." + domain}
+	if unicodeForm := toUnicode(result.PolicyHost); unicodeForm != result.PolicyHost {
+		result.PolicyHostUnicode = unicodeForm
+	}
+	if stripped {
+		result.RequestedDomain = requested
+		result.warnf("DOMAIN-IS-POLICY-HOST", requested, "%s is the policy host or record name of %s, not a mail domain; validated %s instead", requested, domain, domain)
+	}
+	if zone, ok := resolver.(*zoneResolver); ok {
+		result.DNSSource = "zone file " + zone.path
+	}
+	result.merge(mail)
+	result.MX, result.MXLookupError = mail.MX, mail.MXLookupError
+	result.LocalIPv6 = mail.LocalIPv6
+	result.merge(sts)
+	result.STSRecord, result.TXTRecordsExamined, result.NSRecords = sts.STSRecord, sts.TXTRecordsExamined, sts.NSRecords
+	result.STSLookupError = sts.STSLookupError
+	result.STSRecords, result.STSResponseSizes = sts.STSRecords, sts.STSResponseSizes
+
+	mark := result.beginCheck()
+	result.merge(policy)
+	result.Policy, result.PolicyTLSVersion, result.PolicyCert, result.PolicyRedirects =
+		policy.Policy, policy.PolicyTLSVersion, policy.PolicyCert, policy.PolicyRedirects
+	result.PolicyTTFBMillis, result.PolicyFetchMillis = policy.PolicyTTFBMillis, policy.PolicyFetchMillis
+	result.PolicyEncoding, result.PolicyAttempts = policy.PolicyEncoding, policy.PolicyAttempts
+	result.PolicyAddress, result.PolicyTLSDebug = policy.PolicyAddress, policy.PolicyTLSDebug
+	result.PolicyTLSState = policy.PolicyTLSState
+	checkHalfDeployment(result)
+	result.endCheck(checkPolicy, mark)
+
+	if result.Policy != "" {
+		result.merge(dual)
+		result.PolicyHashes, result.PolicyVariants = dual.PolicyHashes, dual.PolicyVariants
+		result.PolicyOrigin = dual.PolicyOrigin
+		validatePolicy(result, mxRecords)
+		checkPolicyProfile(result, opts.profile)
+	} else {
+		result.skipCheck(checkDualStack, "policy could not be fetched")
+		result.skipCheck(checkRecheck, "policy could not be fetched")
+		result.skipCheck(checkOrigin, "policy could not be fetched")
+		result.skipCheck(checkSyntax, "policy could not be fetched")
+		result.skipCheck(checkMXCoverage, "policy could not be fetched")
+		result.skipCheck(checkProfile, "policy could not be fetched")
+	}
+
+	result.merge(rpt)
+	result.TLSRPTRecord = rpt.TLSRPTRecord
+	if opts.cache != nil {
+		verifyIDChange(result, opts.cache.previous(result.Domain))
+	} else {
+		result.skipCheck(checkIDChange, "-check-id-change not set")
+	}
+	result.Raw = &rawArtifacts{MX: mxRecords, STSTXT: sts.Raw.STSTXT, TLSRPTTXT: rpt.Raw.TLSRPTTXT, Policy: policy.Raw.Policy,
+		Probes: rawProbes(result.MX, opts.includeRaw)}
+	return result
+}
+
+// checkHalfDeployment reports a TXT record without a policy and a policy
+// without a TXT record. A TXT lookup that failed says nothing about whether
+// the record exists, so it is not taken for a missing record.
+func checkHalfDeployment(result *Result) {
+	if result.STSRecord != "" && result.Policy == "" {
+		result.errorf("DEPLOYMENT-DNS-WITHOUT-POLICY", result.Domain, "the _mta-sts TXT record is published but the policy cannot be fetched; "+
+			"senders that see the record will try to fetch the policy, fail, and may defer mail depending on their cached state")
+	}
+	if result.STSRecord == "" && result.STSLookupError == "" && result.Policy != "" {
+		result.warnf("DEPLOYMENT-POLICY-WITHOUT-DNS", result.Domain, "the policy is served but no _mta-sts TXT record is published, so no sender will discover it; publish %s",
+			stsRecord(result.Domain, generateID()))
+	}
+}
+
+// mailPhase looks up the MX hosts of domain and probes each one for
+// STARTTLS. The MX names are returned for the coverage check, without the
+// IP addresses, which no mx pattern can cover.
+func mailPhase(domain string, opts *options) (*Result, []string) {
+	result := &Result{Domain: domain}
+
+	mark := result.beginCheck()
+	mxRecords, err := mxRecords(domain)
+	if err != nil {
+		result.MXLookupError = err.Error()
+		if isDNSTimeout(err) {
+			result.errorf("DNS-TIMEOUT", domain, "MX lookup timed out: %v", err)
+		} else {
+			result.errorf("MX-LOOKUP-FAILED", domain, "MX lookup failed: %v", err)
+		}
+	}
+	result.endCheck(checkMXLookup, mark)
+
+	if len(mxRecords) == 0 {
+		result.skipCheck(checkSTARTTLS, "no MX hosts")
+		return result, mxRecords
+	}
+	mark = result.beginCheck()
+	names := make([]string, 0, len(mxRecords))
 	for _, record := range mxRecords {
-		if len(record) > 0 {
-			tlsTest(record, "25")
+		if isIPLiteral(record) {
+			// Senders look the address up as a name, and a certificate
+			// can't be matched against it.
+			result.errorf("MX-IP-LITERAL", record, "MX host must be a host name, not an IP address: %s", record)
+			continue
+		}
+		names = append(names, record)
+		if problem := hostnameLengthError(record); problem != "" {
+			result.errorf("MX-NAME-INVALID", shorten(record, 80), "MX host is not a valid DNS name, %s", problem)
+			continue
+		}
+		if _, err := toASCII(record); err != nil {
+			result.warnf("IDNA-INVALID", record, "MX host cannot be converted to A-label form and is compared as returned: %v", err)
+		}
+		// Senders connect to and match the MX name, not the target of an
+		// alias, so the tests below use record either way.
+		chain, chainErr := cnameChain(record)
+		canonical := ""
+		if len(chain) > 0 {
+			hops := strings.Join(append([]string{record}, chain...), " -> ")
+			if chainErr != nil {
+				result.warnf("MX-POINTS-TO-CNAME", record, "MX host is an alias, MX targets must not be CNAMEs: %s; %v", hops, chainErr)
+			} else {
+				canonical = chain[len(chain)-1]
+				result.warnf("MX-POINTS-TO-CNAME", record, "MX host is an alias, MX targets must not be CNAMEs: %s", hops)
+			}
+		}
+		mx := tlsTest(record, "25", opts)
+		mx.Canonical = canonical
+		mx.CNAMEChain = chain
+		if opts.probeResumption && mx.StartTLS {
+			checkResumption(result, &mx)
+		}
+		if ipv6Only(mx.Candidates) {
+			assessment := localIPv6()
+			result.LocalIPv6 = &assessment
 		}
+		result.MX = append(result.MX, mx)
+		addMXFindings(result, mx, opts)
 	}
+	checkSharedCerts(result)
+	result.endCheck(checkSTARTTLS, mark)
+	return result, names
+}
+
+// stsPhase looks up the _mta-sts TXT record and, with
+// -check-ns-consistency, compares it across the nameservers.
+func stsPhase(domain string, opts *options) *Result {
+	result := &Result{Domain: domain, Spec: opts.spec}
+
+	mark := result.beginCheck()
+	stsName := "_mta-sts." + domain
+	records, txt, err := stsDNSCheck(stsName)
+	result.TXTRecordsExamined = len(txt)
+	result.Raw = &rawArtifacts{STSTXT: txt}
+	if len(records) > 0 {
+		result.STSRecord = records[0]
+	}
+	if len(records) > 1 {
+		// Senders discard every record then, RFC 8461 §3.1.
+		result.STSRecords = records
+		result.errorf("STS-TXT-MULTIPLE", stsName, "%d TXT records start with v=STSv1, so senders treat the domain as having no policy: %s",
+			len(records), strings.Join(quoteAll(records), ", "))
+	}
+	if err != nil {
+		result.STSLookupError = err.Error()
+	}
+	if isDNSTimeout(err) {
+		result.errorf("DNS-TIMEOUT", stsName, "STS Failed, DNS lookup timed out: %v", err)
+	} else if err != nil {
+		result.errorf("STS-TXT-LOOKUP-FAILED", stsName, "STS Failed, DNS lookup failed: %v", err)
+	} else if result.STSRecord == "" {
+		result.errorf("STS-TXT-MISSING", stsName, "STS Failed, DNS lookup succeeded but no STS record among %d TXT records", result.TXTRecordsExamined)
+	} else {
+		checkSTSRecordFields(result, stsName, result.STSRecord)
+	}
+	result.endCheck(checkSTSTXT, mark)
 
-	// Do DNS txt check
-	stsRecord := stsDNSCheck("_mta-sts." + *domain)
-	if len(stsRecord) > 0 {
-		fmt.Printf("STS Found. STS Record:\n\t %s\n\n", stsRecord)
+	if _, ok := resolver.(*zoneResolver); ok {
+		result.skipCheck(checkNSConsistency, "records come from -zonefile")
+	} else if opts.checkNSConsistency {
+		compareNameservers(result, domain)
 	} else {
-		fmt.Printf("ERROR: STS Failed, DNS record not found\n\n")
+		result.skipCheck(checkNSConsistency, "-check-ns-consistency not set")
 	}
+	return result
+}
 
-	// HTTP lookup
-	policyResource := queryHTTPSRecord("https://mta-sts." + *domain + "/.well-known/mta-sts.txt")
-	policyRows := strings.Split(policyResource, "\n")
+// policyPhase fetches the policy and, once it is known to be served,
+// compares it across address families. The policy-fetch check is left open
+// so validate can add the findings that need the TXT record as well.
+func policyPhase(domain string, opts *options) (policy *Result, dualStack *Result) {
+	policy, dualStack = &Result{Domain: domain}, &Result{Domain: domain}
+
+	host := "mta-sts." + domain
+	policyURL := "https://" + host + "/.well-known/mta-sts.txt"
+	policyResource, attempts, err := queryHTTPSRecord(policyURL, opts.retries)
+	policy.PolicyAttempts = attempts
+	policy.Raw = &rawArtifacts{Policy: newRawPolicy(policyResource, opts.includeRaw)}
+	if policyResource != nil {
+		policy.PolicyAddress, policy.PolicyTLSState = policyResource.Address, policyResource.TLS
+	}
+	if opts.tlsDebug {
+		var handshake *handshakeError
+		if policyResource != nil {
+			policy.PolicyTLSDebug = policyResource.TLSDebug
+		} else if errors.As(err, &handshake) {
+			policy.PolicyTLSDebug = handshake.debug
+		}
+	}
+	checkPolicyCert(policy, host, policyResource)
+	if attempts > 1 && err == nil {
+		policy.warnf("POLICY-TLS-RETRIED", host, "the TLS handshake with the policy host failed and only succeeded on attempt %d on a fresh connection; senders that don't retry see no policy",
+			attempts)
+	}
+	var connect *connectError
+	if redirect, ok := err.(*redirectError); ok {
+		policy.PolicyRedirects = traceRedirects(policyURL, redirect, opts.maxRedirectsShown)
+		policy.errorf("POLICY-REDIRECT", policyURL, "the policy host redirects instead of serving the policy, redirect chain: %s",
+			strings.Join(policy.PolicyRedirects, " -> "))
+	} else if errors.As(err, &connect) {
+		policy.errorf("POLICY-HOST-UNREACHABLE", host, "%s resolves to %s but accepts no HTTPS connection on port 443: %v",
+			host, strings.Join(connect.addresses, ", "), connect.err)
+	} else if err != nil {
+		if attempts > 1 {
+			err = fmt.Errorf("%v (after %d TLS handshake attempts)", err, attempts)
+		}
+		policy.errorf("POLICY-FETCH-FAILED", policyURL, "STS Failed HTTPS record not found: %v", err)
+	} else {
+		policy.Policy = policyResource.Body
+		if policyResource.ContentEncoding != "" {
+			policy.PolicyEncoding = policyResource.ContentEncoding
+			policy.warnf("POLICY-CONTENT-ENCODED", policyURL, "the policy was served with Content-Encoding %s; it was decompressed here, but senders may not, serve it uncompressed as text/plain",
+				policyResource.ContentEncoding)
+		}
+		checkPolicyLatency(policy, host, policyResource, opts.policyLatencyWarn)
+		if policyResource.TLS != nil {
+			policy.PolicyTLSVersion = tls.VersionName(policyResource.TLS.Version)
+			checkTLSVersion(policy, host, policyResource.TLS, opts)
+		}
+	}
+
+	if policy.Policy != "" {
+		compareDualStack(dualStack, host, policyURL)
+		if opts.recheckCount > 0 {
+			recheckPolicy(dualStack, host, policyURL, opts.recheckCount, policyResource)
+		} else {
+			dualStack.skipCheck(checkRecheck, "-recheck-count not set")
+		}
+		if opts.policyOrigin != "" {
+			compareOrigin(dualStack, host, policyURL, opts.policyOrigin, policyResource)
+		} else {
+			dualStack.skipCheck(checkOrigin, "-policy-origin not set")
+		}
+	}
+	return policy, dualStack
+}
+
+// tlsrptPhase looks up the TLS reporting record.
+func tlsrptPhase(domain string, opts *options) *Result {
+	result := &Result{Domain: domain}
+
+	mark := result.beginCheck()
+	rptName := "_smtp._tls." + domain
+	var txt []string
+	var err error
+	result.TLSRPTRecord, txt, err = rptDNSCheck(rptName)
+	result.Raw = &rawArtifacts{TLSRPTTXT: txt}
+	if isDNSTimeout(err) {
+		result.errorf("DNS-TIMEOUT", rptName, "RPT Failed, DNS lookup timed out: %v", err)
+	} else if err != nil {
+		result.errorf("TLSRPT-LOOKUP-FAILED", rptName, "RPT Failed, DNS lookup failed: %v", err)
+	} else if result.TLSRPTRecord == "" {
+		if opts.failOnMissingTLSRPT {
+			result.errorf("TLSRPT-MISSING", rptName, "RPT Failed, DNS record not found (required by -fail-on-missing-tlsrpt)")
+		} else {
+			result.warnf("TLSRPT-MISSING", rptName, "RPT Failed, DNS record not found")
+		}
+	}
+	result.endCheck(checkTLSRPT, mark)
+	return result
+}
+
+// validatePolicy checks the fetched policy resource and its mx entries
+// against the live MX records.
+func validatePolicy(result *Result, mxRecords []string) {
+	mark := result.beginCheck()
+	policyRows := strings.Split(result.Policy, "\n")
+
+	// Every key check would fail on a policy that isn't split into lines,
+	// so that is the one finding.
+	if problem := notLineDelimited(policyRows); problem != "" {
+		result.errorf("POLICY-NOT-LINE-DELIMITED", "", "policy resource does not appear to be newline-delimited: %s", problem)
+		result.endCheck(checkSyntax, mark)
+		result.skipCheck(checkMXCoverage, "policy is not newline-delimited")
+		return
+	}
+
+	// A key with nothing but whitespace after the colon is reported as such
+	// rather than as a wrong value.
+	empty := make(map[string]bool)
+	for _, key := range emptyValueKeys(policyRows) {
+		empty[key] = true
+		result.errorf("POLICY-VALUE-EMPTY", key, "%s has an empty value", key)
+	}
+
+	checkLineEndings(result, policyRows)
+	for _, line := range policyRows {
+		if trimmed := strings.TrimSpace(line); trimmed != "" && !strings.Contains(trimmed, ":") {
+			result.errorf("POLICY-LINE-INVALID", shorten(trimmed, 64), "line %q is not a key: value pair, senders may reject the whole policy", shorten(trimmed, 64))
+		}
+	}
 
 	// Validate policy resource records
 	if !hasKey(policyRows, "version") {
-		fmt.Println("Error the policy resource must contain a version field")
+		result.errorf("POLICY-VERSION-MISSING", "", "the policy resource must contain a version field")
 	}
 
-	if valueForKey(policyRows, "version") != "STSv1" {
-		fmt.Println("Error version must equal 'STSv1'")
+	if version := valueForKey(policyRows, "version"); version != "STSv1" && !(version == "" && empty["version"]) {
+		result.errorf("POLICY-VERSION-INVALID", "", "version must equal 'STSv1'")
 	}
 
 	mode := valueForKey(policyRows, "mode")
-	if mode != "report" && mode != "enforce" && mode != "none" {
-		fmt.Printf("Error mode must be one of 'report', 'enforce', 'none' but was %s", mode)
+	result.Mode = mode
+	modes := validModes(result.Spec)
+	switch {
+	case contains(modes, mode):
+	case mode == "" && empty["mode"]:
+	case mode == "report" && result.Spec != specDraft10:
+		// Draft versions called testing "report"; it is still accepted.
+		result.warnf("POLICY-MODE-DEPRECATED", mode, "mode 'report' is the pre-RFC name of 'testing', use 'mode: testing' instead")
+	default:
+		result.errorf("POLICY-MODE-INVALID", "", "mode must be one of '%s' but was %s", strings.Join(modes, "', '"), mode)
 	}
 
 	if !hasKey(policyRows, "max_age") {
-		fmt.Printf("Error policy resource should have a 'max_age' field.")
+		result.errorf("POLICY-MAX-AGE-MISSING", "", "policy resource should have a 'max_age' field.")
+	}
+	result.MaxAge = valueForKey(policyRows, "max_age")
+	if hasKey(policyRows, "max_age") && !(result.MaxAge == "" && empty["max_age"]) {
+		checkMaxAge(result, result.MaxAge)
 	}
 
 	allKeys := allKeys(policyRows)
 	for _, key := range allKeys {
 		if key != "" && key != "version" && key != "mode" && key != "max_age" && key != "mx" {
-			fmt.Printf("Error unknown key in policy [%s]\n", key)
+			result.warnf("POLICY-UNKNOWN-KEY", key, "unknown key in policy [%s]", key)
 		}
 	}
 
 	mxs := valuesForKey(policyRows, "mx")
-	for _, record := range mxRecords {
-		if len(record) > 0 {
-			if !mxHasMatch(mxs, record) {
-				fmt.Printf("Error undefined MX record [%s]\n", record)
+	invalid := make(map[string]bool)
+	for _, mx := range mxs {
+		if len(mx) > 0 {
+			result.PolicyMX = append(result.PolicyMX, mx)
+			if strings.HasSuffix(mx, ".") && normalizeDomain(mx) != "" {
+				result.infof("POLICY-MX-TRAILING-DOT", mx, "mx value has a trailing dot, it is matched as %s", normalizeDomain(mx))
+			}
+			if want, ok := otherWildcardSyntax(result.Spec, normalizeDomain(mx)); ok {
+				result.warnf("POLICY-MX-WILDCARD-SYNTAX", mx, "%s uses the wildcard syntax of the other spec and matches nothing under %s, write it as %s",
+					mx, specNames[result.Spec], want)
+			}
+			if _, err := toASCII(strings.TrimSpace(mx)); err != nil {
+				result.warnf("IDNA-INVALID", mx, "mx value cannot be converted to A-label form and is compared as written: %v", err)
+			}
+			if problem := mxSyntaxProblem(mx); problem != "" {
+				invalid[mx] = true
+				result.warnf("POLICY-MX-INVALID-SYNTAX", mx, "mx value %s %s", mx, problem)
 			}
 		}
 	}
+	checkDuplicateMX(result, mxs)
+	result.endCheck(checkSyntax, mark)
 
-	rptRecord := rptDNSCheck("_smtp-tlsrpt." + *domain)
-	if len(rptRecord) > 0 {
-		fmt.Printf("RPT Found. TLSPRT Record:\n\t %s\n\n", rptRecord)
-	} else {
-		fmt.Printf("ERROR: RPT Failed, DNS record not found\n\n")
+	if result.MXLookupError != "" {
+		result.skipCheck(checkMXCoverage, "MX lookup failed; policy checks performed without MX reconciliation")
+		return
+	}
+	if len(mxRecords) == 0 {
+		result.skipCheck(checkMXCoverage, "no MX hosts")
+		return
+	}
+	mark = result.beginCheck()
+	result.MXCoverage = coverMX(result.Spec, result.PolicyMX, mxRecords)
+	for _, hc := range result.MXCoverage.Hosts {
+		if !hc.Covered {
+			result.errorf("STS-MX-UNDECLARED", hc.Host, "undefined MX record [%s]", displayName(hc.Host))
+		}
 	}
 
+	// A pattern nothing matches is likely stale or a typo. Invalid ones are
+	// already reported.
+	if mode != "none" {
+		for _, pc := range result.MXCoverage.Patterns {
+			if len(pc.Hosts) == 0 && !invalid[pc.Pattern] {
+				result.warnf("POLICY-MX-UNUSED", pc.Pattern, "mx pattern [%s] matches none of the live MX hosts", displayName(pc.Pattern))
+			}
+		}
+	}
+	result.endCheck(checkMXCoverage, mark)
 }
 
-// .example.com matches x.example.com but not x.y.example.com.
-func mxHasMatch(declaredMXs []string, mxHost string) bool {
-	for _, mx := range declaredMXs {
-		if strings.HasPrefix(mx, ".") {
-			i := strings.Index(mxHost, ".")
-			baseHost := mxHost[i:]
-			if baseHost == mx {
-				return true
+// printResult renders the outcome of validate.
+func printResult(result *Result, opts *options) {
+	fmt.Printf("Validating %s against %s\n", displayName(result.Domain), specNames[result.Spec])
+	if result.PolicyHostUnicode != "" {
+		fmt.Printf("Policy host %s, fetched as %s\n", result.PolicyHostUnicode, result.PolicyHost)
+	}
+	if result.DNSSource != "" {
+		fmt.Printf("MX and TXT records from %s, not DNS\n", result.DNSSource)
+	}
+	if opts.verbose && result.AddressSelection != "" {
+		fmt.Printf("Address selection: %s\n", result.AddressSelection)
+	}
+	fmt.Println()
+	for _, mx := range result.MX {
+		printMX(mx)
+	}
+	fmt.Println()
+
+	if result.STSRecord != "" {
+		fmt.Printf("STS Found. STS Record:\n\t %s\n\n", result.STSRecord)
+	}
+
+	if result.Policy != "" {
+		fmt.Println("STS HTTPS Record:\n------------------")
+		fmt.Println(result.Policy)
+		fmt.Printf("Fetched in %dms, first byte after %dms", result.PolicyFetchMillis, result.PolicyTTFBMillis)
+		if result.PolicyAddress != "" {
+			fmt.Printf(" from %s", result.PolicyAddress)
+		}
+		if result.PolicyAttempts > 1 {
+			fmt.Printf(", after %d TLS handshake attempts", result.PolicyAttempts)
+		}
+		fmt.Printf("\n\n")
+	}
+	if len(result.PolicyVariants) > 1 {
+		fmt.Println("Policy variants seen by -recheck-count:")
+		for _, variant := range result.PolicyVariants {
+			fmt.Printf("\tsha256 %s, %s, from %s:\n", shorten(variant.SHA256, 16), plural(variant.Count, "time"), display(strings.Join(variant.Addresses, ", ")))
+			for _, line := range strings.Split(strings.TrimRight(variant.Body, "\r\n"), "\n") {
+				fmt.Printf("\t\t%s\n", strings.TrimRight(line, "\r"))
 			}
+		}
+		fmt.Println()
+	}
+	if origin := result.PolicyOrigin; origin != nil && origin.OriginBody != "" {
+		fmt.Printf("Policy served by the origin %s:\n", origin.Origin)
+		for _, line := range strings.Split(strings.TrimRight(origin.OriginBody, "\r\n"), "\n") {
+			fmt.Printf("\t%s\n", strings.TrimRight(line, "\r"))
+		}
+		fmt.Println()
+	}
+	if result.MXCoverage != nil {
+		printCoverage(result.MXCoverage)
+	}
+	if result.PolicyTLSDebug != nil {
+		fmt.Println("Policy host connection:")
+		printTLSDebug(result.PolicyTLSDebug)
+		fmt.Println()
+	}
 
-		} else if mx == mxHost {
-			return true
+	if result.TLSRPTRecord != "" {
+		fmt.Printf("RPT Found. TLSPRT Record:\n\t %s\n\n", result.TLSRPTRecord)
+	}
+
+	for _, finding := range result.Findings {
+		if finding.Code == "DEPLOYMENT-DNS-WITHOUT-POLICY" && !finding.Suppressed {
+			fmt.Println("\x1b[31;1m!!! HALF DEPLOYED: TXT record published but policy unavailable !!!\x1b[0m")
+			fmt.Println()
 		}
 	}
-	return false
+
+	// The policy looking fine must not be mistaken for a clean pass when
+	// the MX hosts it should cover are unknown.
+	if result.MXLookupError != "" && result.Policy != "" {
+		fmt.Printf("\x1b[33;1mPARTIAL RESULT: MX lookup failed (%s); policy checks performed without MX reconciliation\x1b[0m\n\n", result.MXLookupError)
+	}
+
+	// Workflow command output annotates the findings instead.
+	if opts.format != formatGHA {
+		printFindings(result, opts.verbose)
+		fmt.Println()
+	}
+
+	if opts.verbose {
+		printChecks(result)
+		fmt.Printf("\nPolicy fetched with User-Agent: %s\n", policyUserAgent)
+	}
+
+	if opts.verbose {
+		fmt.Println()
+	}
+	fmt.Printf("DEPLOYMENT STATE %s: %s\n", result.Domain, result.DeploymentState)
+	if opts.format != formatGHA {
+		fmt.Println(statusLine(result))
+		fmt.Println(result.Verdict.line(result.Domain))
+	}
+}
+
+// parseIgnore turns the -ignore list into a set. Unknown codes are reported
+// so a typo doesn't silently ignore nothing.
+func parseIgnore(list string) map[string]bool {
+	ignore := make(map[string]bool)
+	for _, code := range strings.Split(list, ",") {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code == "" {
+			continue
+		}
+		if _, ok := findingCodes[code]; !ok {
+			fmt.Fprintf(os.Stderr, "Warning: unknown finding code %s in -ignore\n", code)
+		}
+		ignore[code] = true
+	}
+	return ignore
+}
+
+// annotate applies the settings in opts to the findings: the severity map,
+// strict gates, suppression of ignored codes, explanations and hints. The
+// findings are grouped and the verdict decided last, once suppression is
+// known.
+func annotate(result *Result, opts *options) {
+	if opts.severities != nil {
+		applySeverityMap(result, opts.severities)
+	}
+	if opts.assertNoUnknownKeys {
+		for i, finding := range result.Findings {
+			if finding.Code == "POLICY-UNKNOWN-KEY" && finding.Severity != SeverityError {
+				result.Findings[i].Severity = SeverityError
+				result.Findings[i].Message += " (fails the run with -assert-no-unknown-keys)"
+			}
+		}
+	}
+	for i, finding := range result.Findings {
+		if opts.ignore[finding.Code] && !finding.Suppressed {
+			result.Findings[i].Suppressed = true
+			result.Findings[i].SuppressedBy = "-ignore"
+		}
+	}
+	if opts.explain {
+		explainFindings(result)
+	}
+	if !opts.quiet {
+		addHints(result)
+	}
+	finishChecks(result)
+	result.Grouped = groupFindings(result.Findings)
+	result.Verdict = decide(result)
+	result.Status = overallStatus(result)
+	result.DeploymentState = deploymentState(result, result.Verdict)
+}
+
+// writeJSON prints v as indented JSON on stdout.
+func writeJSON(v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	fmt.Println(string(data))
+}
+
+// Repeating the same mx value is legal but redundant, usually a copy-paste error.
+func checkDuplicateMX(result *Result, mxs []string) {
+	seen := make(map[string]int)
+	var duplicates []string
+	for _, value := range mxs {
+		mx := normalizeDomain(value)
+		if mx == "" {
+			continue
+		}
+		seen[mx]++
+		if seen[mx] == 2 {
+			duplicates = append(duplicates, mx)
+		}
+	}
+
+	count := 0
+	for _, mx := range duplicates {
+		count += seen[mx] - 1
+	}
+	if count > 0 {
+		result.warnf("POLICY-MX-DUPLICATE", "", "%d duplicate mx entries in policy: %s", count, strings.Join(duplicates, ", "))
+	}
+}
+
+// *.example.com (.example.com in the draft) matches x.example.com but not
+// x.y.example.com.
+func mxHasMatch(spec string, declaredMXs []string, mxHost string) bool {
+	return mxMatch(spec, declaredMXs, mxHost) != ""
 }
 
+// mxMatch returns the first declared pattern matching mxHost, or "".
+// Both sides are compared in their normalizeDomain form.
+func mxMatch(spec string, declaredMXs []string, mxHost string) string {
+	host := normalizeDomain(mxHost)
+	if host == "" {
+		return ""
+	}
+	for _, declared := range declaredMXs {
+		mx := normalizeDomain(declared)
+		if mx == "" {
+			continue
+		}
+		if suffix, ok := wildcardSuffix(spec, mx); ok {
+			i := strings.Index(host, ".")
+			if i >= 0 && host[i:] == suffix {
+				return declared
+			}
+
+		} else if mx == host {
+			return declared
+		}
+	}
+	return ""
+}
+
+// policyKeyToken finds the known keys of a policy anywhere in a line.
+var policyKeyToken = regexp.MustCompile(`(?i)(?:^|[\s;,])(?:version|mode|mx|max_age)\s*:`)
+
+// maxPolicyLineLength is longer than any sensible policy line; an mx
+// pattern is at most 255 bytes.
+const maxPolicyLineLength = 1024
+
+// notLineDelimited describes why a policy of a single line looks like
+// several lines run together, a serving bug that strips or replaces the
+// newlines, or returns "".
+func notLineDelimited(rows []string) string {
+	var lines []string
+	for _, row := range rows {
+		if strings.TrimSpace(row) != "" {
+			lines = append(lines, row)
+		}
+	}
+	if len(lines) != 1 {
+		return ""
+	}
+	line := strings.TrimRight(lines[0], "\r")
+	switch keys := len(policyKeyToken.FindAllStringIndex(line, -1)); {
+	case strings.Contains(line, "\r") && keys > 1:
+		return fmt.Sprintf("its lines are separated by CR alone, which leaves %d keys on one line", keys)
+	case keys > 1:
+		return fmt.Sprintf("its only line holds %d keys: %s", keys, shorten(line, 64))
+	case len(line) > maxPolicyLineLength:
+		return fmt.Sprintf("its only line is %d bytes long", len(line))
+	}
+	return ""
+}
+
+// checkLineEndings warns about a policy whose lines end in a mix of CRLF
+// and LF. Both are allowed and the checks here don't care, but a mix means
+// the file was assembled or edited by tools that disagree, and a parser
+// that strips only one kind leaves a stray CR on some values.
+func checkLineEndings(result *Result, rows []string) {
+	crlf, lf := 0, 0
+	for _, line := range rows[:len(rows)-1] {
+		if strings.HasSuffix(line, "\r") {
+			crlf++
+		} else {
+			lf++
+		}
+	}
+	if crlf > 0 && lf > 0 {
+		result.warnf("POLICY-MIXED-LINE-ENDINGS", "", "the policy mixes line endings: %s end in CRLF, %d in LF only", plural(crlf, "line"), lf)
+	}
+}
+
+// A line without a colon has no key; hasKey, valueForKey and valuesForKey
+// skip such lines, validatePolicy reports them.
 func hasKey(rows []string, key string) bool {
 	for _, line := range rows {
-		if strings.HasPrefix(line, key) {
+		if strings.HasPrefix(line, key) && strings.Contains(line, ":") {
 			return true
 		}
 	}
@@ -125,7 +1014,10 @@ func hasKey(rows []string, key string) bool {
 func valueForKey(rows []string, key string) string {
 	for _, line := range rows {
 		if strings.HasPrefix(line, key) {
-			fields := strings.Split(line, ":")
+			fields := strings.SplitN(line, ":", 2)
+			if len(fields) < 2 {
+				continue
+			}
 			return strings.TrimSpace(fields[1])
 		}
 	}
@@ -136,7 +1028,12 @@ func valuesForKey(rows []string, key string) []string {
 	results := make([]string, 1, 4)
 	for _, line := range rows {
 		if strings.HasPrefix(line, key) {
-			fields := strings.Split(line, ":")
+			// Split at the first colon only, so a misplaced port or URL
+			// survives for the mx syntax check.
+			fields := strings.SplitN(line, ":", 2)
+			if len(fields) < 2 {
+				continue
+			}
 			value := strings.TrimSpace(fields[1])
 			results = append(results, value)
 		}
@@ -144,119 +1041,150 @@ func valuesForKey(rows []string, key string) []string {
 	return results
 }
 
+// emptyValueKeys returns the keys, in order, of the rows that have nothing
+// but whitespace after the colon.
+func emptyValueKeys(rows []string) []string {
+	var keys []string
+	for _, line := range rows {
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		if key := strings.TrimSpace(line[:i]); key != "" && strings.TrimSpace(line[i+1:]) == "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
 func allKeys(rows []string) []string {
 	keys := make([]string, 1, 4)
 
 	for _, line := range rows {
 		fields := strings.Split(line, ":")
 		key := strings.TrimSpace(fields[0])
-		if key != "" {
+		if key != "" && len(fields) > 1 {
 			keys = append(keys, key)
 		}
 	}
 	return keys
 }
 
-func mxRecords(domain string) []string {
-	mxs, err := net.LookupMX(domain)
+// lookupMX returns the MX records of domain with their preferences.
+func lookupMX(domain string) ([]*net.MX, error) {
+	ctx, cancel := dnsContext()
+	defer cancel()
+	return resolver.LookupMX(ctx, domain)
+}
+
+func mxRecords(domain string) ([]string, error) {
+	mxs, err := lookupMX(domain)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	records := make([]string, 1, 4)
+	records := make([]string, 0, len(mxs))
 	for _, mx := range mxs {
 		var buf bytes.Buffer
 		fmt.Fprintf(&buf, "%s", mx.Host)
 		records = append(records, normalizeDomain(buf.String()))
 	}
-	return records
+	return records, nil
 }
 
-func stsDNSCheck(domain string) string {
-	txt, err := net.LookupTXT(domain)
+// stsDNSCheck returns the STS records for domain and the number of TXT
+// records examined to find them. A name without any TXT records is not an
+// error, it just yields zero records.
+func stsDNSCheck(domain string) ([]string, []string, error) {
+	ctx, cancel := dnsContext()
+	defer cancel()
+	txt, err := resolver.LookupTXT(ctx, domain)
 	if err != nil {
-		fmt.Println(err)
-	} else {
-		// If we get multiple TXT records ours starts with "v=STSv1;"
-		// See: https://tools.ietf.org/html/draft-ietf-uta-mta-sts-10#section-3.1
-		for _, element := range txt {
-			if strings.HasPrefix(element, "v=STSv1; ") {
-				return element
-			}
+		if isNotFound(err) {
+			return nil, nil, nil
 		}
+		return nil, nil, err
 	}
-	return ""
+	return findSTSRecords(txt), txt, nil
 }
 
-func rptDNSCheck(domain string) string {
-	txt, err := net.LookupTXT(domain)
-	if err != nil {
-		fmt.Println(err)
-	} else {
-		// If we get multiple TXT records ours starts with "v=TLSRPTv1;"
-		// See:https://tools.ietf.org/html/draft-ietf-uta-smtp-tlsrpt-10
-		for _, element := range txt {
-			if strings.HasPrefix(element, "v=TLSRPTv1") {
-				return element
-			}
-		}
+// findSTSRecord picks the STS record out of the TXT records of a name.
+func findSTSRecord(txt []string) string {
+	if records := findSTSRecords(txt); len(records) > 0 {
+		return records[0]
 	}
 	return ""
 }
 
-func tlsTest(host string, port string) {
-
-	smtpserver := host + ":" + port
-	//fmt.Printf("Tesing: %s\n", smtpserver)
-
-	config := &tls.Config{ServerName: host}
+// stsRecordStart matches the version field an STS record begins with and
+// the delimiter after it, which may have whitespace on either side
+// (RFC 8461 §3.1). The version is case-sensitive.
+var stsRecordStart = regexp.MustCompile(`^v=STSv1[ \t]*;`)
 
-	c, err := smtp.Dial(smtpserver)
-	if err != nil {
-		log.Printf("Could not connect to %s:%s\n", host, port)
-		log.Printf("\x1b[31;1mError\x1b[0m  \"%v\"\n", err)
-		return
+// findSTSRecords returns every STS record among the TXT records of a name.
+// There should be exactly one. The fields after the version are left to
+// checkSTSRecordFields.
+func findSTSRecords(txt []string) []string {
+	// If we get multiple TXT records ours starts with "v=STSv1;"
+	// See: https://tools.ietf.org/html/rfc8461#section-3.1
+	var records []string
+	for _, element := range txt {
+		if stsRecordStart.MatchString(element) {
+			records = append(records, element)
+		}
 	}
+	return records
+}
 
-	err = c.StartTLS(config)
+// rptDNSCheck looks up the TLSRPT record. A name that does not exist is
+// no record, not an error.
+func rptDNSCheck(domain string) (string, []string, error) {
+	ctx, cancel := dnsContext()
+	defer cancel()
+	txt, err := resolver.LookupTXT(ctx, domain)
 	if err != nil {
-		errorMsg := fmt.Sprintf("\x1b[31;1mError:\x1b[0m [%s:%s] failed with error message\n\t\x1b[31;1m%s %s\x1b[0m", host, port, host, err)
-
-		log.Println(errorMsg)
-	} else {
-		log.Println("✔ ", host, " certificate is good")
+		if isNotFound(err) {
+			return "", nil, nil
+		}
+		return "", nil, err
 	}
 
-}
-
-func queryHTTPSRecord(url string) string {
-	response, err := http.Get(url)
-	if err != nil {
-		log.Fatal(err)
-	} else {
-		defer response.Body.Close()
-		responseData, err := ioutil.ReadAll(response.Body)
-		if err != nil {
-			fmt.Println("STS Failed HTTPS record not found")
-			log.Fatal(err)
-		} else {
-			fmt.Println("STS HTTPS Record:\n------------------")
-			responseString := string(responseData)
-			fmt.Println(responseString)
-			return responseString
+	// If we get multiple TXT records ours starts with "v=TLSRPTv1;"
+	// See: https://tools.ietf.org/html/rfc8460#section-3
+	for _, element := range txt {
+		if strings.HasPrefix(element, "v=TLSRPTv1") {
+			return element, txt, nil
 		}
 	}
-	return ""
+	return "", txt, nil
 }
 
+// normalizeDomain is the single normalization applied to every hostname
+// before comparing: lowercase, exactly one trailing dot removed and
+// U-labels converted to A-labels. A name that cannot be converted is
+// compared as it is; the conversion error is reported separately. An empty
+// result means there was no usable name.
 func normalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
 	if strings.HasSuffix(domain, ".") {
-		return trimSuffix(domain, ".")
+		domain = trimSuffix(domain, ".")
+	}
+	if ascii, err := toASCII(domain); err == nil {
+		domain = ascii
 	}
 
 	return domain
 }
 
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
 func trimSuffix(s, suffix string) string {
 	if strings.HasSuffix(s, suffix) {
 		s = s[:len(s)-len(suffix)]
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// The policy file and the TXT record are published by whoever owns the
+// domain, so a batch scan parses hostile input. The fuzz targets require
+// that parsing never panics, that every finding is a documented code, and
+// that nothing passes without a finding unless it is valid. The seeds in
+// testdata/fuzz are valid records and broken ones seen in the wild, and
+// run with every go test.
+
+func FuzzValidatePolicy(f *testing.F) {
+	f.Add(policyOf("version: STSv1", "mode: enforce", "mx: mail.example.com", "max_age: 604800"), "mail.example.com")
+	f.Add("version: STSv1\nmode\nmx: mail.example.com\nmax_age: 86400\n", "mail.example.com")
+	f.Fuzz(func(t *testing.T, policy string, mx string) {
+		if len(policy) > maxPolicySize {
+			t.Skip("larger than any policy fetchPolicy returns")
+		}
+		var mxRecords []string
+		if mx != "" {
+			mxRecords = strings.Split(mx, ",")
+		}
+		for _, spec := range []string{specRFC8461, specDraft10} {
+			result := &Result{Domain: "example.com", Spec: spec, Policy: policy}
+			validatePolicy(result, mxRecords)
+			failed := requireDocumented(t, result)
+			rows := strings.Split(policy, "\n")
+			// mode: report is only deprecated, a warning.
+			mode := contains(validModes(spec), result.Mode) || result.Mode == "report"
+			if !failed && (valueForKey(rows, "version") != "STSv1" || !mode || result.MaxAge == "") {
+				t.Errorf("%s: no error for version %q, mode %q, max_age %q in %q", spec, valueForKey(rows, "version"), result.Mode, result.MaxAge, policy)
+			}
+			canonicalPolicy(policy)
+			suggestedPolicy(result)
+		}
+	})
+}
+
+func FuzzCheckSTSRecordFields(f *testing.F) {
+	f.Add("v=STSv1; id=20240101")
+	f.Add("v=STSv1; id=20240101; id=20240301")
+	f.Fuzz(func(t *testing.T, record string) {
+		records := findSTSRecords([]string{record, "v=spf1 -all"})
+		if len(records) > 1 || len(records) == 1 && records[0] != record {
+			t.Fatalf("findSTSRecords(%q) = %q", record, records)
+		}
+		if len(records) == 0 {
+			return
+		}
+		for _, spec := range []string{specRFC8461, specDraft10} {
+			result := &Result{Domain: "example.com", Spec: spec}
+			checkSTSRecordFields(result, "_mta-sts.example.com", record)
+			if failed := requireDocumented(t, result); !failed && spec == specRFC8461 && !validSTSID(record) {
+				t.Errorf("no error for %q, which has no single valid id", record)
+			}
+		}
+	})
+}
+
+// requireDocumented fails the test for each finding of result whose code is
+// not in findingCodes, and reports whether result has an error.
+func requireDocumented(t *testing.T, result *Result) bool {
+	t.Helper()
+	failed := false
+	for _, f := range result.Findings {
+		if _, ok := findingCodes[f.Code]; !ok {
+			t.Errorf("undocumented finding %s: %s", f.Code, f.Message)
+		}
+		failed = failed || f.Severity == SeverityError
+	}
+	return failed
+}
+
+// validSTSID reports whether record has exactly one id field and its value
+// is a valid RFC 8461 id.
+func validSTSID(record string) bool {
+	var ids []string
+	for _, field := range strings.Split(record, ";") {
+		if key, value, _ := strings.Cut(strings.TrimSpace(field), "="); key == "id" {
+			ids = append(ids, value)
+		}
+	}
+	return len(ids) == 1 && stsIDPattern.MatchString(ids[0])
+}
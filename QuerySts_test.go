@@ -0,0 +1,489 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// findingsOf returns the findings of result with the given code.
+func findingsOf(result *Result, code string) []Finding {
+	var found []Finding
+	for _, f := range result.Findings {
+		if f.Code == code {
+			found = append(found, f)
+		}
+	}
+	return found
+}
+
+// messagesOf returns the messages of the findings of result with the given
+// code.
+func messagesOf(result *Result, code string) []string {
+	var messages []string
+	for _, f := range findingsOf(result, code) {
+		messages = append(messages, f.Message)
+	}
+	return messages
+}
+
+// policyOf joins policy lines the way they are served.
+func policyOf(lines ...string) string {
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+func TestDuplicateMX(t *testing.T) {
+	tests := []struct {
+		name string
+		mx   []string
+		want []string
+	}{
+		{"distinct", []string{"mx1.example.com", "mx2.example.com", "*.example.com"}, nil},
+		{"repeated once", []string{"mx1.example.com", "mx2.example.com", "mx1.example.com"},
+			[]string{"1 duplicate mx entries in policy: mx1.example.com"}},
+		{"repeated twice", []string{"mx1.example.com", "mx1.example.com", "mx1.example.com"},
+			[]string{"2 duplicate mx entries in policy: mx1.example.com"}},
+		{"two patterns repeated", []string{"*.example.com", "mx1.example.com", "*.example.com", "mx1.example.com"},
+			[]string{"2 duplicate mx entries in policy: *.example.com, mx1.example.com"}},
+		{"empty values", []string{"", "", "mx1.example.com"}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines := []string{"version: STSv1", "mode: enforce", "max_age: 86400"}
+			for _, mx := range test.mx {
+				lines = append(lines, "mx: "+mx)
+			}
+			result := &Result{Domain: "example.com", Policy: policyOf(lines...)}
+			validatePolicy(result, nil)
+			if got := messagesOf(result, "POLICY-MX-DUPLICATE"); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("POLICY-MX-DUPLICATE = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSTSRecordLookup(t *testing.T) {
+	unrelated := []string{"v=spf1 include:_spf.example.com ~all", "google-site-verification=abc123", "MS=ms12345678"}
+	tests := []struct {
+		name     string
+		txt      []string
+		err      error
+		code     string
+		examined int
+		message  string
+	}{
+		{"only unrelated records", unrelated, nil, "STS-TXT-MISSING", 3,
+			"STS Failed, DNS lookup succeeded but no STS record among 3 TXT records"},
+		{"no records", nil, nil, "STS-TXT-MISSING", 0,
+			"STS Failed, DNS lookup succeeded but no STS record among 0 TXT records"},
+		{"lookup failed", nil, errServFail, "STS-TXT-LOOKUP-FAILED", 0, ""},
+		{"lookup timed out", nil, errTimeout, "DNS-TIMEOUT", 0, ""},
+		{"record among unrelated", append([]string{"v=STSv1; id=20240101"}, unrelated...), nil, "", 4, ""},
+	}
+	lookupCodes := []string{"STS-TXT-MISSING", "STS-TXT-LOOKUP-FAILED", "DNS-TIMEOUT"}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakeResolver{txt: map[string][]string{}, errs: map[string]error{}}
+			if test.txt != nil {
+				fake.txt["_mta-sts.example.com"] = test.txt
+			}
+			if test.err != nil {
+				fake.errs["_mta-sts.example.com"] = test.err
+			}
+			useResolver(t, fake)
+
+			result := stsPhase("example.com", &options{spec: specRFC8461})
+			for _, code := range lookupCodes {
+				want := 0
+				if code == test.code {
+					want = 1
+				}
+				if got := len(findingsOf(result, code)); got != want {
+					t.Errorf("%d %s findings, want %d", got, code, want)
+				}
+			}
+			if (result.STSLookupError != "") != (test.err != nil) {
+				t.Errorf("STSLookupError = %q, want it set only for a failed lookup", result.STSLookupError)
+			}
+			if result.TXTRecordsExamined != test.examined {
+				t.Errorf("TXTRecordsExamined = %d, want %d", result.TXTRecordsExamined, test.examined)
+			}
+			if messages := messagesOf(result, test.code); test.message != "" && (len(messages) != 1 || messages[0] != test.message) {
+				t.Errorf("%s = %q, want %q", test.code, messages, test.message)
+			}
+		})
+	}
+}
+
+func TestFindSTSRecords(t *testing.T) {
+	tests := []struct {
+		record string
+		want   bool
+	}{
+		{"v=STSv1; id=20240101", true},
+		{"v=STSv1;id=20240101", true},
+		{"v=STSv1 ; id=20240101", true},
+		{"v=STSv1\t;\tid=20240101", true},
+		{"v=STSv1;", true},
+		{"v=stsv1; id=20240101", false},
+		{"v=STSv10; id=20240101", false},
+		{"v=STSv1 id=20240101", false},
+		{" v=STSv1; id=20240101", false},
+		{"v=spf1 -all", false},
+	}
+	for _, test := range tests {
+		if got := len(findSTSRecords([]string{test.record})) == 1; got != test.want {
+			t.Errorf("findSTSRecords(%q) found a record: %v, want %v", test.record, got, test.want)
+		}
+	}
+}
+
+func TestSTSDuplicateFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		txt    []string
+		code   string
+		values string
+	}{
+		{"repeated id", []string{"v=STSv1; id=20240101; id=20240301"}, "TXT-DUPLICATE-FIELD", `"20240101", "20240301"`},
+		{"repeated id without spaces", []string{"v=STSv1;id=20240101;id=20240301"}, "TXT-DUPLICATE-FIELD", `"20240101", "20240301"`},
+		{"repeated version", []string{"v=STSv1; v=STSv1; id=20240101"}, "TXT-DUPLICATE-FIELD", `"STSv1", "STSv1"`},
+		{"two records", []string{"v=STSv1; id=20240101", "v=STSv1;id=20240301"}, "STS-TXT-MULTIPLE", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useResolver(t, &fakeResolver{txt: map[string][]string{"_mta-sts.example.com": test.txt}})
+			result := stsPhase("example.com", &options{spec: specRFC8461})
+			other := map[string]string{"TXT-DUPLICATE-FIELD": "STS-TXT-MULTIPLE", "STS-TXT-MULTIPLE": "TXT-DUPLICATE-FIELD"}[test.code]
+			if got := messagesOf(result, other); len(got) != 0 {
+				t.Errorf("unexpected %s: %q", other, got)
+			}
+			got := messagesOf(result, test.code)
+			if len(got) != 1 || !strings.Contains(got[0], test.values) {
+				t.Errorf("%s = %q, want one naming %s", test.code, got, test.values)
+			}
+		})
+	}
+}
+
+func TestTLSRPTLookup(t *testing.T) {
+	tests := []struct {
+		name string
+		txt  []string
+		err  error
+		code string
+	}{
+		{"record", []string{"v=TLSRPTv1; rua=mailto:tlsrpt@example.com"}, nil, ""},
+		{"no record", nil, nil, "TLSRPT-MISSING"},
+		{"lookup failed", nil, errServFail, "TLSRPT-LOOKUP-FAILED"},
+		{"lookup timed out", nil, errTimeout, "DNS-TIMEOUT"},
+	}
+	lookupCodes := []string{"TLSRPT-MISSING", "TLSRPT-LOOKUP-FAILED", "DNS-TIMEOUT"}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakeResolver{txt: map[string][]string{}, errs: map[string]error{}}
+			if test.txt != nil {
+				fake.txt["_smtp._tls.example.com"] = test.txt
+			}
+			if test.err != nil {
+				fake.errs["_smtp._tls.example.com"] = test.err
+			}
+			useResolver(t, fake)
+
+			result := tlsrptPhase("example.com", &options{})
+			for _, code := range lookupCodes {
+				want := 0
+				if code == test.code {
+					want = 1
+				}
+				if got := len(findingsOf(result, code)); got != want {
+					t.Errorf("%d %s findings, want %d", got, code, want)
+				}
+			}
+			if test.err != nil && !transportCodes[test.code] {
+				t.Errorf("%s is not a transport failure", test.code)
+			}
+		})
+	}
+}
+
+func TestHalfDeployment(t *testing.T) {
+	policy := policyOf("version: STSv1", "mode: testing", "mx: mx1.example.com", "max_age: 86400")
+	tests := []struct {
+		name        string
+		sts         *Result
+		policy      string
+		wantCodes   []string
+		wantState   string
+		wantPublish string
+	}{
+		{"policy without record", &Result{TXTRecordsExamined: 2}, policy,
+			[]string{"DEPLOYMENT-POLICY-WITHOUT-DNS"}, statePolicyOnly, "v=STSv1; id="},
+		{"policy, lookup failed", &Result{STSLookupError: errServFail.Error()}, policy, nil, "", ""},
+		{"policy, lookup timed out", &Result{STSLookupError: errTimeout.Error()}, policy, nil, "", ""},
+		{"record without policy", &Result{STSRecord: "v=STSv1; id=1"}, "",
+			[]string{"DEPLOYMENT-DNS-WITHOUT-POLICY"}, stateDNSOnly, ""},
+		{"both", &Result{STSRecord: "v=STSv1; id=1"}, policy, nil, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := test.sts
+			result.Domain, result.Policy = "example.com", test.policy
+			checkHalfDeployment(result)
+
+			var codes []string
+			for _, f := range result.Findings {
+				codes = append(codes, f.Code)
+			}
+			if !reflect.DeepEqual(codes, test.wantCodes) {
+				t.Errorf("findings %q, want %q", codes, test.wantCodes)
+			}
+			if test.wantPublish != "" && !strings.Contains(result.Findings[0].Message, test.wantPublish) {
+				t.Errorf("message %q does not contain the record to publish", result.Findings[0].Message)
+			}
+			if test.wantState != "" {
+				if state := deploymentState(result, &Verdict{}); state != test.wantState {
+					t.Errorf("deployment state %s, want %s", state, test.wantState)
+				}
+			}
+		})
+	}
+}
+
+func TestMXMatchNormalization(t *testing.T) {
+	tests := []struct {
+		pattern string
+		host    string
+		want    bool
+	}{
+		{"aspmx.l.google.com", "aspmx.l.google.com", true},
+		{"aspmx.l.google.com.", "aspmx.l.google.com", true},
+		{"aspmx.l.google.com", "aspmx.l.google.com.", true},
+		{"aspmx.l.google.com.", "aspmx.l.google.com.", true},
+		{"ASPMX.L.Google.COM", "aspmx.l.google.com", true},
+		{"aspmx.l.google.com", "AspMX.L.GOOGLE.com.", true},
+		{"*.Example.COM.", "MX1.example.com.", true},
+		{"*.example.com", "mx1.sub.example.com", false},
+		{"aspmx.l.google.com..", "aspmx.l.google.com", false},
+		{".", "mx1.example.com", false},
+		{"mx1.example.com", ".", false},
+		{"mx1.example.com", "", false},
+	}
+	for _, test := range tests {
+		if got := mxHasMatch(specRFC8461, []string{test.pattern}, test.host); got != test.want {
+			t.Errorf("mxHasMatch(%q, %q) = %v, want %v", test.pattern, test.host, got, test.want)
+		}
+	}
+}
+
+func TestMXTrailingDot(t *testing.T) {
+	result := &Result{Domain: "example.com", Policy: policyOf("version: STSv1", "mode: enforce", "max_age: 86400",
+		"mx: mx1.example.com.", "mx: MX2.example.com", "mx: mx2.example.com.")}
+	validatePolicy(result, []string{"MX1.example.com", "mx2.example.com."})
+
+	want := []string{"mx value has a trailing dot, it is matched as mx1.example.com", "mx value has a trailing dot, it is matched as mx2.example.com"}
+	if got := messagesOf(result, "POLICY-MX-TRAILING-DOT"); !reflect.DeepEqual(got, want) {
+		t.Errorf("POLICY-MX-TRAILING-DOT = %q, want %q", got, want)
+	}
+	if got := findingsOf(result, "STS-MX-UNDECLARED"); len(got) != 0 {
+		t.Errorf("MX reported as undeclared: %v", got)
+	}
+	if got := messagesOf(result, "POLICY-MX-DUPLICATE"); len(got) != 1 {
+		t.Errorf("POLICY-MX-DUPLICATE = %q, want mx2 counted once as a duplicate", got)
+	}
+}
+
+func TestPolicyMode(t *testing.T) {
+	tests := []struct {
+		spec string
+		mode string
+		want []string
+	}{
+		{specRFC8461, "enforce", nil},
+		{specRFC8461, "testing", nil},
+		{specRFC8461, "none", nil},
+		{specRFC8461, "report", []string{"POLICY-MODE-DEPRECATED"}},
+		{specRFC8461, "Enforce", []string{"POLICY-MODE-INVALID"}},
+		{specRFC8461, "strict", []string{"POLICY-MODE-INVALID"}},
+		{specDraft10, "report", nil},
+		{specDraft10, "testing", []string{"POLICY-MODE-INVALID"}},
+	}
+	for _, test := range tests {
+		result := &Result{Domain: "example.com", Spec: test.spec,
+			Policy: policyOf("version: STSv1", "mode: "+test.mode, "mx: mx1.example.com", "max_age: 604800")}
+		validatePolicy(result, nil)
+		var got []string
+		for _, f := range result.Findings {
+			if strings.HasPrefix(f.Code, "POLICY-MODE-") {
+				got = append(got, f.Code)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s mode %q: %q, want %q", test.spec, test.mode, got, test.want)
+		}
+		if deprecated := findingsOf(result, "POLICY-MODE-DEPRECATED"); len(deprecated) > 0 {
+			if f := deprecated[0]; f.Severity != SeverityWarning || f.Subject != "report" || !strings.Contains(f.Message, "mode: testing") {
+				t.Errorf("POLICY-MODE-DEPRECATED = %+v, want a warning on report naming testing", f)
+			}
+		}
+	}
+}
+
+func TestPolicyValueSpacing(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  []string
+		empty []string
+	}{
+		{"padded values", []string{"version:  STSv1 ", "mode:  enforce ", "mx: mail.example.com ", "max_age:\t604800  "}, nil, nil},
+		{"padded key", []string{"version: STSv1", "mode : enforce", "mx: mail.example.com", "max_age: 604800"}, nil, nil},
+		{"empty mode", []string{"version: STSv1", "mode:", "mx: mail.example.com", "max_age: 604800"}, []string{"POLICY-VALUE-EMPTY"}, []string{"mode"}},
+		{"blank mode", []string{"version: STSv1", "mode: \t ", "mx: mail.example.com", "max_age: 604800"}, []string{"POLICY-VALUE-EMPTY"}, []string{"mode"}},
+		{"empty version and max_age", []string{"version:", "mode: enforce", "mx: mail.example.com", "max_age:  "}, []string{"POLICY-VALUE-EMPTY", "POLICY-VALUE-EMPTY"}, []string{"version", "max_age"}},
+		{"empty mx", []string{"version: STSv1", "mode: enforce", "mx: mail.example.com", "mx: ", "max_age: 604800"}, []string{"POLICY-VALUE-EMPTY"}, []string{"mx"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := &Result{Domain: "example.com", Spec: specRFC8461, Policy: policyOf(test.lines...)}
+			validatePolicy(result, []string{"mail.example.com"})
+			var got, empty []string
+			for _, f := range result.Findings {
+				if f.Severity != SeverityInfo {
+					got = append(got, f.Code)
+				}
+				if f.Code == "POLICY-VALUE-EMPTY" {
+					empty = append(empty, f.Subject)
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) || !reflect.DeepEqual(empty, test.empty) {
+				t.Errorf("findings %q with empty %q, want %q with empty %q", got, empty, test.want, test.empty)
+			}
+			if test.want == nil && (result.Mode != "enforce" || result.MaxAge != "604800" || !reflect.DeepEqual(result.PolicyMX, []string{"mail.example.com"})) {
+				t.Errorf("values not trimmed: mode %q, max_age %q, mx %q", result.Mode, result.MaxAge, result.PolicyMX)
+			}
+		})
+	}
+}
+
+func TestMixedLineEndings(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		want   string
+	}{
+		{"CRLF", "version: STSv1\r\nmode: enforce\r\nmx: mail.example.com\r\nmax_age: 604800\r\n", ""},
+		{"LF", "version: STSv1\nmode: enforce\nmx: mail.example.com\nmax_age: 604800\n", ""},
+		{"LF without a final newline", "version: STSv1\nmode: enforce\nmx: mail.example.com\nmax_age: 604800", ""},
+		{"one LF among CRLF", "version: STSv1\r\nmode: enforce\nmx: mail.example.com\r\nmax_age: 604800\r\n",
+			"the policy mixes line endings: 3 lines end in CRLF, 1 in LF only"},
+		{"alternating", "version: STSv1\r\nmode: enforce\nmx: mail.example.com\r\nmax_age: 604800\n",
+			"the policy mixes line endings: 2 lines end in CRLF, 2 in LF only"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := &Result{Domain: "example.com", Spec: specRFC8461, Policy: test.policy}
+			validatePolicy(result, []string{"mail.example.com"})
+			got := messagesOf(result, "POLICY-MIXED-LINE-ENDINGS")
+			if test.want == "" && len(got) != 0 || test.want != "" && (len(got) != 1 || got[0] != test.want) {
+				t.Errorf("POLICY-MIXED-LINE-ENDINGS = %q, want %q", got, test.want)
+			}
+			if len(result.Findings) != len(got) {
+				t.Errorf("other findings for a valid policy: %+v", result.Findings)
+			}
+			if result.Mode != "enforce" || result.MaxAge != "604800" {
+				t.Errorf("mode %q, max_age %q, want the values without a stray CR", result.Mode, result.MaxAge)
+			}
+		})
+	}
+}
+
+// checkOf returns the named check of result.
+func checkOf(result *Result, name string) *Check {
+	for i := range result.Checks {
+		if result.Checks[i].Name == name {
+			return &result.Checks[i]
+		}
+	}
+	return nil
+}
+
+func TestPolicyWithoutMXReconciliation(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		code       string
+		wantDetail string
+	}{
+		{"lookup failed", errServFail, "MX-LOOKUP-FAILED", "server misbehaving"},
+		{"lookup timed out", errTimeout, "DNS-TIMEOUT", "i/o timeout"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useResolver(t, &fakeResolver{errs: map[string]error{"example.com": test.err}})
+			mail, mxRecords := mailPhase("example.com", &options{})
+
+			result := &Result{Domain: "example.com", Policy: policyOf("version: STSv1", "mode: enforce", "mx: mx1.example.com", "max_age: 604800")}
+			result.merge(mail)
+			result.MXLookupError = mail.MXLookupError
+			validatePolicy(result, mxRecords)
+
+			if !strings.Contains(result.MXLookupError, test.wantDetail) {
+				t.Errorf("MXLookupError = %q, want the lookup error", result.MXLookupError)
+			}
+			if findings := findingsOf(result, test.code); len(findings) != 1 || findings[0].Severity != SeverityError {
+				t.Errorf("%s = %v, want one error", test.code, findings)
+			}
+			if check := checkOf(result, checkMXCoverage); check == nil || check.Status != "skipped" ||
+				check.Reason != "MX lookup failed; policy checks performed without MX reconciliation" {
+				t.Errorf("mx-coverage check = %+v, want skipped without MX reconciliation", check)
+			}
+			if check := checkOf(result, checkSyntax); check == nil || check.Status == "skipped" {
+				t.Errorf("policy syntax check = %+v, want it run", check)
+			}
+			if check := checkOf(result, checkSTARTTLS); check == nil || check.Status != "skipped" {
+				t.Errorf("STARTTLS check = %+v, want it skipped", check)
+			}
+			if len(result.Findings) != 1 {
+				t.Errorf("findings %v, want only the lookup failure", result.Findings)
+			}
+		})
+	}
+}
+
+func TestNotLineDelimited(t *testing.T) {
+	long := "mx: " + strings.Repeat("a", maxPolicyLineLength) + ".example.com"
+	tests := []struct {
+		name   string
+		policy string
+		want   string
+	}{
+		{"lines", policyOf("version: STSv1", "mode: enforce", "mx: mx.example.com", "max_age: 86400"), ""},
+		{"LF only", "version: STSv1\nmode: enforce\nmx: mx.example.com\nmax_age: 86400\n", ""},
+		{"one key", "version: STSv1", ""},
+		{"spaces", "version: STSv1 mode: enforce mx: mx.example.com max_age: 86400", "its only line holds 4 keys"},
+		{"semicolons", "version: STSv1;mode: enforce;mx: mx.example.com;max_age: 86400\r\n", "its only line holds 4 keys"},
+		{"keys in any case", "Version: STSv1, MODE: enforce", "its only line holds 2 keys"},
+		{"CR alone", "version: STSv1\rmode: enforce\rmx: mx.example.com\rmax_age: 86400\r\n", "separated by CR alone, which leaves 4 keys"},
+		{"blank lines around", "\n\nversion: STSv1 mode: enforce\n\n", "its only line holds 2 keys"},
+		{"overlong line", long, "its only line is 1040 bytes long"},
+		{"key names in a value", policyOf("version: STSv1", "mode: enforce", "mx: mode.example.com"), ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := notLineDelimited(strings.Split(test.policy, "\n"))
+			if test.want == "" && got != "" || !strings.Contains(got, test.want) {
+				t.Errorf("notLineDelimited = %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	result := &Result{Domain: "example.com", Policy: "version: STSv1 mode: enforce mx: mx.example.com max_age: 86400"}
+	validatePolicy(result, []string{"mx.example.com"})
+	if len(result.Findings) != 1 || result.Findings[0].Code != "POLICY-NOT-LINE-DELIMITED" {
+		t.Errorf("findings of a policy on one line = %v, want POLICY-NOT-LINE-DELIMITED alone", result.Findings)
+	}
+	if check := checkOf(result, checkMXCoverage); check == nil || check.Status != "skipped" {
+		t.Errorf("mx coverage check = %+v, want it skipped", check)
+	}
+}
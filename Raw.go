@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// rawHeaders are the policy response headers kept in the raw section; the
+// ones that explain how the body was served and cached.
+var rawHeaders = []string{"Content-Type", "Content-Length", "Content-Encoding", "Cache-Control", "Expires",
+	"Last-Modified", "ETag", "Date", "Server", "Location"}
+
+// rawArtifacts is the input a validation was computed from, exactly as it
+// came off the wire, so a result can be re-examined without querying the
+// domain again. The policy body and the EHLO replies can be large and are
+// only kept with -include-raw.
+type rawArtifacts struct {
+	MX        []string   `json:"mx,omitempty"`
+	STSTXT    []string   `json:"sts_txt,omitempty"`
+	TLSRPTTXT []string   `json:"tlsrpt_txt,omitempty"`
+	Policy    *rawPolicy `json:"policy,omitempty"`
+	Probes    []rawProbe `json:"probes,omitempty"`
+}
+
+// rawPolicy is the policy host response. Body is the body before any
+// content encoding was undone, base64 encoded when it is not valid UTF-8.
+type rawPolicy struct {
+	Status       int               `json:"status"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	Body         string            `json:"body,omitempty"`
+	BodyEncoding string            `json:"body_encoding,omitempty"`
+}
+
+// rawProbe is what one MX sent before STARTTLS and the SHA-256
+// fingerprints of the chain it presented, leaf first.
+type rawProbe struct {
+	Host    string   `json:"host"`
+	Address string   `json:"address,omitempty"`
+	Port    string   `json:"port"`
+	Banner  []string `json:"banner,omitempty"`
+	EHLO    []string `json:"ehlo,omitempty"`
+	Chain   []string `json:"chain_sha256,omitempty"`
+}
+
+// newRawPolicy keeps the status and headers of response, and with
+// includeBody the body. It returns nil when there was no HTTP response.
+func newRawPolicy(response *policyResponse, includeBody bool) *rawPolicy {
+	if response == nil {
+		return nil
+	}
+	raw := &rawPolicy{Status: response.StatusCode, Headers: selectHeaders(response.Header)}
+	if includeBody && response.Raw != nil {
+		if utf8.Valid(response.Raw) {
+			raw.Body = string(response.Raw)
+		} else {
+			raw.Body, raw.BodyEncoding = base64.StdEncoding.EncodeToString(response.Raw), "base64"
+		}
+	}
+	return raw
+}
+
+func selectHeaders(header http.Header) map[string]string {
+	selected := make(map[string]string)
+	for _, name := range rawHeaders {
+		if values := header.Values(name); len(values) > 0 {
+			selected[name] = strings.Join(values, ", ")
+		}
+	}
+	if len(selected) == 0 {
+		return nil
+	}
+	return selected
+}
+
+// rawProbes describes every MX probe: the greeting lines, and with
+// includeEHLO the EHLO reply, plus the chain fingerprints.
+func rawProbes(probes []MXResult, includeEHLO bool) []rawProbe {
+	var raws []rawProbe
+	for _, mx := range probes {
+		raw := rawProbe{Host: mx.Host, Address: mx.Address, Port: mx.Port}
+		for _, line := range strings.Split(mx.Session, "\n") {
+			line = strings.TrimRight(line, "\r")
+			switch {
+			case strings.HasPrefix(line, "220"):
+				raw.Banner = append(raw.Banner, line)
+			case includeEHLO && line != "":
+				raw.EHLO = append(raw.EHLO, line)
+			}
+		}
+		if mx.TLSState != nil {
+			for _, cert := range mx.TLSState.PeerCertificates {
+				raw.Chain = append(raw.Chain, sha256Hex(string(cert.Raw)))
+			}
+		}
+		raws = append(raws, raw)
+	}
+	return raws
+}
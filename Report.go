@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Formats rendered as a standalone document rather than the terminal
+// report, see renderDocument.
+const (
+	formatMarkdown = "markdown"
+	formatHTML     = "html"
+)
+
+// fileExtensions is the file extension -output-dir uses for each format.
+var fileExtensions = map[string]string{
+	"json":         "json",
+	formatMarkdown: "md",
+	formatHTML:     "html",
+}
+
+func isDocumentFormat(format string) bool {
+	return format == formatMarkdown || format == formatHTML
+}
+
+// renderDocument renders result as a markdown or HTML report.
+func renderDocument(result *Result, format string) string {
+	if format == formatHTML {
+		var buf bytes.Buffer
+		if err := reportTemplate.Execute(&buf, result); err != nil {
+			return err.Error()
+		}
+		return buf.String()
+	}
+	return renderMarkdown(result)
+}
+
+// markdownCell escapes a value for a markdown table cell.
+var markdownCell = strings.NewReplacer("|", `\|`, "\n", " ")
+
+func renderMarkdown(result *Result) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# MTA-STS report for %s\n\n", result.Domain)
+	if result.Verdict != nil {
+		fmt.Fprintf(&buf, "**Verdict:** %s (%s)  \n", result.Verdict.Status, markdownCell.Replace(result.Verdict.Summary))
+	}
+	if result.DeploymentState != "" {
+		fmt.Fprintf(&buf, "**Deployment state:** %s  \n", result.DeploymentState)
+	}
+	if result.Spec != "" {
+		fmt.Fprintf(&buf, "**Spec:** %s  \n", specNames[result.Spec])
+	}
+	if result.DNSSource != "" {
+		fmt.Fprintf(&buf, "**MX and TXT records from:** %s  \n", result.DNSSource)
+	}
+
+	if len(result.MX) > 0 {
+		fmt.Fprintf(&buf, "\n## MX hosts\n\n| Host | Status | TLS | Certificate expires |\n| --- | --- | --- | --- |\n")
+		for _, mx := range result.MX {
+			expires := ""
+			if mx.Cert != nil {
+				expires = mx.Cert.NotAfter.Format("2006-01-02")
+			}
+			fmt.Fprintf(&buf, "| %s | %s | %s | %s |\n", markdownCell.Replace(displayName(mx.Host)), mx.Status(), mx.TLSVersion, expires)
+		}
+	}
+
+	if result.STSRecord != "" {
+		fmt.Fprintf(&buf, "\n## STS record\n\n`%s`\n", result.STSRecord)
+	}
+	if result.Policy != "" {
+		fmt.Fprintf(&buf, "\n## Policy\n\n```\n%s\n```\n", strings.TrimRight(result.Policy, "\n"))
+	}
+	if result.TLSRPTRecord != "" {
+		fmt.Fprintf(&buf, "\n## TLSRPT record\n\n`%s`\n", result.TLSRPTRecord)
+	}
+
+	if len(result.Findings) > 0 {
+		fmt.Fprintf(&buf, "\n## Findings\n\n| Severity | Code | Subject | Message | Hint |\n| --- | --- | --- | --- | --- |\n")
+		for _, f := range result.Findings {
+			severity := f.Severity.String()
+			if f.Suppressed {
+				severity += " (suppressed)"
+			}
+			fmt.Fprintf(&buf, "| %s | %s | %s | %s | %s |\n", severity, f.Code,
+				markdownCell.Replace(f.Subject), markdownCell.Replace(f.Message), markdownCell.Replace(f.Hint))
+		}
+	}
+
+	if len(result.Checks) > 0 {
+		fmt.Fprintf(&buf, "\n## Checks\n\n| Check | Status | Reason |\n| --- | --- | --- |\n")
+		for _, check := range result.Checks {
+			fmt.Fprintf(&buf, "| %s | %s | %s |\n", check.Name, check.Status, markdownCell.Replace(check.Reason))
+		}
+	}
+	return buf.String()
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"displayName": displayName,
+	"specName":    func(spec string) string { return specNames[spec] },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>MTA-STS report for {{.Domain}}</title></head>
+<body>
+<h1>MTA-STS report for {{.Domain}}</h1>
+{{with .Verdict}}<p><strong>Verdict:</strong> {{.Status}} ({{.Summary}})</p>{{end}}
+{{with .DeploymentState}}<p><strong>Deployment state:</strong> {{.}}</p>{{end}}
+{{with .Spec}}<p><strong>Spec:</strong> {{specName .}}</p>{{end}}
+{{with .DNSSource}}<p><strong>MX and TXT records from:</strong> {{.}}</p>{{end}}
+{{if .MX}}<h2>MX hosts</h2>
+<table>
+<tr><th>Host</th><th>Status</th><th>TLS</th><th>Certificate expires</th></tr>
+{{range .MX}}<tr><td>{{displayName .Host}}</td><td>{{.Status}}</td><td>{{.TLSVersion}}</td><td>{{with .Cert}}{{.NotAfter.Format "2006-01-02"}}{{end}}</td></tr>
+{{end}}</table>{{end}}
+{{with .STSRecord}}<h2>STS record</h2><p><code>{{.}}</code></p>{{end}}
+{{with .Policy}}<h2>Policy</h2><pre>{{.}}</pre>{{end}}
+{{with .TLSRPTRecord}}<h2>TLSRPT record</h2><p><code>{{.}}</code></p>{{end}}
+{{if .Findings}}<h2>Findings</h2>
+<table>
+<tr><th>Severity</th><th>Code</th><th>Subject</th><th>Message</th><th>Hint</th></tr>
+{{range .Findings}}<tr><td>{{.Severity}}{{if .Suppressed}} (suppressed){{end}}</td><td>{{.Code}}</td><td>{{.Subject}}</td><td>{{.Message}}</td><td>{{.Hint}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Checks}}<h2>Checks</h2>
+<table>
+<tr><th>Check</th><th>Status</th><th>Reason</th></tr>
+{{range .Checks}}<tr><td>{{.Name}}</td><td>{{.Status}}</td><td>{{.Reason}}</td></tr>
+{{end}}</table>{{end}}
+</body>
+</html>
+`))
+
+// unsafeFileChars are replaced when a domain is turned into a file name.
+var unsafeFileChars = regexp.MustCompile(`[^a-z0-9._-]`)
+
+// reportFileName turns a domain into a file name that is safe on every
+// filesystem, with the extension of format.
+func reportFileName(domain string, format string) string {
+	name := unsafeFileChars.ReplaceAllString(strings.ToLower(strings.TrimSpace(domain)), "_")
+	name = strings.TrimLeft(name, ".")
+	if name == "" {
+		name = "_"
+	}
+	return name + "." + fileExtensions[format]
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place, so readers never see a partial report.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-"+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// reportBytes renders result in format for writing to a file.
+func reportBytes(result *Result, format string) ([]byte, error) {
+	if format == "json" {
+		return json.MarshalIndent(result, "", "  ")
+	}
+	return []byte(renderDocument(result, format)), nil
+}
+
+// indexEntry is one domain in the -output-dir index.
+type indexEntry struct {
+	Domain          string `json:"domain"`
+	File            string `json:"file"`
+	Status          string `json:"status"`
+	Summary         string `json:"summary"`
+	DeploymentState string `json:"deployment_state,omitempty"`
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>MTA-STS reports</title></head>
+<body>
+<h1>MTA-STS reports</h1>
+<table>
+<tr><th>Domain</th><th>Verdict</th><th>Deployment state</th><th>Summary</th></tr>
+{{range .}}<tr><td><a href="{{.File}}">{{.Domain}}</a></td><td>{{.Status}}</td><td>{{.DeploymentState}}</td><td>{{.Summary}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// writeReports writes one report per result into dir, creating it if
+// needed, and an index summarizing all of them.
+func writeReports(dir string, results []*Result, format string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	var index []indexEntry
+	used := make(map[string]int)
+	for _, result := range results {
+		data, err := reportBytes(result, format)
+		if err != nil {
+			return err
+		}
+		// Domains that only differ in case or in unsafe characters would
+		// overwrite each other's report.
+		name := reportFileName(result.Domain, format)
+		if used[name]++; used[name] > 1 {
+			name = fmt.Sprintf("%s-%d.%s", strings.TrimSuffix(name, "."+fileExtensions[format]), used[name], fileExtensions[format])
+		}
+		if err := writeFileAtomic(filepath.Join(dir, name), data); err != nil {
+			return err
+		}
+		index = append(index, indexEntry{
+			Domain:          result.Domain,
+			File:            name,
+			Status:          result.Verdict.Status,
+			Summary:         result.Verdict.Summary,
+			DeploymentState: result.DeploymentState,
+		})
+	}
+
+	var data []byte
+	switch format {
+	case formatHTML:
+		var buf bytes.Buffer
+		if err := indexTemplate.Execute(&buf, index); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	case formatMarkdown:
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "# MTA-STS reports\n\n| Domain | Verdict | Deployment state | Summary |\n| --- | --- | --- | --- |\n")
+		for _, entry := range index {
+			fmt.Fprintf(&buf, "| [%s](%s) | %s | %s | %s |\n", entry.Domain, entry.File, entry.Status, entry.DeploymentState,
+				markdownCell.Replace(entry.Summary))
+		}
+		data = buf.Bytes()
+	default:
+		var err error
+		if data, err = json.MarshalIndent(index, "", "  "); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(filepath.Join(dir, "index."+fileExtensions[format]), data)
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"time"
+)
+
+// Severity of a finding. Only errors count as failures.
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+// MarshalText encodes the severity by name in JSON output.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a severity name, for reading saved JSON results.
+func (s *Severity) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "error":
+		*s = SeverityError
+	case "warning":
+		*s = SeverityWarning
+	case "info":
+		*s = SeverityInfo
+	default:
+		return fmt.Errorf("unknown severity %q", text)
+	}
+	return nil
+}
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	return "info"
+}
+
+// Finding is a single problem (or observation) found while validating a domain.
+// Code is a stable identifier like STS-TXT-MISSING, Subject is the host or
+// record the finding is about. SuppressedBy says what suppressed it, -ignore
+// or an inline annotation. RemappedFrom is the built-in severity when
+// -severity-map changed it.
+type Finding struct {
+	Code         string   `json:"code"`
+	Severity     Severity `json:"severity"`
+	Subject      string   `json:"subject,omitempty"`
+	Message      string   `json:"message"`
+	Explanation  string   `json:"explanation,omitempty"`
+	Reference    string   `json:"reference,omitempty"`
+	Hint         string   `json:"hint,omitempty"`
+	Suppressed   bool     `json:"suppressed,omitempty"`
+	SuppressedBy string   `json:"suppressed_by,omitempty"`
+	RemappedFrom string   `json:"remapped_from,omitempty"`
+	Line         int      `json:"line,omitempty"`
+}
+
+func (f Finding) String() string {
+	label := "Info"
+	switch f.Severity {
+	case SeverityError:
+		label = "\x1b[31;1mError\x1b[0m"
+	case SeverityWarning:
+		label = "\x1b[33;1mWarning\x1b[0m"
+	}
+	if f.Suppressed {
+		label = "Suppressed " + f.Severity.String()
+	}
+	if f.Subject != "" {
+		return fmt.Sprintf("%s [%s] %s: %s", label, f.Code, f.Subject, f.Message)
+	}
+	return fmt.Sprintf("%s [%s] %s", label, f.Code, f.Message)
+}
+
+// suppressedCount returns the number of suppressed findings.
+func (r *Result) suppressedCount() int {
+	count := 0
+	for _, f := range r.Findings {
+		if f.Suppressed {
+			count++
+		}
+	}
+	return count
+}
+
+// FindingGroups splits the unsuppressed findings of a result by whether they
+// break delivery. Blocking findings are the errors, the ones that fail the
+// verdict; advisory findings are warnings and informational notes.
+type FindingGroups struct {
+	Blocking []Finding `json:"blocking"`
+	Advisory []Finding `json:"advisory"`
+}
+
+func groupFindings(findings []Finding) *FindingGroups {
+	groups := &FindingGroups{Blocking: []Finding{}, Advisory: []Finding{}}
+	for _, f := range findings {
+		switch {
+		case f.Suppressed:
+		case f.Severity == SeverityError:
+			groups.Blocking = append(groups.Blocking, f)
+		default:
+			groups.Advisory = append(groups.Advisory, f)
+		}
+	}
+	return groups
+}
+
+// printFindings renders the findings of a result, the blocking ones first so
+// they aren't buried among the advisory ones. Suppressed findings are only
+// listed in verbose mode, otherwise just counted.
+func printFindings(result *Result, verbose bool) {
+	groups := groupFindings(result.Findings)
+	fmt.Printf("BLOCKING (%d):\n", len(groups.Blocking))
+	for _, finding := range groups.Blocking {
+		printFinding(finding)
+	}
+	fmt.Printf("\nADVISORY (%d):\n", len(groups.Advisory))
+	for _, finding := range groups.Advisory {
+		printFinding(finding)
+	}
+
+	n := result.suppressedCount()
+	if n > 0 && verbose {
+		fmt.Printf("\nSUPPRESSED (%d):\n", n)
+		for _, finding := range result.Findings {
+			if finding.Suppressed {
+				printFinding(finding)
+			}
+		}
+	} else if n > 0 {
+		fmt.Printf("\n%d finding(s) suppressed, use -verbose to show them\n", n)
+	}
+}
+
+// printFinding renders a finding followed by its explanation and hint
+// when those have been filled in.
+func printFinding(f Finding) {
+	fmt.Println(f)
+	if f.Explanation != "" {
+		fmt.Printf("\t%s (%s)\n", f.Explanation, f.Reference)
+	}
+	if f.Hint != "" {
+		fmt.Printf("\tHint: %s\n", f.Hint)
+	}
+}
+
+// MXResult holds the outcome of the STARTTLS test against one MX host.
+// TLSOK means the certificate is valid for the host.
+type MXResult struct {
+	Host       string               `json:"host"`
+	Canonical  string               `json:"canonical_name,omitempty"`
+	CNAMEChain []string             `json:"cname_chain,omitempty"`
+	Address    string               `json:"address,omitempty"`
+	Candidates []string             `json:"addresses,omitempty"`
+	Port       string               `json:"port"`
+	Connected  bool                 `json:"connected"`
+	DialError  string               `json:"connect_failure,omitempty"`
+	GreetWait  float64              `json:"no_greeting_after_ms,omitempty"`
+	StartTLS   bool                 `json:"starttls"`
+	TLSFailure string               `json:"tls_failure,omitempty"`
+	TLSAlert   string               `json:"tls_alert,omitempty"`
+	Banner     string               `json:"banner,omitempty"`
+	BannerName string               `json:"banner_name,omitempty"`
+	EHLOName   string               `json:"ehlo_name,omitempty"`
+	Session    string               `json:"-"`
+	TLSVersion string               `json:"tls_version,omitempty"`
+	TLSState   *tls.ConnectionState `json:"-"`
+	Handshake  time.Duration        `json:"-"`
+	Cert       *CertInfo            `json:"cert,omitempty"`
+	TLSOK      bool                 `json:"tls_ok"`
+	Resumption string               `json:"resumption,omitempty"`
+	TooFast    bool                 `json:"anti_pipelining,omitempty"`
+	Delayed    string               `json:"pre_tls_delay,omitempty"`
+	Error      string               `json:"error,omitempty"`
+	Transcript []string             `json:"transcript,omitempty"`
+	TLSDebug   *tlsDebug            `json:"tls_debug,omitempty"`
+}
+
+// Status is a short human readable summary of the MX test.
+func (m MXResult) Status() string {
+	if m.GreetWait > 0 {
+		return "no greeting"
+	}
+	if m.DialError != "" {
+		return "connect failed (" + m.DialError + ")"
+	}
+	if !m.Connected {
+		return "connect failed"
+	}
+	if !m.StartTLS {
+		return "STARTTLS failed"
+	}
+	if !m.TLSOK {
+		return "STARTTLS ok, cert invalid"
+	}
+	return "STARTTLS ok, cert good"
+}
+
+// Result is everything collected while validating a single domain.
+type Result struct {
+	Domain             string            `json:"domain"`
+	Spec               string            `json:"spec,omitempty"`
+	RequestedDomain    string            `json:"requested_domain,omitempty"`
+	MX                 []MXResult        `json:"mx"`
+	DNSSource          string            `json:"dns_source,omitempty"`
+	PolicyFile         string            `json:"policy_file,omitempty"`
+	MXLookupError      string            `json:"mx_lookup_error,omitempty"`
+	LocalIPv6          *ipv6Assessment   `json:"local_ipv6,omitempty"`
+	STSLookupError     string            `json:"sts_lookup_error,omitempty"`
+	STSRecord          string            `json:"sts_record,omitempty"`
+	STSRecords         []string          `json:"sts_records,omitempty"`
+	TXTRecordsExamined int               `json:"txt_records_examined"`
+	NSRecords          map[string]string `json:"ns_sts_records,omitempty"`
+	STSResponseSizes   []dnsSizeProbe    `json:"sts_response_sizes,omitempty"`
+	PolicyHost         string            `json:"policy_host,omitempty"`
+	PolicyHostUnicode  string            `json:"policy_host_unicode,omitempty"`
+	Policy             string            `json:"policy,omitempty"`
+	PolicyTLSVersion   string            `json:"policy_tls_version,omitempty"`
+	PolicyCert         *CertInfo         `json:"policy_cert,omitempty"`
+	PolicyRedirects    []string          `json:"policy_redirects,omitempty"`
+	PolicyTTFBMillis   int64             `json:"policy_ttfb_ms,omitempty"`
+	PolicyFetchMillis  int64             `json:"policy_fetch_ms,omitempty"`
+	PolicyEncoding     string            `json:"policy_content_encoding,omitempty"`
+	PolicyAttempts     int               `json:"policy_tls_attempts,omitempty"`
+	PolicyAddress      string            `json:"policy_address,omitempty"`
+	PolicyTLSDebug     *tlsDebug         `json:"policy_tls_debug,omitempty"`
+	AddressSelection   string            `json:"address_selection,omitempty"`
+	PolicyHashes       map[string]string `json:"policy_sha256,omitempty"`
+	PolicyVariants     []policyVariant   `json:"policy_variants,omitempty"`
+	PolicyOrigin       *originComparison `json:"policy_origin,omitempty"`
+	IDChange           *idChange         `json:"id_change,omitempty"`
+	Mode               string            `json:"mode,omitempty"`
+	MaxAge             string            `json:"max_age,omitempty"`
+	PolicyMX           []string          `json:"policy_mx,omitempty"`
+	Profile            string            `json:"profile,omitempty"`
+	MXCoverage         *mxCoverage       `json:"mx_coverage,omitempty"`
+	TLSRPTRecord       string            `json:"tlsrpt_record,omitempty"`
+	Raw                *rawArtifacts     `json:"raw,omitempty"`
+	Findings           []Finding         `json:"findings"`
+	Grouped            *FindingGroups    `json:"grouped_findings,omitempty"`
+	Checks             []Check           `json:"checks"`
+	Verdict            *Verdict          `json:"verdict,omitempty"`
+
+	// Status is pass, warn or fail. It is derived from Verdict by
+	// overallStatus and never set on its own: fail exactly when the
+	// verdict is FAIL, warn when a passing verdict leaves unsuppressed
+	// warnings.
+	Status          string `json:"status,omitempty"`
+	DeploymentState string `json:"deployment_state,omitempty"`
+
+	// PolicyTLSState is the connection the policy was fetched over, for
+	// -save-certs.
+	PolicyTLSState *tls.ConnectionState `json:"-"`
+}
+
+func (r *Result) add(severity Severity, code string, subject string, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, Finding{
+		Code:     code,
+		Severity: severity,
+		Subject:  subject,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (r *Result) errorf(code string, subject string, format string, args ...interface{}) {
+	r.add(SeverityError, code, subject, format, args...)
+}
+
+func (r *Result) warnf(code string, subject string, format string, args ...interface{}) {
+	r.add(SeverityWarning, code, subject, format, args...)
+}
+
+func (r *Result) infof(code string, subject string, format string, args ...interface{}) {
+	r.add(SeverityInfo, code, subject, format, args...)
+}
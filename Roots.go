@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	_ "embed"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+)
+
+// embeddedRoots is a copy of the Mozilla root bundle, used when the system
+// has none, as in a FROM scratch container. See tools/bin/update-roots.sh.
+//
+//go:embed roots.pem
+var embeddedRoots []byte
+
+var rootsGenerated = regexp.MustCompile(`(?m)^# Generated: (\S+)$`)
+
+// rootPool is the pool every certificate is verified against: the MX
+// chains, the policy host and any other HTTPS request. nil means the
+// system pool.
+var rootPool *x509.CertPool
+
+// rootSource says where rootPool came from, for -version.
+var rootSource = "system"
+
+// caFileUsage describes -ca-file, which every command that verifies
+// certificates takes.
+const caFileUsage = "Verify certificates against the PEM root certificates in this file instead of the system's"
+
+// installRoots sets up rootPool: the certificates of caFile when it is
+// given, otherwise the system pool or, when the system has no roots, the
+// embedded bundle, with a warning on stderr that it may be stale.
+func installRoots(caFile string) error {
+	switch {
+	case caFile != "":
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("%s contains no PEM certificates", caFile)
+		}
+		useRoots(pool, "-ca-file "+caFile)
+	case !haveSystemRoots():
+		useRoots(embeddedPool(), "embedded bundle generated "+embeddedRootsDate())
+		fmt.Fprintf(os.Stderr, "Warning: no system root certificates found, using the embedded bundle generated %s, which may be stale; -ca-file overrides it\n",
+			embeddedRootsDate())
+	}
+	return nil
+}
+
+// embeddedPool returns a pool of the embedded bundle.
+func embeddedPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(embeddedRoots)
+	return pool
+}
+
+// haveSystemRoots reports whether the system pool has any certificates. On
+// most systems a missing bundle yields an empty pool rather than an error.
+func haveSystemRoots() bool {
+	pool, err := x509.SystemCertPool()
+	return err == nil && !pool.Equal(x509.NewCertPool())
+}
+
+// useRoots makes pool the roots of every verification, including the HTTP
+// clients built before it was known.
+func useRoots(pool *x509.CertPool, source string) {
+	rootPool, rootSource = pool, source
+	policyClient.Transport.(*http.Transport).TLSClientConfig.RootCAs = pool
+	if transport, ok := http.DefaultTransport.(*http.Transport); ok {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+}
+
+// embeddedRootsDate is the generation date recorded in the embedded bundle.
+func embeddedRootsDate() string {
+	if match := rootsGenerated.FindSubmatch(embeddedRoots); match != nil {
+		return string(match[1])
+	}
+	return "unknown"
+}
+
+// printVersion implements -version.
+func printVersion() {
+	fmt.Printf("StrictMTATest %s\n", version)
+	fmt.Printf("Root certificates: %s\n", rootSource)
+	fmt.Printf("Embedded fallback bundle generated %s\n", embeddedRootsDate())
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// sampleSpec is the -sample size, a number of domains or a percentage of
+// the list.
+type sampleSpec struct {
+	count   int
+	percent float64
+}
+
+func parseSample(spec string) (sampleSpec, error) {
+	if strings.HasSuffix(spec, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(spec, "%"), 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return sampleSpec{}, fmt.Errorf("%q is not a percentage between 0 and 100", spec)
+		}
+		return sampleSpec{percent: percent}, nil
+	}
+	count, err := strconv.Atoi(spec)
+	if err != nil || count <= 0 {
+		return sampleSpec{}, fmt.Errorf("%q is neither a positive number of domains nor a percentage", spec)
+	}
+	return sampleSpec{count: count}, nil
+}
+
+// size is the number of domains to sample out of total, at least one and
+// at most all of them.
+func (s sampleSpec) size(total int) int {
+	n := s.count
+	if s.percent > 0 {
+		n = int(math.Ceil(s.percent * float64(total) / 100))
+	}
+	if n < 1 {
+		n = 1
+	}
+	if n > total {
+		n = total
+	}
+	return n
+}
+
+// sampleDomains picks size domains uniformly at random, reproducibly for a
+// seed, and keeps them in the order of the list.
+func sampleDomains(domains []string, size int, seed int64) []string {
+	picked := rand.New(rand.NewSource(seed)).Perm(len(domains))[:size]
+	sort.Ints(picked)
+	sample := make([]string, size)
+	for i, index := range picked {
+		sample[i] = domains[index]
+	}
+	return sample
+}
+
+// sampleReport is the posture of the whole list estimated from a -sample
+// run, part of the batch JSON output.
+type sampleReport struct {
+	Population int           `json:"population"`
+	Size       int           `json:"size"`
+	Seed       int64         `json:"seed"`
+	Estimates  []sampleShare `json:"estimates"`
+}
+
+// sampleShare is how many sampled domains were in one state and what that
+// extrapolates to for the whole list. Margin is the half width of the 95%
+// confidence interval of Share.
+type sampleShare struct {
+	Name      string  `json:"name"`
+	Count     int     `json:"count"`
+	Share     float64 `json:"share"`
+	Margin    float64 `json:"margin"`
+	Estimated int     `json:"estimated"`
+}
+
+// sampleDeploymentStates are the rows of the estimate, in rollout order.
+var sampleDeploymentStates = []string{stateNotDeployed, stateDNSOnly, statePolicyOnly, stateTesting, stateEnforced, stateBroken}
+
+// estimateFromSample extrapolates the deployment states and the share of
+// passing domains from results, a sample of population domains. The margin
+// uses the normal approximation with the finite population correction, so
+// it shrinks to zero as the sample approaches the whole list.
+func estimateFromSample(results []*Result, population int, seed int64) *sampleReport {
+	report := &sampleReport{Population: population, Size: len(results), Seed: seed}
+	counts := make(map[string]int)
+	for _, result := range results {
+		counts[result.DeploymentState]++
+		if result.Verdict.Status == verdictPass {
+			counts["passing"]++
+		}
+	}
+	n, total := float64(len(results)), float64(population)
+	for _, name := range append(append([]string(nil), sampleDeploymentStates...), "passing") {
+		share := float64(counts[name]) / n
+		margin := 0.0
+		if population > 1 {
+			margin = 1.96 * math.Sqrt(share*(1-share)/n*(total-n)/(total-1))
+		}
+		report.Estimates = append(report.Estimates, sampleShare{Name: name, Count: counts[name], Share: share, Margin: margin,
+			Estimated: int(math.Round(share * total))})
+	}
+	return report
+}
+
+// printSampleReport renders the estimate below the batch summary.
+func printSampleReport(report *sampleReport) {
+	fmt.Printf("Sampled %d of %d domains (seed %d, rerun with -sample-seed %d for the same sample).\n",
+		report.Size, report.Population, report.Seed, report.Seed)
+	fmt.Println("Estimated for the whole list, at 95% confidence:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, share := range report.Estimates {
+		fmt.Fprintf(w, "\t%s\t%d\t%.1f%% ± %.1f%%\t~%d of %d\t\n", share.Name, share.Count, 100*share.Share, 100*share.Margin,
+			share.Estimated, report.Population)
+	}
+	w.Flush()
+}
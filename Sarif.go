@@ -0,0 +1,203 @@
+package main
+
+import (
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// formatSARIF renders every result of a run as one SARIF 2.1.0 log, for
+// code scanning platforms.
+const formatSARIF = "sarif"
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	projectURL   = "https://github.com/yepher/StrictMTATest"
+)
+
+// The subset of the SARIF 2.1.0 object model the report uses.
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	FullDescription      *sarifMessage      `json:"fullDescription,omitempty"`
+	Help                 *sarifMessage      `json:"help,omitempty"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID              string             `json:"ruleId"`
+	RuleIndex           int                `json:"ruleIndex"`
+	Level               string             `json:"level"`
+	Message             sarifMessage       `json:"message"`
+	Locations           []sarifLocation    `json:"locations"`
+	PartialFingerprints map[string]string  `json:"partialFingerprints"`
+	Suppressions        []sarifSuppression `json:"suppressions,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation *sarifPhysicalLocation `json:"physicalLocation,omitempty"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+type sarifLogicalLocation struct {
+	Name               string `json:"name"`
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+type sarifSuppression struct {
+	Kind          string `json:"kind"`
+	Justification string `json:"justification,omitempty"`
+}
+
+// sarifLevels maps severities to SARIF result levels.
+var sarifLevels = map[Severity]string{
+	SeverityError:   "error",
+	SeverityWarning: "warning",
+	SeverityInfo:    "note",
+}
+
+// sarifReport turns the findings of results into a SARIF log. Each finding
+// code is a rule described by findingCodes. Findings of a -policy-file run
+// are located in the file, others logically at the domain and subject,
+// since a live domain has no source file.
+func sarifReport(results []*Result) *sarifLog {
+	run := sarifRun{
+		Tool:    sarifTool{Driver: sarifDriver{Name: "StrictMTATest", Version: version, InformationURI: projectURL, Rules: []sarifRule{}}},
+		Results: []sarifResult{},
+	}
+
+	var codes []string
+	levels := make(map[string]Severity)
+	for _, result := range results {
+		for _, f := range result.Findings {
+			if _, ok := levels[f.Code]; !ok {
+				codes = append(codes, f.Code)
+			}
+			if f.Severity > levels[f.Code] {
+				levels[f.Code] = f.Severity
+			}
+		}
+	}
+	sort.Strings(codes)
+	ruleIndex := make(map[string]int)
+	for i, code := range codes {
+		ruleIndex[code] = i
+		rule := sarifRule{ID: code, ShortDescription: sarifMessage{ruleTitle(code)}, DefaultConfiguration: sarifConfiguration{sarifLevels[levels[code]]}}
+		if info, ok := findingCodes[code]; ok {
+			rule.FullDescription = &sarifMessage{info.Explanation}
+			rule.Help = &sarifMessage{"Reference: " + info.Reference}
+		}
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, rule)
+	}
+
+	for _, result := range results {
+		for _, f := range result.Findings {
+			text := f.Message
+			if f.Hint != "" {
+				text += "\nHint: " + f.Hint
+			}
+			location := sarifLocation{}
+			if result.PolicyFile != "" {
+				location.PhysicalLocation = &sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: artifactURI(result.PolicyFile)}}
+				if f.Line > 0 {
+					location.PhysicalLocation.Region = &sarifRegion{StartLine: f.Line}
+				}
+			} else {
+				name := f.Subject
+				if name == "" {
+					name = result.Domain
+				}
+				location.LogicalLocations = []sarifLogicalLocation{{Name: name, FullyQualifiedName: result.Domain + "/" + name, Kind: "resource"}}
+			}
+
+			entry := sarifResult{
+				RuleID:              f.Code,
+				RuleIndex:           ruleIndex[f.Code],
+				Level:               sarifLevels[f.Severity],
+				Message:             sarifMessage{text},
+				Locations:           []sarifLocation{location},
+				PartialFingerprints: map[string]string{"mtastsFinding/v1": strings.Join([]string{result.Domain, f.Code, f.Subject}, "|")},
+			}
+			if f.Suppressed {
+				entry.Suppressions = []sarifSuppression{{Kind: suppressionKind(f), Justification: f.SuppressedBy}}
+			}
+			run.Results = append(run.Results, entry)
+		}
+	}
+	return &sarifLog{Schema: sarifSchema, Version: sarifVersion, Runs: []sarifRun{run}}
+}
+
+// suppressionKind is "inSource" for a finding suppressed by an annotation
+// in the policy file and "external" for one suppressed by -ignore.
+func suppressionKind(f Finding) string {
+	if strings.HasPrefix(f.SuppressedBy, "annotation") {
+		return "inSource"
+	}
+	return "external"
+}
+
+// artifactURI turns a file path into a SARIF artifact URI: relative paths
+// stay relative to the directory the tool ran in, which is what code
+// scanning resolves against the repository.
+func artifactURI(path string) string {
+	if filepath.IsAbs(path) {
+		return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+	}
+	return (&url.URL{Path: filepath.ToSlash(filepath.Clean(path))}).String()
+}
+
+// ruleTitle turns a finding code into a short description, CERT-EXPIRING
+// into "Cert expiring".
+func ruleTitle(code string) string {
+	title := strings.ToLower(strings.Replace(code, "-", " ", -1))
+	if title == "" {
+		return title
+	}
+	return strings.ToUpper(title[:1]) + title[1:]
+}
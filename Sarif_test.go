@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// sarifObject decodes the SARIF log for results the way a consumer reads
+// it, so the test checks property names rather than Go fields.
+func sarifObject(t *testing.T, results []*Result) map[string]interface{} {
+	t.Helper()
+	data, err := json.Marshal(sarifReport(results))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log map[string]interface{}
+	if err := json.Unmarshal(data, &log); err != nil {
+		t.Fatal(err)
+	}
+	return log
+}
+
+// require reports the properties of object the SARIF 2.1.0 schema requires
+// that are missing.
+func require(t *testing.T, where string, object interface{}, properties ...string) map[string]interface{} {
+	t.Helper()
+	fields, ok := object.(map[string]interface{})
+	if !ok {
+		t.Fatalf("%s is %T, want an object", where, object)
+	}
+	for _, property := range properties {
+		if _, ok := fields[property]; !ok {
+			t.Errorf("%s has no %s", where, property)
+		}
+	}
+	return fields
+}
+
+func TestSARIFReport(t *testing.T) {
+	live := &Result{Domain: "example.com", Findings: []Finding{
+		{Code: "CERT-EXPIRING", Severity: SeverityWarning, Subject: "mx.example.com", Message: "certificate expires in 9 days"},
+		{Code: "TLSRPT-MISSING", Severity: SeverityWarning, Subject: "_smtp._tls.example.com", Message: "RPT Failed", Suppressed: true, SuppressedBy: "-ignore"},
+	}}
+	file := &Result{Domain: "example.org", PolicyFile: "policy.txt", Findings: []Finding{
+		{Code: "POLICY-MODE-INVALID", Severity: SeverityError, Message: "mode must be one of ...", Line: 2},
+		{Code: "POLICY-UNKNOWN-KEY", Severity: SeverityWarning, Subject: "foo", Message: "unknown key", Line: 5, Suppressed: true, SuppressedBy: "annotation on line 4"},
+		{Code: "POLICY-MX-TRAILING-DOT", Severity: SeverityInfo, Subject: "mx.example.org.", Message: "trailing dot"},
+	}}
+	log := sarifObject(t, []*Result{live, file})
+
+	require(t, "log", log, "$schema", "version", "runs")
+	if log["version"] != "2.1.0" {
+		t.Errorf("version = %v, want 2.1.0", log["version"])
+	}
+	runs, _ := log["runs"].([]interface{})
+	if len(runs) != 1 {
+		t.Fatalf("%d runs, want 1", len(runs))
+	}
+	run := require(t, "run", runs[0], "tool", "results")
+	driver := require(t, "tool.driver", require(t, "tool", run["tool"], "driver")["driver"], "name", "rules")
+	if driver["name"] != "StrictMTATest" {
+		t.Errorf("driver name = %v", driver["name"])
+	}
+
+	var ruleIDs []string
+	for i, rule := range driver["rules"].([]interface{}) {
+		fields := require(t, "rule", rule, "id", "shortDescription", "defaultConfiguration")
+		require(t, "rule.shortDescription", fields["shortDescription"], "text")
+		ruleIDs = append(ruleIDs, fields["id"].(string))
+		if i > 0 && ruleIDs[i-1] >= ruleIDs[i] {
+			t.Errorf("rules are not sorted by id: %q", ruleIDs)
+		}
+	}
+
+	levels := map[string]bool{"none": true, "note": true, "warning": true, "error": true}
+	suppressions := make(map[string]string)
+	results := run["results"].([]interface{})
+	if len(results) != 5 {
+		t.Fatalf("%d results, want one per finding", len(results))
+	}
+	for _, r := range results {
+		fields := require(t, "result", r, "ruleId", "ruleIndex", "level", "message", "locations")
+		id := fields["ruleId"].(string)
+		require(t, id+" message", fields["message"], "text")
+		if index := int(fields["ruleIndex"].(float64)); index >= len(ruleIDs) || ruleIDs[index] != id {
+			t.Errorf("%s has ruleIndex %d, which is not its rule", id, index)
+		}
+		if !levels[fields["level"].(string)] {
+			t.Errorf("%s has level %v", id, fields["level"])
+		}
+		locations := fields["locations"].([]interface{})
+		if len(locations) != 1 {
+			t.Fatalf("%s has %d locations, want 1", id, len(locations))
+		}
+		location := locations[0].(map[string]interface{})
+		if physical, ok := location["physicalLocation"]; ok {
+			artifact := require(t, id+" physicalLocation", physical, "artifactLocation")["artifactLocation"]
+			require(t, id+" artifactLocation", artifact, "uri")
+		} else {
+			for _, logical := range location["logicalLocations"].([]interface{}) {
+				require(t, id+" logicalLocation", logical, "name", "fullyQualifiedName", "kind")
+			}
+		}
+		if list, ok := fields["suppressions"].([]interface{}); ok {
+			for _, suppression := range list {
+				suppressions[id] = require(t, id+" suppression", suppression, "kind")["kind"].(string)
+			}
+		}
+	}
+
+	want := map[string]string{"TLSRPT-MISSING": "external", "POLICY-UNKNOWN-KEY": "inSource"}
+	if len(suppressions) != len(want) {
+		t.Errorf("suppressions = %v, want %v", suppressions, want)
+	}
+	for code, kind := range want {
+		if suppressions[code] != kind {
+			t.Errorf("%s suppression kind = %q, want %q", code, suppressions[code], kind)
+		}
+	}
+}
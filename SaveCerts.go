@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// certObservation records one place a certificate was presented.
+type certObservation struct {
+	Domain   string    `json:"domain"`
+	Role     string    `json:"role"`
+	Host     string    `json:"host"`
+	Address  string    `json:"address,omitempty"`
+	Port     string    `json:"port"`
+	Position int       `json:"position"`
+	Seen     time.Time `json:"seen"`
+}
+
+// savedCert is the JSON sidecar written next to each certificate.
+type savedCert struct {
+	SHA256       string            `json:"sha256"`
+	Subject      string            `json:"subject"`
+	Issuer       string            `json:"issuer"`
+	NotAfter     time.Time         `json:"not_after"`
+	Observations []certObservation `json:"observations"`
+
+	cert *x509.Certificate
+	name string
+}
+
+// certStore collects every certificate presented during a run for
+// -save-certs. A certificate seen on several hosts or positions is kept
+// once with all its observations.
+type certStore struct {
+	dir   string
+	certs map[string]*savedCert
+	order []string
+}
+
+func newCertStore(dir string) *certStore {
+	return &certStore{dir: dir, certs: make(map[string]*savedCert)}
+}
+
+// observe adds the chains presented by the MX hosts and the policy host of
+// result. They are timestamped now, right after the domain was checked.
+func (s *certStore) observe(result *Result) {
+	if s == nil {
+		return
+	}
+	now := time.Now().UTC()
+	for _, mx := range result.MX {
+		if mx.TLSState != nil {
+			s.add(mx.TLSState.PeerCertificates, certObservation{Domain: result.Domain, Role: "mx", Host: mx.Host, Address: mx.Address, Port: mx.Port, Seen: now})
+		}
+	}
+	if result.PolicyTLSState != nil {
+		s.add(result.PolicyTLSState.PeerCertificates, certObservation{Domain: result.Domain, Role: "policy host", Host: "mta-sts." + result.Domain,
+			Address: result.PolicyAddress, Port: "443", Seen: now})
+	}
+}
+
+func (s *certStore) add(chain []*x509.Certificate, seen certObservation) {
+	for i, cert := range chain {
+		hash := sha256Hex(string(cert.Raw))
+		saved, ok := s.certs[hash]
+		if !ok {
+			// Named after where it was first seen.
+			base := strings.TrimSuffix(reportFileName(seen.Host, "json"), ".json")
+			saved = &savedCert{SHA256: hash, Subject: cert.Subject.String(), Issuer: cert.Issuer.String(), NotAfter: cert.NotAfter,
+				cert: cert, name: fmt.Sprintf("%s_%d_%s", base, i, hash[:16])}
+			s.certs[hash] = saved
+			s.order = append(s.order, hash)
+		}
+		seen.Position = i
+		saved.Observations = append(saved.Observations, seen)
+	}
+}
+
+// write saves each certificate as <host>_<position>_<sha256 prefix>.pem with
+// a .json sidecar of its observations, creating the directory if needed.
+func (s *certStore) write() error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	for _, hash := range s.order {
+		saved := s.certs[hash]
+		data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: saved.cert.Raw})
+		if err := writeFileAtomic(filepath.Join(s.dir, saved.name+".pem"), data); err != nil {
+			return err
+		}
+		sidecar, err := json.MarshalIndent(saved, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(filepath.Join(s.dir, saved.name+".json"), append(sidecar, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveCerts writes the collected certificates. A failure is only a warning,
+// the validation itself is unaffected.
+func saveCerts(store *certStore) {
+	if store == nil {
+		return
+	}
+	if err := store.write(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: saving certificates to %s failed: %v\n", store.dir, err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// severityMap is the -severity-map file: finding codes mapped to the
+// severity they get instead of the built-in one, like
+//
+//	# ours must not ship without reporting
+//	TLSRPT-MISSING       error
+//	CERT-WILDCARD-MATCH  warning
+type severityMap map[string]Severity
+
+// readSeverityMap parses a severity map file, one code and severity per
+// line. Lines starting with # are comments. Codes are read like those of
+// annotations, case-insensitive with _ for -; codes the tool doesn't know
+// are a warning on stderr, since they may belong to a newer version.
+func readSeverityMap(path string) (severityMap, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := make(severityMap)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want a finding code and a severity, got %q", path, i+1, line)
+		}
+		code := strings.ToUpper(strings.Replace(fields[0], "_", "-", -1))
+		var severity Severity
+		if err := severity.UnmarshalText([]byte(strings.ToLower(fields[1]))); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v, must be error, warning or info", path, i+1, err)
+		}
+		if _, ok := findingCodes[code]; !ok {
+			fmt.Fprintf(os.Stderr, "Warning: unknown finding code %s in %s:%d\n", code, path, i+1)
+		}
+		m[code] = severity
+	}
+	return m, nil
+}
+
+// applySeverityMap gives the findings the severities of m. A remapped
+// finding keeps its built-in severity in RemappedFrom and says so in its
+// message.
+func applySeverityMap(result *Result, m severityMap) {
+	for i, finding := range result.Findings {
+		severity, ok := m[finding.Code]
+		if !ok || severity == finding.Severity {
+			continue
+		}
+		f := &result.Findings[i]
+		f.RemappedFrom = f.Severity.String()
+		f.Severity = severity
+		f.Message += fmt.Sprintf(" (%s remapped to %s by -severity-map)", f.RemappedFrom, severity)
+	}
+}
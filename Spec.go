@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Specifications a run can validate against, see -spec. The behavior that
+// differs between them is kept in this file.
+const (
+	specRFC8461 = "rfc8461"
+	specDraft10 = "draft10"
+)
+
+// specNames is how each spec is named in reports.
+var specNames = map[string]string{
+	specRFC8461: "RFC 8461",
+	specDraft10: "draft-ietf-uta-mta-sts-10",
+}
+
+// RFC 8461 §3.2 caps max_age at about one year.
+const maxMaxAge = 31557600
+
+var stsIDPattern = regexp.MustCompile(`^[A-Za-z0-9]{1,32}$`)
+
+// The extension fields the TXT record grammar allows, RFC 8461 §3.1.
+var (
+	stsExtName  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,31}$`)
+	stsExtValue = regexp.MustCompile(`^[\x21-\x3a\x3c\x3e-\x7e]+$`)
+)
+
+// policyFileKeys are keys of the policy file that are sometimes put into the
+// TXT record by mistake.
+var policyFileKeys = map[string]bool{"version": true, "mode": true, "mx": true, "max_age": true}
+
+// wildcardSuffix reports whether the normalized mx pattern is a wildcard
+// under spec, and if so returns the suffix, with its leading dot, that the
+// host must have after its first label. RFC 8461 writes wildcards as
+// *.example.com, the draft as .example.com.
+func wildcardSuffix(spec string, mx string) (string, bool) {
+	if spec == specDraft10 {
+		return mx, strings.HasPrefix(mx, ".")
+	}
+	if strings.HasPrefix(mx, "*.") {
+		return mx[1:], true
+	}
+	return "", false
+}
+
+// otherWildcardSyntax reports whether the mx pattern uses the wildcard form
+// of the other spec, which matches nothing under spec.
+func otherWildcardSyntax(spec string, mx string) (string, bool) {
+	if spec == specDraft10 && strings.HasPrefix(mx, "*.") {
+		return mx[1:], true
+	}
+	if spec != specDraft10 && strings.HasPrefix(mx, ".") {
+		return "*" + mx, true
+	}
+	return "", false
+}
+
+var hostLabelPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// mxSyntaxProblem describes why the mx value can never match a host name,
+// or returns "" when it is a valid host name or wildcard pattern. Either
+// wildcard form is accepted here, using the other spec's form is reported
+// separately. Values that fail A-label conversion are also left to that
+// check.
+func mxSyntaxProblem(mx string) string {
+	name := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(mx)), ".")
+	switch {
+	case strings.Contains(name, "://"):
+		return "has a URL scheme; mx values are bare host names"
+	case strings.Contains(name, "/"):
+		return "contains a path; mx values are bare host names"
+	case net.ParseIP(strings.Trim(name, "[]")) != nil:
+		return "looks like an IP address; mx values must be host names"
+	}
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		if port := name[i+1:]; port != "" && strings.Trim(port, "0123456789") == "" {
+			if net.ParseIP(strings.Trim(name[:i], "[]")) != nil {
+				return "looks like an IP address with a port; mx values must be host names"
+			}
+			return "has a port; mx values are host names and port 25 is implied"
+		}
+		return "contains ':', which is not allowed in host names"
+	}
+
+	if strings.HasPrefix(name, "*.") {
+		name = name[2:]
+	} else if strings.HasPrefix(name, ".") {
+		name = name[1:]
+	}
+	ascii, err := toASCII(name)
+	if err != nil {
+		return ""
+	}
+	if len(ascii) > maxHostnameLength {
+		return fmt.Sprintf("is %d characters long, host names are limited to %d", len(ascii), maxHostnameLength)
+	}
+	labels := strings.Split(ascii, ".")
+	if len(labels) < 2 {
+		return "is a single label; mx values must be fully qualified host names"
+	}
+	for _, label := range labels {
+		switch {
+		case label == "":
+			return "has an empty label"
+		case strings.Contains(label, "*"):
+			return fmt.Sprintf("has '*' in label %q; a wildcard can only be the whole first label", label)
+		case len(label) > maxLabelLength:
+			return fmt.Sprintf("has a label of %d characters, labels are limited to %d", len(label), maxLabelLength)
+		case !hostLabelPattern.MatchString(label):
+			return fmt.Sprintf("has an invalid label %q; host name labels are letters, digits and inner hyphens", label)
+		}
+	}
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return "ends in an all-numeric label; top-level domains are never numeric"
+	}
+	return ""
+}
+
+// validModes lists the policy modes defined by spec. The draft called
+// testing "report".
+func validModes(spec string) []string {
+	if spec == specDraft10 {
+		return []string{"enforce", "report", "none"}
+	}
+	return []string{"enforce", "testing", "none"}
+}
+
+// testingMode is the non-enforcing mode under spec.
+func testingMode(spec string) string {
+	if spec == specDraft10 {
+		return "report"
+	}
+	return "testing"
+}
+
+// checkMaxAge validates the max_age value: a non-negative integer, and
+// under RFC 8461 no more than maxMaxAge.
+func checkMaxAge(result *Result, maxAge string) {
+	seconds, err := strconv.Atoi(maxAge)
+	if err != nil || seconds < 0 {
+		result.errorf("POLICY-MAX-AGE-INVALID", maxAge, "max_age must be a non-negative number of seconds but was %q", maxAge)
+		return
+	}
+	if result.Spec != specDraft10 && seconds > maxMaxAge {
+		result.errorf("POLICY-MAX-AGE-INVALID", maxAge, "max_age %d is above the maximum of %d seconds", seconds, maxMaxAge)
+	}
+}
+
+// checkSTSRecordFields validates the fields of the _mta-sts TXT record. Both
+// specs require a single v and id and allow extension fields next to them;
+// RFC 8461 §3.1 also limits the id to 1-32 alphanumeric characters and does
+// not allow extension fields to repeat.
+func checkSTSRecordFields(result *Result, name string, record string) {
+	fields := make(map[string][]string)
+	values := make(map[string]string)
+	var keys []string
+	for _, field := range strings.Split(record, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		if key == "" {
+			continue
+		}
+		if len(fields[key]) == 0 {
+			keys = append(keys, key)
+			values[key] = value
+		}
+		fields[key] = append(fields[key], value)
+	}
+
+	for _, key := range keys {
+		switch {
+		case key == "v" || key == "id":
+		case policyFileKeys[strings.ToLower(key)]:
+			result.warnf("STS-TXT-POLICY-KEY", name, "%s belongs in the policy file mta-sts.txt, not in the TXT record, where senders ignore it", key)
+		case stsExtName.MatchString(key) && stsExtValue.MatchString(values[key]):
+			result.infof("STS-TXT-UNKNOWN-KEY", name, "extension field %s=%s is not defined by the spec and senders ignore it", key, values[key])
+		default:
+			result.errorf("STS-TXT-INVALID", name, "field %q is neither v, id nor a valid extension field", key+"="+values[key])
+		}
+	}
+
+	// Parsers disagree on which of repeated values wins, so some senders
+	// may never see an id change.
+	for _, key := range []string{"v", "id"} {
+		if len(fields[key]) > 1 {
+			result.errorf("TXT-DUPLICATE-FIELD", name, "%s appears %d times (%s); StrictMTATest uses the first, %s=%s, but senders are free to pick another value or reject the record",
+				key, len(fields[key]), strings.Join(quoteAll(fields[key]), ", "), key, values[key])
+		}
+	}
+
+	if len(fields["id"]) == 0 {
+		result.errorf("STS-TXT-INVALID", name, "the STS record has no id field")
+		return
+	}
+	if result.Spec == specDraft10 {
+		return
+	}
+	if id := values["id"]; !stsIDPattern.MatchString(id) {
+		result.errorf("STS-TXT-INVALID", name, "id %q must be 1-32 letters and digits", id)
+	}
+	for _, key := range keys {
+		if len(fields[key]) > 1 && key != "v" && key != "id" {
+			result.errorf("STS-TXT-INVALID", name, "field %s appears %d times", key, len(fields[key]))
+		}
+	}
+}
+
+func quoteAll(values []string) []string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = strconv.Quote(value)
+	}
+	return quoted
+}
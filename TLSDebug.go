@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// tlsDebug is the TLS-level picture of one connection for -tls-debug, taken
+// from the ConnectionState and, for a failed handshake, the typed error.
+type tlsDebug struct {
+	ServerName      string   `json:"server_name"`
+	ALPNOffered     []string `json:"alpn_offered"`
+	ALPN            string   `json:"alpn,omitempty"`
+	Version         string   `json:"version,omitempty"`
+	CipherSuite     string   `json:"cipher_suite,omitempty"`
+	KeyExchange     string   `json:"key_exchange,omitempty"`
+	Resumed         bool     `json:"resumed"`
+	Chain           []string `json:"chain,omitempty"`
+	HandshakeMillis float64  `json:"handshake_ms"`
+	Failure         string   `json:"failure,omitempty"`
+	Alert           string   `json:"alert,omitempty"`
+	Error           string   `json:"error,omitempty"`
+}
+
+// newTLSDebug describes a handshake with serverName that offered the ALPN
+// protocols offered and took elapsed. state is nil when the handshake
+// failed with err.
+func newTLSDebug(serverName string, offered []string, state *tls.ConnectionState, elapsed time.Duration, err error) *tlsDebug {
+	debug := &tlsDebug{ServerName: serverName, ALPNOffered: offered, HandshakeMillis: millis(elapsed)}
+	if debug.ALPNOffered == nil {
+		debug.ALPNOffered = []string{}
+	}
+	if err != nil {
+		debug.Error = err.Error()
+		debug.Failure, debug.Alert = classifyTLSError(err)
+	}
+	if state == nil || !state.HandshakeComplete {
+		return debug
+	}
+	debug.ALPN = state.NegotiatedProtocol
+	debug.Version = tls.VersionName(state.Version)
+	debug.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	if state.CurveID != 0 {
+		debug.KeyExchange = state.CurveID.String()
+	}
+	debug.Resumed = state.DidResume
+	for _, cert := range state.PeerCertificates {
+		debug.Chain = append(debug.Chain, fmt.Sprintf("%s, issued by %s, %s key, expires %s",
+			cert.Subject, cert.Issuer, cert.PublicKeyAlgorithm, cert.NotAfter.Format("2006-01-02")))
+	}
+	return debug
+}
+
+// classifyTLSError names what ended a failed handshake, and the alert when
+// the server sent one. crypto/tls returns a received alert as a
+// "remote error" OpError wrapping the alert.
+func classifyTLSError(err error) (string, string) {
+	var opErr *net.OpError
+	var recordErr tls.RecordHeaderError
+	var verifyErr *tls.CertificateVerificationError
+	var hostnameErr x509.HostnameError
+	var authorityErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	var replyErr *textproto.Error
+	var netErr net.Error
+	switch {
+	case errors.As(err, &opErr) && opErr.Op == "remote error":
+		return "alert from the server", strings.TrimPrefix(opErr.Err.Error(), "tls: ")
+	case errors.As(err, &replyErr):
+		return "STARTTLS rejected", ""
+	case errors.As(err, &recordErr):
+		return "the server did not answer with TLS", ""
+	case errors.As(err, &verifyErr), errors.As(err, &hostnameErr), errors.As(err, &authorityErr), errors.As(err, &invalidErr):
+		return "certificate verification", ""
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout", ""
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "connection closed by the server", ""
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection reset by the server", ""
+	}
+	return "other", ""
+}
+
+// tlsAlertMeanings says what an alert from the server means to a client
+// that offered no certificate, keyed by the alert's name in crypto/tls.
+var tlsAlertMeanings = map[string]string{
+	"handshake failure":              "the server shares no cipher suite, key exchange or signature algorithm with the client",
+	"protocol version not supported": "the server supports none of the TLS versions the client offered",
+	"insufficient security level":    "the server requires stronger parameters than the client offered",
+	"unrecognized name":              "the server has no certificate for the name sent as SNI",
+	"internal error":                 "the server's TLS stack failed, often over a missing or unreadable certificate or key",
+	"access denied":                  "the server refuses TLS to this client",
+	"certificate required":           "the server requires a client certificate, which senders don't present",
+	"bad certificate":                "the server rejected the client's certificate, or requires one",
+	"unknown certificate authority":  "the server requires a client certificate from a CA it trusts",
+	"expired certificate":            "the server rejected the client's certificate as expired",
+	"illegal parameter":              "the server rejected a field of the ClientHello, an interoperability bug in its TLS stack",
+	"error decoding message":         "the server could not parse the ClientHello, an interoperability bug in its TLS stack",
+	"unexpected message":             "the server and client disagree about the handshake, an interoperability bug",
+	"no application protocol":        "the server supports none of the ALPN protocols offered",
+}
+
+// describeTLSFailure turns the classification of classifyTLSError into
+// the reason given with STARTTLS-FAILED.
+func describeTLSFailure(failure string, alert string) string {
+	if alert == "" {
+		return failure
+	}
+	if meaning, ok := tlsAlertMeanings[alert]; ok {
+		return fmt.Sprintf("the server sent the TLS alert %q: %s", alert, meaning)
+	}
+	return fmt.Sprintf("the server sent the TLS alert %q", alert)
+}
+
+// printTLSDebug renders debug as an indented block.
+func printTLSDebug(debug *tlsDebug) {
+	fmt.Println("\tTLS debug:")
+	fmt.Printf("\t\tServer name:  %s\n", debug.ServerName)
+	fmt.Printf("\t\tALPN:         offered %s, negotiated %s\n", display(strings.Join(debug.ALPNOffered, ", ")), display(debug.ALPN))
+	fmt.Printf("\t\tHandshake:    %.1fms\n", debug.HandshakeMillis)
+	if debug.Version != "" {
+		fmt.Printf("\t\tNegotiated:   %s, %s, key exchange %s, resumed %t\n", debug.Version, debug.CipherSuite, display(debug.KeyExchange), debug.Resumed)
+	}
+	for i, cert := range debug.Chain {
+		fmt.Printf("\t\tChain %d:      %s\n", i, cert)
+	}
+	if debug.Failure != "" {
+		fmt.Printf("\t\tFailure:      %s\n", debug.Failure)
+	}
+	if debug.Alert != "" {
+		fmt.Printf("\t\tAlert:        %s\n", debug.Alert)
+	}
+	if debug.Error != "" {
+		fmt.Printf("\t\tError:        %s\n", debug.Error)
+	}
+}
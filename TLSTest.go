@@ -0,0 +1,671 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Certificates expiring within this many days get a warning unless
+// -warn-cert-expiry-days says otherwise.
+const certExpiryWarnDays = 30
+
+// tlsVersions maps the -min-tls values to their versions. A floor below
+// 1.2 would only weaken the default warning, so there is none.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// CertInfo describes the leaf certificate presented by a server.
+type CertInfo struct {
+	Subject       string    `json:"subject"`
+	Issuer        string    `json:"issuer"`
+	DNSNames      []string  `json:"dns_names"`
+	NotBefore     time.Time `json:"not_before"`
+	NotAfter      time.Time `json:"not_after"`
+	Fingerprint   string    `json:"sha256_fingerprint"`
+	ChainError    string    `json:"chain_error,omitempty"`
+	HostnameError string    `json:"hostname_error,omitempty"`
+	MatchedName   string    `json:"matched_name,omitempty"`
+	Wildcard      bool      `json:"wildcard_match"`
+	OutOfOrder    []string  `json:"chain_out_of_order,omitempty"`
+	Extraneous    []string  `json:"chain_extraneous,omitempty"`
+	RootIncluded  string    `json:"chain_root_included,omitempty"`
+}
+
+// expired reports whether the certificate is past its NotAfter date.
+func (c *CertInfo) expired() bool {
+	return time.Now().After(c.NotAfter)
+}
+
+// daysLeft returns the number of whole days until the certificate expires.
+func (c *CertInfo) daysLeft() int {
+	return int(time.Until(c.NotAfter).Hours() / 24)
+}
+
+// antiPipelining matches the rejections of servers that drop clients
+// issuing commands too quickly after the greeting, like Exim's
+// "synchronization error" and Postfix postscreen's pregreet test.
+var antiPipelining = regexp.MustCompile(`(?i)synchroni[sz]ation|pipelining|pregreet|too (fast|soon|quickly)`)
+
+// tlsTest probes host:port, see smtpProbe. A session that fails before TLS
+// is repeated once with a pause before STARTTLS when -pre-tls-delay is set,
+// and the result says whether the pause helped. The first attempt is always
+// made without it, the way most senders connect.
+func tlsTest(host string, port string, opts *options) MXResult {
+	debug := probeDebug{smtp: opts.smtpDebug, tls: opts.tlsDebug}
+	result := smtpProbe(host, port, 0, debug)
+	if !result.Connected || result.StartTLS {
+		return result
+	}
+	rejected := antiPipelining.MatchString(result.Error)
+	if opts.preTLSDelay <= 0 {
+		result.TooFast = rejected
+		return result
+	}
+
+	retry := smtpProbe(host, port, opts.preTLSDelay, debug)
+	retry.TooFast = rejected || antiPipelining.MatchString(retry.Error)
+	retry.Delayed = fmt.Sprintf("did not help, first attempt: %s", result.Error)
+	if retry.StartTLS {
+		retry.Delayed = fmt.Sprintf("helped, first attempt: %s", result.Error)
+	}
+	return retry
+}
+
+// greetingTimeout bounds the wait for the 220 greeting once the TCP
+// connection is up, see -timeout-greeting. Senders wait up to five minutes
+// (RFC 5321 §4.5.3.2.1), but a listener that stays silent this long is a
+// tarpit or broken, and waiting that long per MX makes the run unusable.
+// Each later reply and handshake step gets as long, see deadlineConn, and
+// so does the policy host to answer, see policyFetchTimeout.
+var greetingTimeout = time.Minute
+
+// smtpProbe connects to port on host, trying its addresses like a sender
+// does, issues STARTTLS after waiting delay and inspects the certificate the
+// server presents. The handshake itself does not verify the certificate, so
+// the details are available even when it is invalid; chain and hostname are
+// verified separately afterwards.
+// debug selects the extra detail recorded for -smtp-debug and -tls-debug.
+func smtpProbe(host string, port string, delay time.Duration, debug probeDebug) (result MXResult) {
+	result = MXResult{Host: host, Port: port}
+
+	// Allow old versions so they can be reported rather than failing the
+	// handshake outright.
+	config := &tls.Config{ServerName: host, InsecureSkipVerify: true, MinVersion: tls.VersionTLS10}
+
+	conn, addresses, err := dialInOrder(context.Background(), "tcp", host, port)
+	result.Candidates = addresses
+	if err != nil {
+		result.DialError = dialFailure(err)
+		if ipv6Only(addresses) && !localIPv6().Connected {
+			// Whatever the error, it says nothing about the MX.
+			result.DialError = dialNoIPv6
+		}
+		result.Error = err.Error()
+		return result
+	}
+	result.Address = remoteIP(conn)
+	conn = &deadlineConn{Conn: conn, timeout: greetingTimeout}
+	if debug.smtp {
+		transcript := &transcriptConn{Conn: conn}
+		conn = transcript
+		defer func() { result.Transcript = transcript.transcript() }()
+	}
+	recorder := &recordingConn{Conn: conn}
+	connected := time.Now()
+	conn.SetReadDeadline(connected.Add(greetingTimeout))
+	c, err := smtp.NewClient(recorder, host)
+	if err != nil {
+		conn.Close()
+		if isNetTimeout(err) {
+			result.GreetWait = millis(time.Since(connected))
+			err = fmt.Errorf("connected to %s but no SMTP greeting within %s", result.Address, greetingTimeout)
+		}
+		result.Error = err.Error()
+		return result
+	}
+	defer c.Close()
+	result.Connected = true
+
+	// EHLO before STARTTLS so the greeting is recorded in plain text.
+	err = c.Hello("localhost")
+	if isNetTimeout(err) {
+		err = fmt.Errorf("no reply to EHLO within %s", greetingTimeout)
+	}
+	result.Banner, result.BannerName = replyName(recorder.String(), "220")
+	_, result.EHLOName = replyName(recorder.String(), "250")
+	result.Session = recorder.String()
+	recorder.stop()
+	if err == nil {
+		time.Sleep(delay)
+		start := time.Now()
+		err = c.StartTLS(config)
+		result.Handshake = time.Since(start)
+		if err != nil {
+			result.TLSFailure, result.TLSAlert = classifyTLSError(err)
+		}
+		if debug.tls {
+			var state *tls.ConnectionState
+			if s, ok := c.TLSConnectionState(); ok {
+				state = &s
+			}
+			result.TLSDebug = newTLSDebug(host, config.NextProtos, state, result.Handshake, err)
+		}
+	}
+	if err != nil {
+		if transcript, ok := conn.(*transcriptConn); ok && transcript.encrypted {
+			transcript.note("TLS handshake failed: %v", err)
+		}
+		result.Error = err.Error()
+		return result
+	}
+	result.StartTLS = true
+
+	state, _ := c.TLSConnectionState()
+	result.TLSVersion = tls.VersionName(state.Version)
+	result.TLSState = &state
+	result.Cert = inspectCert(host, state.PeerCertificates)
+	result.TLSOK = result.Cert != nil && !result.Cert.expired() &&
+		result.Cert.ChainError == "" && result.Cert.HostnameError == ""
+	c.Quit()
+
+	return result
+}
+
+// deadlineConn gives each exchange with the server its own deadline: every
+// write, an SMTP command or a flight of the TLS handshake, moves the
+// deadline to timeout from then. A server that stops answering after EHLO,
+// after STARTTLS or halfway through the handshake fails that step instead
+// of hanging the probe, however long the session is.
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *deadlineConn) Write(p []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(p)
+}
+
+// isNetTimeout reports whether err is a connection running out of time.
+func isNetTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// probeDebug selects the debugging detail smtpProbe records.
+type probeDebug struct {
+	smtp bool
+	tls  bool
+}
+
+// recordingConn keeps a copy of what the server sends until stop is
+// called, which happens before STARTTLS so only plain text is recorded.
+type recordingConn struct {
+	net.Conn
+	buf     bytes.Buffer
+	stopped bool
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if !c.stopped && c.buf.Len() < 4096 {
+		c.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+func (c *recordingConn) stop() { c.stopped = true }
+
+func (c *recordingConn) String() string { return c.buf.String() }
+
+// replyName finds the first reply line with the given code in the recorded
+// session and returns the line and the host name the server put after the
+// code, like "mx.example.com" in "220 mx.example.com ESMTP".
+func replyName(session string, code string) (string, string) {
+	for _, line := range strings.Split(session, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if len(line) < 4 || line[:3] != code || (line[3] != ' ' && line[3] != '-') {
+			continue
+		}
+		fields := strings.Fields(line[4:])
+		if len(fields) == 0 {
+			return line, ""
+		}
+		return line, fields[0]
+	}
+	return "", ""
+}
+
+// checkSMTPNames compares the names the MX announced in its banner and EHLO
+// greeting with its DNS name and its certificate. A mismatch often explains
+// a certificate problem or shows which backend behind a load balancer
+// answered, so it is only informational.
+func checkSMTPNames(result *Result, mx MXResult) {
+	host := normalizeDomain(mx.Host)
+	for _, announced := range []struct{ where, name string }{{"banner", mx.BannerName}, {"EHLO", mx.EHLOName}} {
+		name := normalizeDomain(announced.name)
+		if name == "" {
+			continue
+		}
+		inCert := mx.Cert == nil || certCoversName(mx.Cert.DNSNames, name)
+		if name == host && inCert {
+			continue
+		}
+		certNames := "no certificate"
+		if mx.Cert != nil {
+			certNames = "certificate names " + strings.Join(mx.Cert.DNSNames, ", ")
+		}
+		result.infof("SMTP-NAME-MISMATCH", mx.Host, "%s announces %s, DNS name is %s, %s", announced.where, announced.name, host, certNames)
+	}
+}
+
+// certCoversName reports whether a certificate valid for names covers host.
+// A wildcard covers exactly one label.
+func certCoversName(names []string, host string) bool {
+	return matchedCertName(names, host) != ""
+}
+
+// matchedCertName returns the name of names that covers host, preferring
+// an exact match over a wildcard, or "". Following RFC 6125 §6.4.3 a
+// wildcard is only recognized as the whole leftmost label and stands for
+// exactly one label, so *.example.com covers a.example.com but neither
+// example.com nor a.b.example.com.
+func matchedCertName(names []string, host string) string {
+	host = normalizeDomain(host)
+	for _, name := range names {
+		if normalizeDomain(name) == host {
+			return name
+		}
+	}
+	for _, name := range names {
+		if wildcard := normalizeDomain(name); strings.HasPrefix(wildcard, "*.") {
+			if i := strings.Index(host, "."); i > 0 && host[i:] == wildcard[1:] {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// deepWildcard returns the wildcard of names that would cover host if a
+// wildcard could stand for several labels, for explaining a mismatch.
+func deepWildcard(names []string, host string) string {
+	host = normalizeDomain(host)
+	for _, name := range names {
+		if wildcard := normalizeDomain(name); strings.HasPrefix(wildcard, "*.") &&
+			strings.HasSuffix(host, wildcard[1:]) && strings.Count(host, ".") > strings.Count(wildcard, ".") {
+			return name
+		}
+	}
+	return ""
+}
+
+// probeResumption makes two STARTTLS connections to address, the one the
+// probe of host used, sharing a session cache and reports whether the
+// second handshake resumed the session of the first. Go only resumes with
+// session tickets, so servers that support session IDs alone show up as not
+// resumed.
+func probeResumption(address string, host string, port string) (string, error) {
+	config := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS10,
+		ClientSessionCache: tls.NewLRUClientSessionCache(1),
+	}
+	if _, err := startTLSState(address, host, port, config); err != nil {
+		return "", fmt.Errorf("first handshake failed: %v", err)
+	}
+	state, err := startTLSState(address, host, port, config)
+	if err != nil {
+		return "", fmt.Errorf("resumed handshake failed: %v", err)
+	}
+	switch {
+	case !state.DidResume:
+		return "not resumed", nil
+	case state.Version >= tls.VersionTLS13:
+		return "resumed (TLS 1.3 PSK ticket)", nil
+	}
+	return "resumed (session ticket)", nil
+}
+
+// startTLSState runs one STARTTLS handshake with config. It ends with QUIT
+// so that a TLS 1.3 session ticket sent after the handshake is read into
+// the session cache. Like smtpProbe, each step gets the greeting timeout,
+// so a server that stops answering halfway doesn't hang the run.
+func startTLSState(address string, host string, port string, config *tls.Config) (tls.ConnectionState, error) {
+	raw, err := net.DialTimeout("tcp", net.JoinHostPort(address, port), connectTimeout)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	conn := &deadlineConn{Conn: raw, timeout: greetingTimeout}
+	conn.SetDeadline(time.Now().Add(greetingTimeout))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return tls.ConnectionState{}, err
+	}
+	defer c.Close()
+	if err := c.StartTLS(config); err != nil {
+		return tls.ConnectionState{}, err
+	}
+	state, _ := c.TLSConnectionState()
+	c.Quit()
+	return state, nil
+}
+
+// checkResumption records the outcome of probeResumption. Problems are
+// warnings at most, resumption is not required by MTA-STS.
+func checkResumption(result *Result, mx *MXResult) {
+	address := mx.Address
+	if address == "" {
+		address = mx.Host
+	}
+	resumption, err := probeResumption(address, mx.Host, mx.Port)
+	if err != nil {
+		mx.Resumption = "error: " + err.Error()
+		result.warnf("TLS-RESUMPTION-FAILED", mx.Host, "session resumption probe: %v", err)
+		return
+	}
+	mx.Resumption = resumption
+	if resumption == "not resumed" {
+		result.infof("TLS-RESUMPTION-UNSUPPORTED", mx.Host, "the second handshake did not resume the session of the first")
+	}
+}
+
+// inspectCert verifies the presented chain and the hostname of the leaf.
+// An expired leaf has its chain checked as of its expiry date so the two
+// problems are reported separately.
+func inspectCert(host string, chain []*x509.Certificate) *CertInfo {
+	if len(chain) == 0 {
+		return nil
+	}
+	leaf := chain[0]
+
+	info := &CertInfo{
+		Subject:   leaf.Subject.String(),
+		Issuer:    leaf.Issuer.String(),
+		DNSNames:  leaf.DNSNames,
+		NotBefore: leaf.NotBefore,
+		NotAfter:  leaf.NotAfter,
+	}
+	sum := sha256.Sum256(leaf.Raw)
+	info.Fingerprint = hex.EncodeToString(sum[:])
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	opts := x509.VerifyOptions{Intermediates: intermediates, Roots: rootPool}
+	if info.expired() {
+		opts.CurrentTime = leaf.NotAfter
+	}
+	if _, err := leaf.Verify(opts); err != nil {
+		info.ChainError = err.Error()
+	}
+
+	if err := leaf.VerifyHostname(host); err != nil {
+		info.HostnameError = err.Error()
+		if wildcard := deepWildcard(leaf.DNSNames, host); wildcard != "" {
+			info.HostnameError += fmt.Sprintf("; the wildcard %s stands for a single label, so it does not cover %s", wildcard, host)
+		}
+	} else {
+		info.MatchedName = matchedCertName(leaf.DNSNames, host)
+		info.Wildcard = strings.HasPrefix(info.MatchedName, "*.")
+	}
+	inspectChainOrder(info, chain)
+	return info
+}
+
+// checkTLSVersion compares the negotiated version against the -min-tls
+// floor. Without the flag anything below the RFC minimum of TLS 1.2 is a
+// warning.
+func checkTLSVersion(result *Result, subject string, state *tls.ConnectionState, opts *options) {
+	floor, severity := uint16(tls.VersionTLS12), SeverityWarning
+	if opts.minTLS != 0 {
+		floor, severity = opts.minTLS, SeverityError
+	}
+	if state.Version < floor {
+		result.add(severity, "TLS-VERSION-LOW", subject, "negotiated %s, below the minimum of %s",
+			tls.VersionName(state.Version), tls.VersionName(floor))
+	}
+}
+
+// addMXFindings records the problems found by tlsTest.
+func addMXFindings(result *Result, mx MXResult, opts *options) {
+	subject := mx.Host
+	switch {
+	case mx.GreetWait > 0:
+		result.errorf("SMTP-NO-GREETING", subject, "%s accepted the TCP connection but sent no SMTP greeting in %.1fs of waiting; it is tarpitting or the listener is broken",
+			net.JoinHostPort(mx.Host, mx.Port), mx.GreetWait/1000)
+		return
+	case mx.DialError == dialRefused:
+		result.errorf("SMTP-CONNECTION-REFUSED", subject, "%s refused the connection, nothing listens on the port: %s", net.JoinHostPort(mx.Host, mx.Port), mx.Error)
+		return
+	case mx.DialError == dialTimeout:
+		result.errorf("SMTP-CONNECT-TIMEOUT", subject, "connecting to %s timed out; a firewall drops the packets, possibly one blocking outbound port 25 from here: %s",
+			net.JoinHostPort(mx.Host, mx.Port), mx.Error)
+		return
+	case mx.DialError == dialNoIPv6:
+		result.errorf("SMTP-IPV6-UNTESTABLE", subject, "MX is IPv6-only (%s) but this host has no IPv6 connectivity (%s), so it cannot be validated from here: %s",
+			strings.Join(mx.Candidates, ", "), localIPv6().Detail, mx.Error)
+		return
+	case mx.DialError == dialUnreachable:
+		result.errorf("SMTP-UNREACHABLE", subject, "%s is unreachable, there is no route to it: %s", net.JoinHostPort(mx.Host, mx.Port), mx.Error)
+		return
+	case !mx.Connected:
+		result.errorf("SMTP-CONNECT-FAILED", subject, "could not connect to %s: %s", net.JoinHostPort(mx.Host, mx.Port), mx.Error)
+		return
+	case !mx.StartTLS:
+		if mx.TLSFailure != "" && mx.TLSFailure != "other" {
+			result.errorf("STARTTLS-FAILED", subject, "STARTTLS failed, %s: %s", describeTLSFailure(mx.TLSFailure, mx.TLSAlert), mx.Error)
+		} else {
+			result.errorf("STARTTLS-FAILED", subject, "STARTTLS failed: %s", mx.Error)
+		}
+		if mx.Delayed != "" {
+			result.infof("SMTP-PRE-TLS-DELAY", subject, "retried with a pause before STARTTLS, which %s", mx.Delayed)
+		} else if mx.TooFast {
+			result.warnf("SMTP-ANTI-PIPELINING", subject, "the server rejected the session as too fast, which may be a defense against clients that don't wait; retry with -pre-tls-delay")
+		}
+		return
+	case mx.Cert == nil:
+		result.errorf("CERT-MISSING", subject, "server presented no certificate")
+		return
+	}
+
+	if mx.TLSState != nil {
+		checkTLSVersion(result, subject, mx.TLSState, opts)
+	}
+	checkSMTPNames(result, mx)
+	if mx.Delayed != "" {
+		if mx.TooFast {
+			result.warnf("SMTP-ANTI-PIPELINING", subject, "the server rejected the session as too fast and only accepted STARTTLS after a pause; senders that don't wait get the same rejection")
+		}
+		result.infof("SMTP-PRE-TLS-DELAY", subject, "retried with a pause before STARTTLS, which %s", mx.Delayed)
+	}
+
+	cert := mx.Cert
+	if len(opts.pins) > 0 && !opts.pins[cert.Fingerprint] {
+		var allowed []string
+		for pin := range opts.pins {
+			allowed = append(allowed, shorten(pin, 16))
+		}
+		sort.Strings(allowed)
+		result.errorf("CERT-PIN-MISMATCH", subject, "certificate SHA256:%s is not among the fingerprints allowed by -pin-fingerprints: %s",
+			cert.Fingerprint, strings.Join(allowed, ", "))
+	}
+	if cert.expired() {
+		result.errorf("CERT-EXPIRED", subject, "certificate expired on %s", cert.NotAfter.Format("2006-01-02"))
+	} else if days := cert.daysLeft(); opts.certExpiryFailDays > 0 && days < opts.certExpiryFailDays {
+		result.errorf("CERT-EXPIRING", subject, "certificate expires in %d days on %s, within the -fail-on-cert-expiry-days threshold of %d days",
+			days, cert.NotAfter.Format("2006-01-02"), opts.certExpiryFailDays)
+	} else if days < opts.certExpiryWarnDays {
+		result.warnf("CERT-EXPIRING", subject, "certificate expires in %d days on %s, within the warning threshold of %d days",
+			days, cert.NotAfter.Format("2006-01-02"), opts.certExpiryWarnDays)
+	}
+	if cert.ChainError != "" {
+		result.errorf("CERT-CHAIN-INVALID", subject, "certificate chain does not verify: %s", cert.ChainError)
+	}
+	addChainFindings(result, subject, cert)
+	if cert.HostnameError != "" && mx.Canonical != "" && mx.TLSState != nil &&
+		mx.TLSState.PeerCertificates[0].VerifyHostname(mx.Canonical) == nil {
+		result.errorf("CERT-HOSTNAME-MISMATCH", subject, "%s; it only covers the CNAME target %s, senders check the MX name", cert.HostnameError, mx.Canonical)
+	} else if cert.HostnameError != "" {
+		result.errorf("CERT-HOSTNAME-MISMATCH", subject, "%s", cert.HostnameError)
+	} else if cert.Wildcard {
+		result.infof("CERT-WILDCARD-MATCH", subject, "the certificate covers %s with the wildcard %s", mx.Host, cert.MatchedName)
+	}
+}
+
+// parsePins reads the -pin-fingerprints value: the name of a file with one
+// fingerprint per line, or a comma separated list. Fingerprints are SHA-256
+// of the leaf certificate in hex, with or without colons and a SHA256:
+// prefix. Lines starting with # are comments.
+func parsePins(value string) (map[string]bool, error) {
+	list := strings.Split(value, ",")
+	if data, err := ioutil.ReadFile(value); err == nil {
+		list = strings.Split(string(data), "\n")
+	}
+
+	pins := make(map[string]bool)
+	for _, pin := range list {
+		pin = strings.TrimSpace(pin)
+		if pin == "" || strings.HasPrefix(pin, "#") {
+			continue
+		}
+		if len(pin) > 7 && strings.EqualFold(pin[:7], "sha256:") {
+			pin = pin[7:]
+		}
+		pin = strings.ToLower(strings.Replace(pin, ":", "", -1))
+		if decoded, err := hex.DecodeString(pin); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("%q is not a SHA-256 fingerprint", pin)
+		}
+		pins[pin] = true
+	}
+	return pins, nil
+}
+
+// printMX renders the outcome of tlsTest for one host.
+func printMX(mx MXResult) {
+	if mx.TLSOK {
+		fmt.Println("✔ ", displayName(mx.Host), " certificate is good")
+	} else {
+		fmt.Printf("\x1b[31;1m✘\x1b[0m  %s  %s\n", displayName(mx.Host), mx.Status())
+	}
+	if len(mx.CNAMEChain) > 0 {
+		hops := make([]string, len(mx.CNAMEChain))
+		for i, name := range mx.CNAMEChain {
+			hops[i] = displayName(name)
+		}
+		fmt.Printf("\tAlias of:    %s\n", strings.Join(hops, " -> "))
+	}
+	if mx.Address != "" && len(mx.Candidates) > 1 {
+		fmt.Printf("\tAddress:     %s, of %s in order\n", mx.Address, strings.Join(mx.Candidates, ", "))
+	} else if mx.Address != "" {
+		fmt.Printf("\tAddress:     %s\n", mx.Address)
+	}
+	if !mx.TLSOK && len(mx.Transcript) > 0 {
+		fmt.Println("\tTranscript:")
+		for _, line := range mx.Transcript {
+			fmt.Printf("\t\t%s\n", line)
+		}
+	}
+	if mx.TLSDebug != nil {
+		printTLSDebug(mx.TLSDebug)
+	}
+	if mx.Cert == nil {
+		return
+	}
+
+	cert := mx.Cert
+	fmt.Printf("\tTLS version: %s\n", mx.TLSVersion)
+	if mx.Resumption != "" {
+		fmt.Printf("\tResumption:  %s\n", mx.Resumption)
+	}
+	if mx.Delayed != "" {
+		fmt.Printf("\tPre-TLS delay: %s\n", mx.Delayed)
+	}
+	fmt.Printf("\tSubject:     %s\n", cert.Subject)
+	fmt.Printf("\tIssuer:      %s\n", cert.Issuer)
+	fmt.Printf("\tSAN:         %s\n", strings.Join(cert.DNSNames, ", "))
+	fmt.Printf("\tSHA-256:     %s\n", cert.Fingerprint)
+	fmt.Printf("\tExpires:     %s (%d days)\n", cert.NotAfter.Format("2006-01-02"), cert.daysLeft())
+	if cert.ChainError != "" {
+		fmt.Printf("\tChain:       %s\n", cert.ChainError)
+	} else {
+		fmt.Printf("\tChain:       ok\n")
+	}
+}
+
+// certOnlyMain tests a single SMTP server given as host:port, skipping all
+// DNS and policy checks. The port defaults to 25.
+func certOnlyMain(target string, opts *options) int {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		// No port, which includes a bare IPv6 literal like ::1.
+		host, port = strings.Trim(target, "[]"), "25"
+	}
+
+	result := &Result{Domain: target}
+	for _, name := range allChecks {
+		if name == checkSTARTTLS {
+			mark := result.beginCheck()
+			mx := tlsTest(host, port, opts)
+			if opts.probeResumption && mx.StartTLS {
+				checkResumption(result, &mx)
+			}
+			result.MX = append(result.MX, mx)
+			addMXFindings(result, mx, opts)
+			result.endCheck(checkSTARTTLS, mark)
+		} else {
+			result.skipCheck(name, "-cert-only")
+		}
+	}
+	result.Raw = &rawArtifacts{Probes: rawProbes(result.MX, opts.includeRaw)}
+	annotate(result, opts)
+	opts.certs.observe(result)
+	saveCerts(opts.certs)
+	// There is no policy involved, so no deployment state either.
+	result.DeploymentState = ""
+
+	if opts.format == "json" {
+		writeJSON(result)
+	} else if opts.format == formatSARIF {
+		writeJSON(sarifReport([]*Result{result}))
+	} else if opts.format == formatGHA {
+		printGHA(result, func() {
+			printMX(result.MX[0])
+			if opts.verbose {
+				printChecks(result)
+			}
+		})
+	} else if isDocumentFormat(opts.format) {
+		fmt.Print(renderDocument(result, opts.format))
+	} else {
+		printMX(result.MX[0])
+		printFindings(result, opts.verbose)
+		if opts.verbose {
+			fmt.Println()
+			printChecks(result)
+		}
+		fmt.Println()
+		fmt.Println(statusLine(result))
+		fmt.Println(result.Verdict.line(target))
+	}
+
+	return result.Verdict.exitCode()
+}
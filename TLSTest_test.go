@@ -0,0 +1,523 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a self-signed certificate for names, each a host
+// name or an IP address, valid from an hour ago until notAfter.
+func testCertificate(t *testing.T, notAfter time.Time, names ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// smtpStub is a scripted SMTP server on a local listener, just enough of
+// one for smtpProbe.
+type smtpStub struct {
+	// config offers STARTTLS with this configuration; nil offers none.
+	config *tls.Config
+	// silent never sends the greeting.
+	silent bool
+	// rejectFast rejects a STARTTLS sent within this long of EHLO, like
+	// the anti-pipelining checks of Exim and postscreen.
+	rejectFast time.Duration
+	// stall stops answering at EHLO, at STARTTLS or, after accepting
+	// STARTTLS, at the handshake, keeping the connection open.
+	stall string
+}
+
+// start listens on address of network, like "tcp6" and "[::1]:0", for
+// the rest of the test and returns the host and port to probe.
+func (s *smtpStub) start(t *testing.T, network string, address string) (string, string) {
+	t.Helper()
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		t.Skipf("cannot listen on %s: %v", address, err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	return host, port
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	if s.silent {
+		io.Copy(ioutil.Discard, conn)
+		return
+	}
+	reply := func(lines ...string) { fmt.Fprint(conn, strings.Join(lines, "\r\n")+"\r\n") }
+	reply("220 stub.example ESMTP")
+	reader := bufio.NewReader(conn)
+	var ehlo time.Time
+	encrypted := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			reply("500 5.5.2 empty command")
+			continue
+		}
+		command := strings.ToUpper(fields[0])
+		if command == s.stall || command == "HELO" && s.stall == "EHLO" {
+			io.Copy(ioutil.Discard, reader)
+			return
+		}
+		switch command {
+		case "EHLO", "HELO":
+			ehlo = time.Now()
+			if s.config != nil && !encrypted {
+				reply("250-stub.example", "250 STARTTLS")
+			} else {
+				reply("250 stub.example")
+			}
+		case "STARTTLS":
+			if s.config == nil || encrypted {
+				reply("502 5.5.1 STARTTLS not offered")
+				continue
+			}
+			if time.Since(ehlo) < s.rejectFast {
+				reply("554 5.5.0 SMTP synchronization error")
+				return
+			}
+			reply("220 2.0.0 ready to start TLS")
+			if s.stall == "handshake" {
+				io.Copy(ioutil.Discard, reader)
+				return
+			}
+			tlsConn := tls.Server(conn, s.config)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn, reader, encrypted = tlsConn, bufio.NewReader(tlsConn), true
+		case "QUIT":
+			reply("221 2.0.0 bye")
+			return
+		default:
+			reply("250 2.0.0 ok")
+		}
+	}
+}
+
+func TestProbeIPLiteral(t *testing.T) {
+	tests := []struct {
+		name     string
+		network  string
+		address  string
+		startTLS bool
+	}{
+		{"IPv6 with STARTTLS", "tcp6", "[::1]:0", true},
+		{"IPv6 plain", "tcp6", "[::1]:0", false},
+		{"IPv4 with STARTTLS", "tcp4", "127.0.0.1:0", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := &smtpStub{}
+			if test.startTLS {
+				ip := "::1"
+				if test.network == "tcp4" {
+					ip = "127.0.0.1"
+				}
+				stub.config = &tls.Config{Certificates: []tls.Certificate{testCertificate(t, time.Now().Add(24*time.Hour), ip)}}
+			}
+			host, port := stub.start(t, test.network, test.address)
+
+			mx := tlsTest(host, port, &options{})
+			if !mx.Connected {
+				t.Fatalf("not connected to %s port %s: %s", host, port, mx.Error)
+			}
+			if mx.Address != host {
+				t.Errorf("Address = %q, want %q", mx.Address, host)
+			}
+			if mx.StartTLS != test.startTLS {
+				t.Errorf("StartTLS = %v, want %v (%s)", mx.StartTLS, test.startTLS, mx.Error)
+			}
+			if test.startTLS && (mx.Cert == nil || mx.Cert.HostnameError != "") {
+				t.Errorf("certificate for %s not matched: %+v", host, mx.Cert)
+			}
+		})
+	}
+}
+
+func TestConnectFailureAddress(t *testing.T) {
+	result := &Result{Domain: "example.com"}
+	addMXFindings(result, MXResult{Host: "2001:db8::25", Port: "25", Error: "connection reset"}, &options{})
+	want := []string{"could not connect to [2001:db8::25]:25: connection reset"}
+	if got := messagesOf(result, "SMTP-CONNECT-FAILED"); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("SMTP-CONNECT-FAILED = %q, want %q", got, want)
+	}
+}
+
+func TestResumption(t *testing.T) {
+	saved := greetingTimeout
+	greetingTimeout = 500 * time.Millisecond
+	t.Cleanup(func() { greetingTimeout = saved })
+
+	tests := []struct {
+		name    string
+		stub    *smtpStub
+		want    string
+		wantErr string
+	}{
+		{"tickets", &smtpStub{config: &tls.Config{}}, "resumed (TLS 1.3 PSK ticket)", ""},
+		{"TLS 1.2 tickets", &smtpStub{config: &tls.Config{MaxVersion: tls.VersionTLS12}}, "resumed (session ticket)", ""},
+		{"no tickets", &smtpStub{config: &tls.Config{SessionTicketsDisabled: true}}, "not resumed", ""},
+		{"no greeting", &smtpStub{silent: true}, "", "first handshake failed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.stub.config != nil {
+				test.stub.config.Certificates = []tls.Certificate{testCertificate(t, time.Now().Add(24*time.Hour), "mx.example.com")}
+			}
+			address, port := test.stub.start(t, "tcp4", "127.0.0.1:0")
+
+			done := make(chan struct{})
+			var got string
+			var err error
+			go func() {
+				got, err = probeResumption(address, "mx.example.com", port)
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("probeResumption did not give up on a silent server")
+			}
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil || got != test.want {
+				t.Errorf("probeResumption = %q, %v, want %q", got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestParsePins(t *testing.T) {
+	const pin = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	colons := strings.ToUpper(pin[:2])
+	for i := 2; i < len(pin); i += 2 {
+		colons += ":" + strings.ToUpper(pin[i:i+2])
+	}
+	file := filepath.Join(t.TempDir(), "pins.txt")
+	if err := ioutil.WriteFile(file, []byte("# pinned MX certificates\n"+pin+"\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"hex", pin, 1, false},
+		{"colons and prefix", "SHA256:" + colons, 1, false},
+		{"list", pin + ", " + strings.Replace(pin, "9f", "00", 1), 2, false},
+		{"file", file, 1, false},
+		{"too short", pin[:62], 0, true},
+		{"not hex", strings.Replace(pin, "9f", "zz", 1), 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pins, err := parsePins(test.value)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("parsePins(%q) = %v, want an error", test.value, pins)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pins) != test.want || !pins[pin] {
+				t.Errorf("parsePins(%q) = %v, want %d pins including %s", test.value, pins, test.want, pin)
+			}
+		})
+	}
+}
+
+func TestPinnedFingerprints(t *testing.T) {
+	const (
+		pinned = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+		other  = "60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752"
+	)
+	tests := []struct {
+		name        string
+		fingerprint string
+		pins        map[string]bool
+		want        bool
+	}{
+		{"matching", pinned, map[string]bool{pinned: true}, false},
+		{"matching one of several", pinned, map[string]bool{other: true, pinned: true}, false},
+		{"not matching", other, map[string]bool{pinned: true}, true},
+		{"no pins", other, nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := &Result{Domain: "example.com"}
+			mx := MXResult{Host: "mx.example.com", Port: "25", Connected: true, StartTLS: true,
+				Cert: &CertInfo{Fingerprint: test.fingerprint, NotAfter: time.Now().Add(90 * 24 * time.Hour)}}
+			addMXFindings(result, mx, &options{pins: test.pins})
+
+			got := messagesOf(result, "CERT-PIN-MISMATCH")
+			if (len(got) > 0) != test.want {
+				t.Fatalf("CERT-PIN-MISMATCH = %q, want reported %v", got, test.want)
+			}
+			if test.want && (!strings.Contains(got[0], "SHA256:"+test.fingerprint) || !strings.Contains(got[0], shorten(pinned, 16))) {
+				t.Errorf("message %q does not show the observed fingerprint and the allowed set", got[0])
+			}
+		})
+	}
+}
+
+func TestPreTLSDelay(t *testing.T) {
+	tests := []struct {
+		name        string
+		delay       time.Duration
+		wantTLS     bool
+		wantDelayed string
+		wantCodes   []string
+	}{
+		{"no delay", 0, false, "", []string{"STARTTLS-FAILED", "SMTP-ANTI-PIPELINING"}},
+		{"delay too short", 20 * time.Millisecond, false, "did not help", []string{"STARTTLS-FAILED", "SMTP-PRE-TLS-DELAY"}},
+		{"delay long enough", 400 * time.Millisecond, true, "helped", []string{"SMTP-ANTI-PIPELINING", "SMTP-PRE-TLS-DELAY"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := &smtpStub{
+				config:     &tls.Config{Certificates: []tls.Certificate{testCertificate(t, time.Now().Add(90*24*time.Hour), "127.0.0.1")}},
+				rejectFast: 200 * time.Millisecond,
+			}
+			host, port := stub.start(t, "tcp4", "127.0.0.1:0")
+
+			opts := &options{preTLSDelay: test.delay}
+			mx := tlsTest(host, port, opts)
+			if mx.StartTLS != test.wantTLS {
+				t.Errorf("StartTLS = %v, want %v (%s)", mx.StartTLS, test.wantTLS, mx.Error)
+			}
+			if !mx.TooFast {
+				t.Errorf("TooFast not set for %q", mx.Error)
+			}
+			if !strings.HasPrefix(mx.Delayed, test.wantDelayed) || (test.wantDelayed == "") != (mx.Delayed == "") {
+				t.Errorf("Delayed = %q, want it to start with %q", mx.Delayed, test.wantDelayed)
+			}
+
+			result := &Result{Domain: "example.com"}
+			addMXFindings(result, mx, opts)
+			for _, code := range test.wantCodes {
+				if len(findingsOf(result, code)) != 1 {
+					t.Errorf("no %s finding among %+v", code, result.Findings)
+				}
+			}
+		})
+	}
+}
+
+func TestCertExpiryThresholds(t *testing.T) {
+	tests := []struct {
+		name     string
+		daysLeft int
+		warnDays int
+		failDays int
+		severity Severity
+		// threshold is the one the message names, "" for no finding.
+		threshold string
+	}{
+		{"outside both", 40, 30, 7, SeverityInfo, ""},
+		{"warning threshold", 20, 30, 7, SeverityWarning, "within the warning threshold of 30 days"},
+		{"failure threshold", 5, 30, 7, SeverityError, "within the -fail-on-cert-expiry-days threshold of 7 days"},
+		{"failure threshold off", 5, 30, 0, SeverityWarning, "within the warning threshold of 30 days"},
+		{"failure above warning", 20, 10, 25, SeverityError, "within the -fail-on-cert-expiry-days threshold of 25 days"},
+		{"warning off", 20, 0, 7, SeverityInfo, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			notAfter := time.Now().Add(time.Duration(test.daysLeft)*24*time.Hour + 12*time.Hour)
+			mx := MXResult{Host: "mx.example.com", Port: "25", Connected: true, StartTLS: true, Cert: &CertInfo{NotAfter: notAfter}}
+			result := &Result{Domain: "example.com"}
+			addMXFindings(result, mx, &options{certExpiryWarnDays: test.warnDays, certExpiryFailDays: test.failDays})
+
+			findings := findingsOf(result, "CERT-EXPIRING")
+			if test.threshold == "" {
+				if len(findings) != 0 {
+					t.Errorf("CERT-EXPIRING = %+v, want none", findings)
+				}
+				return
+			}
+			if len(findings) != 1 {
+				t.Fatalf("CERT-EXPIRING = %+v, want one", findings)
+			}
+			if findings[0].Severity != test.severity || !strings.Contains(findings[0].Message, test.threshold) {
+				t.Errorf("CERT-EXPIRING is a %s %q, want a %s naming %q", findings[0].Severity, findings[0].Message, test.severity, test.threshold)
+			}
+			if fails := decide(result).exitCode() != 0; fails != (test.severity == SeverityError) {
+				t.Errorf("run fails: %v, want %v", fails, test.severity == SeverityError)
+			}
+		})
+	}
+}
+
+func TestUnresponsiveMX(t *testing.T) {
+	saved := greetingTimeout
+	greetingTimeout = 300 * time.Millisecond
+	t.Cleanup(func() { greetingTimeout = saved })
+
+	tests := []struct {
+		name      string
+		stall     string
+		connected bool
+		code      string
+		want      string
+	}{
+		{"no greeting", "silent", false, "SMTP-NO-GREETING", "sent no SMTP greeting in 0.3s of waiting"},
+		{"no EHLO reply", "EHLO", true, "STARTTLS-FAILED", "no reply to EHLO within 300ms"},
+		{"no STARTTLS reply", "STARTTLS", true, "STARTTLS-FAILED", "timeout"},
+		{"no handshake", "handshake", true, "STARTTLS-FAILED", "timeout"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := &smtpStub{
+				config: &tls.Config{Certificates: []tls.Certificate{testCertificate(t, time.Now().Add(24*time.Hour), "127.0.0.1")}},
+				silent: test.stall == "silent",
+				stall:  test.stall,
+			}
+			host, port := stub.start(t, "tcp4", "127.0.0.1:0")
+
+			start := time.Now()
+			mx := tlsTest(host, port, &options{})
+			if waited := time.Since(start); waited > 3*time.Second {
+				t.Errorf("gave up after %s, want about %s", waited, greetingTimeout)
+			}
+			if mx.Connected != test.connected || mx.StartTLS {
+				t.Errorf("Connected = %v, StartTLS = %v, want %v, false", mx.Connected, mx.StartTLS, test.connected)
+			}
+			if test.stall == "silent" && mx.GreetWait < 300 {
+				t.Errorf("GreetWait = %vms, want the time waited", mx.GreetWait)
+			}
+
+			result := &Result{Domain: "example.com"}
+			addMXFindings(result, mx, &options{})
+			got := messagesOf(result, test.code)
+			if len(got) != 1 || !strings.Contains(got[0], test.want) {
+				t.Errorf("%s = %q, want one containing %q", test.code, got, test.want)
+			}
+		})
+	}
+}
+
+func TestTLSAlerts(t *testing.T) {
+	cert := testCertificate(t, time.Now().Add(24*time.Hour), "127.0.0.1")
+	tests := []struct {
+		name   string
+		config *tls.Config
+		alert  string
+	}{
+		{"no certificate for the name", &tls.Config{}, "unrecognized name"},
+		{"client certificate required", &tls.Config{Certificates: []tls.Certificate{cert}, ClientAuth: tls.RequireAnyClientCert}, "certificate required"},
+		{"no shared cipher suite", &tls.Config{Certificates: []tls.Certificate{cert}, MaxVersion: tls.VersionTLS12,
+			CipherSuites: []uint16{tls.TLS_RSA_WITH_AES_128_CBC_SHA}}, "handshake failure"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := &smtpStub{config: test.config}
+			host, port := stub.start(t, "tcp4", "127.0.0.1:0")
+
+			mx := tlsTest(host, port, &options{})
+			if mx.StartTLS || mx.TLSFailure != "alert from the server" || mx.TLSAlert != test.alert {
+				t.Fatalf("StartTLS %v, failure %q, alert %q, want the alert %q (%s)", mx.StartTLS, mx.TLSFailure, mx.TLSAlert, test.alert, mx.Error)
+			}
+			result := &Result{Domain: "example.com"}
+			addMXFindings(result, mx, &options{})
+			want := "STARTTLS failed, the server sent the TLS alert \"" + test.alert + "\": " + tlsAlertMeanings[test.alert]
+			if got := messagesOf(result, "STARTTLS-FAILED"); len(got) != 1 || !strings.HasPrefix(got[0], want) {
+				t.Errorf("STARTTLS-FAILED = %q, want it to start with %q", got, want)
+			}
+		})
+	}
+}
+
+func TestMatchedCertName(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+		host  string
+		want  string
+		deep  string
+	}{
+		{"exact", []string{"mx.example.com"}, "mx.example.com", "mx.example.com", ""},
+		{"case and trailing dot", []string{"MX.Example.com"}, "mx.example.com.", "MX.Example.com", ""},
+		{"one label wildcard", []string{"*.example.com"}, "mx.example.com", "*.example.com", ""},
+		{"exact preferred over wildcard", []string{"*.example.com", "mx.example.com"}, "mx.example.com", "mx.example.com", ""},
+		{"wildcard does not cover the apex", []string{"*.example.com"}, "example.com", "", ""},
+		{"wildcard covers one label only", []string{"*.example.com"}, "a.b.example.com", "", "*.example.com"},
+		{"partial label wildcard", []string{"mx*.example.com"}, "mx1.example.com", "", ""},
+		{"wildcard not leftmost", []string{"mx.*.example.com"}, "mx.a.example.com", "", ""},
+		{"other domain", []string{"*.example.net", "example.com"}, "mx.example.com", "", ""},
+		{"no names", nil, "mx.example.com", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := matchedCertName(test.names, test.host); got != test.want {
+				t.Errorf("matchedCertName(%q, %q) = %q, want %q", test.names, test.host, got, test.want)
+			}
+			if got := certCoversName(test.names, test.host); got != (test.want != "") {
+				t.Errorf("certCoversName(%q, %q) = %v", test.names, test.host, got)
+			}
+			if got := deepWildcard(test.names, test.host); got != test.deep {
+				t.Errorf("deepWildcard(%q, %q) = %q, want %q", test.names, test.host, got, test.deep)
+			}
+		})
+	}
+}
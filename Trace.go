@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// acquisitionTrace is the -trace-acquisition output: the steps a compliant
+// sending MTA takes to discover, fetch and apply the policy of a domain, in
+// the order of RFC 8461, built from the checks of a normal run.
+type acquisitionTrace struct {
+	Domain   string      `json:"domain"`
+	Steps    []traceStep `json:"steps"`
+	Decision string      `json:"decision"`
+}
+
+type traceStep struct {
+	Step      string `json:"step"`
+	Reference string `json:"reference"`
+	Input     string `json:"input"`
+	Output    string `json:"output"`
+	Decision  string `json:"decision"`
+}
+
+// checkErrors lists the codes of the unsuppressed errors a check produced.
+func checkErrors(result *Result, name string) []string {
+	var codes []string
+	for _, check := range result.Checks {
+		if check.Name != name || check.Status == "skipped" {
+			continue
+		}
+		for _, f := range result.Findings[check.first:check.last] {
+			if f.Severity == SeverityError && !f.Suppressed && !contains(codes, f.Code) {
+				codes = append(codes, f.Code)
+			}
+		}
+	}
+	return codes
+}
+
+// traceAcquisition narrates result as a sender would experience it.
+// previous stands in for the sender's policy cache: the last run recorded
+// in the run cache, or nil for a sender that has never seen the domain.
+func traceAcquisition(result *Result, previous *runSummary) *acquisitionTrace {
+	trace := &acquisitionTrace{Domain: result.Domain}
+	step := func(name string, reference string, input string, output string, decision string) {
+		trace.Steps = append(trace.Steps, traceStep{Step: name, Reference: reference, Input: input, Output: output, Decision: decision})
+	}
+	cached := previous != nil && previous.STSID != "" && previous.Policy != ""
+
+	// 1. The cache.
+	if cached {
+		step("policy cache", "RFC 8461 §5.1", "the cached policy of "+result.Domain,
+			fmt.Sprintf("a policy with id %s, cached on %s", previous.STSID, previous.Checked.Format("2006-01-02 15:04 MST")),
+			"look up the TXT record to learn whether the policy changed")
+	} else {
+		step("policy cache", "RFC 8461 §5.1", "the cached policy of "+result.Domain, "none",
+			"look up the TXT record to discover whether the domain has a policy")
+	}
+
+	// 2. The TXT record.
+	stsName := "_mta-sts." + result.Domain
+	id := recordID(result.STSRecord)
+	switch {
+	case result.STSLookupError != "" && cached:
+		step("TXT lookup", "RFC 8461 §3.1", "TXT "+stsName, "lookup failed: "+result.STSLookupError,
+			"a temporary failure, not a missing record; keep applying the cached policy")
+		trace.Decision = "deliver according to the cached policy, the TXT record can't be looked up"
+		return trace
+	case result.STSLookupError != "":
+		step("TXT lookup", "RFC 8461 §3.1", "TXT "+stsName, "lookup failed: "+result.STSLookupError,
+			"a temporary failure, not a missing record; without a cached policy there is none to apply")
+		return opportunistic(trace, result, "the STS record can't be looked up")
+	case result.STSRecord == "" && !cached:
+		step("TXT lookup", "RFC 8461 §3.1", "TXT "+stsName, "no STS record",
+			"the domain has no MTA-STS policy")
+		return opportunistic(trace, result, "no MTA-STS")
+	case result.STSRecord == "":
+		step("TXT lookup", "RFC 8461 §3.1", "TXT "+stsName, "no STS record",
+			"keep applying the cached policy until it expires; a removed record does not revoke it")
+	default:
+		output := result.STSRecord
+		if len(result.STSRecords) > 1 {
+			output = strings.Join(quoteAll(result.STSRecords), ", ")
+		}
+		// Several records or an invalid one mean no policy is available.
+		errs := checkErrors(result, checkSTSTXT)
+		if len(errs) == 0 {
+			step("TXT lookup", "RFC 8461 §3.1", "TXT "+stsName, output, "compare the id with the cached policy")
+			break
+		}
+		output += " (" + strings.Join(errs, ", ") + ")"
+		if cached {
+			step("TXT lookup", "RFC 8461 §3.1", "TXT "+stsName, output,
+				"an invalid record counts as none; keep applying the cached policy until it expires")
+			trace.Decision = "deliver according to the cached policy, the STS record is invalid"
+			return trace
+		}
+		step("TXT lookup", "RFC 8461 §3.1", "TXT "+stsName, output,
+			"an invalid record counts as none; deliver as though the domain has not implemented MTA-STS")
+		return opportunistic(trace, result, "an invalid STS record")
+	}
+
+	// 3. The id.
+	switch {
+	case !cached:
+		step("id comparison", "RFC 8461 §3.1", "id "+display(id), "no cached policy to compare with", "fetch the policy")
+	case id == previous.STSID:
+		step("id comparison", "RFC 8461 §3.1", "id "+id+", cached id "+previous.STSID, "unchanged",
+			"the cached policy is current; this trace fetches it anyway to show what a fresh sender gets")
+	default:
+		step("id comparison", "RFC 8461 §3.1", "id "+display(id)+", cached id "+previous.STSID, "changed", "fetch the new policy")
+	}
+
+	// 4. The fetch.
+	url := "https://" + result.PolicyHost + "/.well-known/mta-sts.txt"
+	if result.Policy == "" {
+		output := "failed: " + display(strings.Join(checkErrors(result, checkPolicy), ", "))
+		if cached {
+			step("policy fetch", "RFC 8461 §3.3", "GET "+url, output, "keep applying the cached policy")
+			trace.Decision = "deliver according to the cached policy, the current one can't be fetched"
+			return trace
+		}
+		step("policy fetch", "RFC 8461 §3.3", "GET "+url, output,
+			"without a cached policy, deliver as though the domain has not implemented MTA-STS")
+		return opportunistic(trace, result, "no usable policy")
+	}
+	output := fmt.Sprintf("%d bytes in %dms", len(result.Policy), result.PolicyFetchMillis)
+	if result.PolicyAddress != "" {
+		output += " from " + result.PolicyAddress
+	}
+	if result.PolicyTLSVersion != "" {
+		output += " over " + result.PolicyTLSVersion
+	}
+	if result.PolicyCert != nil {
+		output += ", certificate valid for " + result.PolicyHost
+	}
+	step("policy fetch", "RFC 8461 §3.3", "GET "+url+", certificate verified against the trusted roots", output, "parse the policy")
+
+	// 5. The policy.
+	input := fmt.Sprintf("mode %s, max_age %s, mx %s", display(result.Mode), display(result.MaxAge), display(strings.Join(result.PolicyMX, ", ")))
+	if errs := checkErrors(result, checkSyntax); len(errs) > 0 {
+		step("policy validation", "RFC 8461 §3.2", input, "invalid: "+strings.Join(errs, ", "),
+			"an invalid policy counts as none; deliver as though the domain has not implemented MTA-STS")
+		return opportunistic(trace, result, "an invalid policy")
+	}
+	step("policy validation", "RFC 8461 §3.2", input, "valid",
+		fmt.Sprintf("cache the policy for %s and apply it to the MX hosts", formatMaxAge(result.MaxAge)))
+
+	// 6. The MX hosts.
+	trace.Decision = applyPolicy(trace, result)
+	return trace
+}
+
+// opportunistic finishes a trace for a sender without a policy to apply:
+// any MX will do, with or without TLS.
+func opportunistic(trace *acquisitionTrace, result *Result, why string) *acquisitionTrace {
+	for _, mx := range result.MX {
+		if mx.Connected {
+			trace.Decision = fmt.Sprintf("%s: deliver to %s, using STARTTLS if offered but without verifying the certificate", why, mx.Host)
+			return trace
+		}
+	}
+	trace.Decision = fmt.Sprintf("%s, and no MX host accepted a connection: defer and retry", why)
+	return trace
+}
+
+// applyPolicy adds a step per MX host in order of preference and returns
+// the delivery decision. Under enforce only a host matching the policy
+// with a valid certificate may be used; under testing failures are only
+// reported.
+func applyPolicy(trace *acquisitionTrace, result *Result) string {
+	patterns := make(map[string]string)
+	if result.MXCoverage != nil {
+		for _, hc := range result.MXCoverage.Hosts {
+			patterns[hc.Host] = hc.Pattern
+		}
+	}
+	enforce := result.Mode == "enforce"
+	chosen := ""
+	for _, mx := range result.MX {
+		pattern := patterns[mx.Host]
+		var problems []string
+		if pattern == "" {
+			problems = append(problems, "matches no mx pattern")
+		}
+		switch {
+		case !mx.Connected:
+		case !mx.StartTLS:
+			problems = append(problems, "no STARTTLS")
+		case !mx.TLSOK:
+			problems = append(problems, "certificate not valid")
+		}
+		input := "MX " + mx.Host
+		if pattern != "" {
+			input += ", matched by " + pattern
+		}
+		output := mx.Status()
+		if len(problems) > 0 {
+			output += "; " + strings.Join(problems, ", ")
+		}
+
+		var decision string
+		switch {
+		case chosen != "":
+			decision = "not needed, " + chosen + " was chosen"
+		case !mx.Connected:
+			decision = "unreachable, try the next MX"
+		case len(problems) == 0:
+			chosen, decision = mx.Host, "a valid candidate, deliver here"
+		case enforce:
+			decision = "not a valid candidate under enforce, try the next MX"
+		case result.Mode == "testing" || result.Mode == "report":
+			chosen, decision = mx.Host, "fails the policy, but under testing deliver anyway and report the failure with TLSRPT"
+		default:
+			chosen, decision = mx.Host, "mode none, deliver without checking the policy"
+		}
+		trace.Steps = append(trace.Steps, traceStep{Step: "MX delivery", Reference: "RFC 8461 §4, §5", Input: input, Output: output, Decision: decision})
+	}
+
+	switch {
+	case chosen != "":
+		return fmt.Sprintf("deliver to %s under mode %s", chosen, result.Mode)
+	case enforce:
+		return "defer: no MX host satisfies the enforced policy, the mail is queued and retried, and the failure is reported with TLSRPT"
+	}
+	return "defer: no MX host accepted a connection"
+}
+
+// printTrace renders the trace as numbered steps.
+func printTrace(trace *acquisitionTrace) {
+	fmt.Printf("MTA-STS acquisition trace for %s, as a compliant sender performs it:\n\n", displayName(trace.Domain))
+	for i, s := range trace.Steps {
+		fmt.Printf("%d. %s (%s)\n", i+1, s.Step, s.Reference)
+		fmt.Printf("\tInput:    %s\n", s.Input)
+		fmt.Printf("\tOutput:   %s\n", s.Output)
+		fmt.Printf("\tDecision: %s\n", s.Decision)
+	}
+	fmt.Printf("\nDELIVERY DECISION: %s\n", trace.Decision)
+}
+
+// traceMain implements -trace-acquisition. The run cache of
+// -check-id-change, when given, plays the sender's policy cache; the trace
+// itself records nothing. It exits like a normal run of the domain.
+func traceMain(domain string, opts *options) int {
+	result := validate(domain, opts)
+	annotate(result, opts)
+	var previous *runSummary
+	if opts.cache != nil {
+		previous = opts.cache.previous(result.Domain)
+	}
+	trace := traceAcquisition(result, previous)
+	if opts.format == "json" {
+		writeJSON(trace)
+	} else {
+		printTrace(trace)
+	}
+	return result.Verdict.exitCode()
+}
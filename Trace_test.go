@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTraceStopsAtInvalidSTSRecord(t *testing.T) {
+	cache := &runSummary{STSID: "20240101", Policy: canonicalPolicy(policyOf("version: STSv1", "mode: enforce", "mx: mx.example.com", "max_age: 86400"))}
+	tests := []struct {
+		name     string
+		txt      []string
+		err      error
+		output   string
+		decision string
+	}{
+		{"multiple records", []string{"v=STSv1; id=20240101", "v=STSv1; id=20240202"},
+			nil, `"v=STSv1; id=20240101", "v=STSv1; id=20240202" (STS-TXT-MULTIPLE)`, "an invalid STS record"},
+		{"no id", []string{"v=STSv1;"}, nil, "v=STSv1; (STS-TXT-INVALID)", "an invalid STS record"},
+		{"lookup failed", nil, errServFail, "lookup failed: ", "the STS record can't be looked up"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolver := &fakeResolver{txt: map[string][]string{"_mta-sts.example.com": test.txt}}
+			if test.err != nil {
+				resolver.errs = map[string]error{"_mta-sts.example.com": test.err}
+			}
+			useResolver(t, resolver)
+			result := stsPhase("example.com", &options{spec: specRFC8461})
+			result.PolicyHost = "mta-sts.example.com"
+
+			trace := traceAcquisition(result, nil)
+			last := trace.Steps[len(trace.Steps)-1]
+			if last.Step != "TXT lookup" || !strings.HasPrefix(last.Output, test.output) {
+				t.Errorf("last step %q with output %q, want the trace to stop at the TXT lookup with %q", last.Step, last.Output, test.output)
+			}
+			if !strings.HasPrefix(trace.Decision, test.decision+", and no MX host accepted a connection") {
+				t.Errorf("decision %q, want opportunistic delivery for %s", trace.Decision, test.decision)
+			}
+
+			trace = traceAcquisition(result, cache)
+			last = trace.Steps[len(trace.Steps)-1]
+			if last.Step != "TXT lookup" || !strings.Contains(last.Decision, "keep applying the cached policy") ||
+				!strings.HasPrefix(trace.Decision, "deliver according to the cached policy") {
+				t.Errorf("with a cached policy the trace ends at %q deciding %q, then %q, want the cached policy kept", last.Step, last.Decision, trace.Decision)
+			}
+		})
+	}
+}
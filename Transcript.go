@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// maxTranscript bounds the size of an SMTP transcript, so a server that
+// floods the connection can't exhaust memory or the report.
+const maxTranscript = 16 << 10
+
+// transcriptConn records the SMTP dialogue in both directions for
+// -smtp-debug, one line per command or reply prefixed with C: or S:. Once
+// the server accepts STARTTLS the rest is TLS, which is only counted; the
+// encrypted session is not recorded.
+type transcriptConn struct {
+	net.Conn
+	lines     []string
+	size      int
+	truncated bool
+	pending   string
+	starttls  bool
+	encrypted bool
+	tlsBytes  int
+}
+
+func (c *transcriptConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if c.encrypted {
+		// Handshake failures are noted by the caller, which sees the TLS
+		// error rather than the raw read error.
+		c.tlsBytes += n
+		return n, err
+	}
+	c.pending += string(p[:n])
+	for {
+		i := strings.Index(c.pending, "\n")
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(c.pending[:i], "\r")
+		c.pending = c.pending[i+1:]
+		c.add("S: " + line)
+		// A final 2xx reply to STARTTLS starts the handshake.
+		if c.starttls && len(line) > 3 && line[3] == ' ' && line[0] == '2' {
+			c.encrypted = true
+			c.tlsBytes += len(c.pending)
+			c.pending = ""
+			break
+		}
+	}
+	if err != nil {
+		c.note("read: %v", err)
+	}
+	return n, err
+}
+
+func (c *transcriptConn) Write(p []byte) (int, error) {
+	if c.encrypted {
+		c.tlsBytes += len(p)
+		return c.Conn.Write(p)
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\r\n"), "\n") {
+		line = strings.TrimRight(line, "\r")
+		c.add("C: " + line)
+		if strings.EqualFold(line, "STARTTLS") {
+			c.starttls = true
+		}
+	}
+	n, err := c.Conn.Write(p)
+	if err != nil {
+		c.note("write: %v", err)
+	}
+	return n, err
+}
+
+// note records something that is not part of the dialogue, like an error.
+func (c *transcriptConn) note(format string, args ...interface{}) {
+	c.add("-- " + fmt.Sprintf(format, args...))
+}
+
+func (c *transcriptConn) add(line string) {
+	if c.truncated {
+		return
+	}
+	if c.size+len(line) > maxTranscript {
+		c.truncated = true
+		c.lines = append(c.lines, fmt.Sprintf("-- transcript truncated at %d bytes", maxTranscript))
+		return
+	}
+	c.size += len(line)
+	c.lines = append(c.lines, line)
+}
+
+// transcript returns the recorded lines, ending with what is known about
+// the TLS part of the session.
+func (c *transcriptConn) transcript() []string {
+	if c.pending != "" {
+		c.add("S: " + c.pending + " (incomplete line)")
+		c.pending = ""
+	}
+	if c.encrypted {
+		c.note("%d bytes of TLS not recorded", c.tlsBytes)
+	}
+	return c.lines
+}
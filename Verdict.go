@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Verdict is the overall outcome of a run. It is the single place the
+// pass/fail decision is made; the exit code, the VERDICT line, the JSON
+// verdict field and Result.Status are all derived from it.
+type Verdict struct {
+	Status  string   `json:"status"`
+	Reasons []string `json:"reasons,omitempty"`
+	Summary string   `json:"summary"`
+}
+
+const (
+	verdictPass = "PASS"
+	verdictFail = "FAIL"
+)
+
+// decide derives the verdict of a single domain from its findings.
+func decide(result *Result) *Verdict {
+	verdict := &Verdict{Status: verdictPass}
+
+	for _, f := range result.Findings {
+		if f.Severity == SeverityError && !f.Suppressed {
+			reason := f.Code
+			if f.Subject != "" {
+				reason += " " + f.Subject
+			}
+			verdict.Reasons = append(verdict.Reasons, reason)
+		}
+	}
+
+	if len(verdict.Reasons) > 0 {
+		verdict.Status = verdictFail
+		verdict.Summary = fmt.Sprintf("%s: %s", plural(len(verdict.Reasons), "error"), strings.Join(verdict.Reasons, ", "))
+		return verdict
+	}
+
+	var summary []string
+	if result.Mode != "" {
+		summary = append(summary, result.Mode)
+	}
+	summary = append(summary, fmt.Sprintf("%d MX", len(result.MX)))
+	if result.MaxAge != "" {
+		summary = append(summary, "max_age "+formatMaxAge(result.MaxAge))
+	}
+	verdict.Summary = strings.Join(summary, ", ")
+	return verdict
+}
+
+// Overall statuses of a run. Unlike the verdict, which only fails on
+// errors, they tell a clean pass from one with warnings.
+const (
+	statusPass = "pass"
+	statusWarn = "warn"
+	statusFail = "fail"
+)
+
+// overallStatus is fail for a failed verdict, warn when unsuppressed
+// warnings remain and pass otherwise. It only refines the verdict, so it
+// must run after decide.
+func overallStatus(result *Result) string {
+	if result.Verdict.Status == verdictFail {
+		return statusFail
+	}
+	for _, f := range result.Findings {
+		if f.Severity == SeverityWarning && !f.Suppressed {
+			return statusWarn
+		}
+	}
+	return statusPass
+}
+
+// batchStatus is the worst status of results.
+func batchStatus(results []*Result) string {
+	status := statusPass
+	for _, result := range results {
+		switch result.Status {
+		case statusFail:
+			return statusFail
+		case statusWarn:
+			status = statusWarn
+		}
+	}
+	return status
+}
+
+// statusLine is the plain sentence printed above the VERDICT line, like
+// "PASS: gmail.com has a valid, enforcing MTA-STS policy covering all MX".
+func statusLine(result *Result) string {
+	name := displayName(result.Domain)
+	if result.Status == statusFail {
+		return fmt.Sprintf("FAIL: %s has %s", name, plural(len(result.Verdict.Reasons), "blocking error"))
+	}
+
+	mode := modeAdjective(result.Mode)
+	if mode != "" {
+		mode = " " + mode
+	}
+	var line string
+	switch {
+	case result.PolicyFile != "":
+		line = fmt.Sprintf("%s is a valid%s MTA-STS policy", name, mode)
+	case result.Policy != "":
+		line = fmt.Sprintf("%s has a valid,%s MTA-STS policy covering all MX", name, mode)
+	default:
+		line = fmt.Sprintf("%s presents a valid certificate over STARTTLS", name)
+	}
+	if result.Status == statusWarn {
+		warnings := 0
+		for _, f := range result.Findings {
+			if f.Severity == SeverityWarning && !f.Suppressed {
+				warnings++
+			}
+		}
+		return fmt.Sprintf("WARN: %s, with %s", line, plural(warnings, "warning"))
+	}
+	return "PASS: " + line
+}
+
+// modeAdjective describes a policy mode for statusLine.
+func modeAdjective(mode string) string {
+	switch mode {
+	case "enforce":
+		return "enforcing"
+	case "testing":
+		return "testing-mode"
+	case "none":
+		return "mode none"
+	}
+	return ""
+}
+
+// decideBatch derives the verdict of a multi-domain run from the verdicts of
+// its domains.
+func decideBatch(results []*Result) *Verdict {
+	verdict := &Verdict{Status: verdictPass}
+	for _, result := range results {
+		if result.Verdict.Status == verdictFail {
+			verdict.Reasons = append(verdict.Reasons, result.Domain)
+		}
+	}
+	if len(verdict.Reasons) > 0 {
+		verdict.Status = verdictFail
+		verdict.Summary = fmt.Sprintf("%d of %d domains failed: %s", len(verdict.Reasons), len(results), strings.Join(verdict.Reasons, ", "))
+	} else {
+		verdict.Summary = fmt.Sprintf("all %d domains passed", len(results))
+	}
+	return verdict
+}
+
+// Exit codes of a multi-domain run.
+const (
+	exitPass       = 0
+	exitValidation = 1
+	exitTransport  = 2
+)
+
+// transportCodes are the errors that mean the tool could not check
+// something, as opposed to having found it broken.
+var transportCodes = map[string]bool{
+	"DNS-TIMEOUT":           true,
+	"MX-LOOKUP-FAILED":      true,
+	"STS-TXT-LOOKUP-FAILED": true,
+	"TLSRPT-LOOKUP-FAILED":  true,
+	"SMTP-CONNECT-FAILED":   true,
+	"SMTP-CONNECT-TIMEOUT":  true,
+	"SMTP-UNREACHABLE":      true,
+	"SMTP-IPV6-UNTESTABLE":  true,
+}
+
+// failureKind classifies a failed result as "validation" or, when every
+// error it has is a transport failure, "transport". It is "" for a pass.
+func failureKind(result *Result) string {
+	if result.Verdict.Status != verdictFail {
+		return ""
+	}
+	for _, f := range result.Findings {
+		if f.Severity == SeverityError && !f.Suppressed && !transportCodes[f.Code] {
+			return "validation"
+		}
+	}
+	return "transport"
+}
+
+// batchExitCode is the exit code of a multi-domain run together with the
+// reason for it, printed in the batch summary: 0 when every domain passed,
+// 1 when any domain failed validation, 2 when domains only failed because
+// they could not be checked. -max-failures tolerates that many failing
+// domains and -exit-zero always exits 0.
+func batchExitCode(results []*Result, opts *options) (int, string) {
+	var validation, transport int
+	for _, result := range results {
+		switch failureKind(result) {
+		case "validation":
+			validation++
+		case "transport":
+			transport++
+		}
+	}
+	failed := validation + transport
+
+	var code int
+	var reason string
+	switch {
+	case failed == 0:
+		code, reason = exitPass, fmt.Sprintf("all %d domains passed", len(results))
+	case validation > 0:
+		code, reason = exitValidation, fmt.Sprintf("%s failed validation", plural(validation, "domain"))
+		if transport > 0 {
+			reason += fmt.Sprintf(", %s could not be checked", plural(transport, "more domain"))
+		}
+	default:
+		code, reason = exitTransport, fmt.Sprintf("%s could not be checked (transport failures), none failed validation", plural(transport, "domain"))
+	}
+
+	switch {
+	case code != exitPass && failed <= opts.maxFailures:
+		code, reason = exitPass, reason+fmt.Sprintf("; tolerated by -max-failures %d", opts.maxFailures)
+	case code != exitPass && opts.exitZero:
+		code, reason = exitPass, reason+"; -exit-zero"
+	}
+	return code, reason
+}
+
+// exitCode is the process exit code for the verdict.
+func (v *Verdict) exitCode() int {
+	if v.Status == verdictFail {
+		return 1
+	}
+	return 0
+}
+
+// line is the grep-able VERDICT line printed last in text output.
+func (v *Verdict) line(name string) string {
+	return fmt.Sprintf("VERDICT %s: %s (%s)", name, v.Status, v.Summary)
+}
+
+// formatMaxAge shortens a max_age in seconds to days or hours when exact.
+func formatMaxAge(maxAge string) string {
+	seconds, err := strconv.Atoi(maxAge)
+	if err != nil || seconds <= 0 {
+		return maxAge
+	}
+	switch {
+	case seconds%86400 == 0:
+		return fmt.Sprintf("%dd", seconds/86400)
+	case seconds%3600 == 0:
+		return fmt.Sprintf("%dh", seconds/3600)
+	}
+	return fmt.Sprintf("%ds", seconds)
+}
+
+// plural formats a count with its noun, like "1 error" or "2 errors".
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// Deployment states a domain moves through while rolling out MTA-STS.
+const (
+	stateNotDeployed = "NOT_DEPLOYED"
+	stateDNSOnly     = "DNS_ONLY"
+	statePolicyOnly  = "POLICY_ONLY"
+	stateTesting     = "TESTING"
+	stateEnforced    = "ENFORCED"
+	stateBroken      = "BROKEN"
+)
+
+// deploymentState names the rollout state of the domain from the collected
+// results. A policy in mode none withdraws MTA-STS and counts as not
+// deployed.
+func deploymentState(result *Result, verdict *Verdict) string {
+	hasTXT := result.STSRecord != ""
+	hasPolicy := result.Policy != ""
+
+	switch {
+	case !hasTXT && !hasPolicy:
+		return stateNotDeployed
+	case hasTXT && !hasPolicy:
+		return stateDNSOnly
+	case !hasTXT && hasPolicy:
+		return statePolicyOnly
+	}
+
+	switch result.Mode {
+	case "none":
+		return stateNotDeployed
+	case "report", "testing":
+		return stateTesting
+	case "enforce":
+		if verdict.Status == verdictPass {
+			return stateEnforced
+		}
+	}
+	return stateBroken
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestOverallStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		findings []Finding
+		verdict  string
+		status   string
+	}{
+		{"clean", nil, verdictPass, statusPass},
+		{"info only", []Finding{{Code: "CERT-WILDCARD-MATCH", Severity: SeverityInfo}}, verdictPass, statusPass},
+		{"warning", []Finding{{Code: "TLSRPT-MISSING", Severity: SeverityWarning}}, verdictPass, statusWarn},
+		{"suppressed warning", []Finding{{Code: "TLSRPT-MISSING", Severity: SeverityWarning, Suppressed: true}}, verdictPass, statusPass},
+		{"error", []Finding{{Code: "STS-TXT-MISSING", Severity: SeverityError}, {Code: "TLSRPT-MISSING", Severity: SeverityWarning}}, verdictFail, statusFail},
+		{"suppressed error", []Finding{{Code: "STS-TXT-MISSING", Severity: SeverityError, Suppressed: true}}, verdictPass, statusPass},
+	}
+	for _, test := range tests {
+		result := &Result{Domain: "example.com", Findings: test.findings}
+		result.Verdict = decide(result)
+		result.Status = overallStatus(result)
+		if result.Verdict.Status != test.verdict || result.Status != test.status {
+			t.Errorf("%s: verdict %s, status %s, want %s, %s", test.name, result.Verdict.Status, result.Status, test.verdict, test.status)
+		}
+		if (result.Status == statusFail) != (result.Verdict.exitCode() != 0) {
+			t.Errorf("%s: status %s disagrees with exit code %d", test.name, result.Status, result.Verdict.exitCode())
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// zoneResolver answers MX, TXT and CNAME lookups from a BIND zone file instead of
+// DNS, see -zonefile. Names without records of the type asked for are not
+// found, like a negative answer from a nameserver for the zone.
+type zoneResolver struct {
+	path  string
+	mx    map[string][]*net.MX
+	txt   map[string][]string
+	cname map[string]string
+}
+
+func (z *zoneResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	if records := z.mx[normalizeDomain(name)]; len(records) > 0 {
+		return records, nil
+	}
+	return nil, z.notFound(name)
+}
+
+func (z *zoneResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if records := z.txt[normalizeDomain(name)]; len(records) > 0 {
+		return records, nil
+	}
+	return nil, z.notFound(name)
+}
+
+// LookupCNAME returns the target of the CNAME record of name in the zone.
+// Like the resolver it returns name itself when it is not an alias.
+func (z *zoneResolver) LookupCNAME(ctx context.Context, name string) (string, error) {
+	name = normalizeDomain(name)
+	if target := z.cname[name]; target != "" {
+		return target + ".", nil
+	}
+	return name + ".", nil
+}
+
+func (z *zoneResolver) notFound(name string) error {
+	return &net.DNSError{Err: "no such host", Name: name, Server: "zone file " + z.path, IsNotFound: true}
+}
+
+// covers reports whether the zone has any MX or TXT record at or below
+// domain.
+func (z *zoneResolver) covers(domain string) bool {
+	domain = normalizeDomain(domain)
+	under := func(name string) bool {
+		return name == domain || strings.HasSuffix(name, "."+domain)
+	}
+	for name := range z.mx {
+		if under(name) {
+			return true
+		}
+	}
+	for name := range z.txt {
+		if under(name) {
+			return true
+		}
+	}
+	for name := range z.cname {
+		if under(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// zoneToken is a word of a zone file entry. Quoted tells a quoted string
+// apart from a bare word, so a quoted "@" or ";" is taken literally.
+type zoneToken struct {
+	text   string
+	quoted bool
+}
+
+// zoneEntry is one logical line of a zone file, after comments are removed
+// and parenthesized continuation lines are joined. Continued means the line
+// started with whitespace, so it has no owner name of its own.
+type zoneEntry struct {
+	line      int
+	continued bool
+	tokens    []zoneToken
+}
+
+var zoneTTLPattern = regexp.MustCompile(`^[0-9]+([smhdwSMHDW][0-9]*)*$`)
+
+var zoneClasses = map[string]bool{"IN": true, "CH": true, "HS": true, "CS": true}
+
+// loadZoneFile parses the MX, TXT and CNAME records of a BIND format zone file.
+// Relative names are completed with $ORIGIN, or with origin until the file
+// sets one. Other record types are skipped; $INCLUDE and $GENERATE are not
+// supported.
+func loadZoneFile(path string, origin string) (*zoneResolver, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := splitZone(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s:%v", path, err)
+	}
+
+	z := &zoneResolver{path: path, mx: make(map[string][]*net.MX), txt: make(map[string][]string), cname: make(map[string]string)}
+	origin = normalizeDomain(origin)
+	owner := ""
+	for _, entry := range entries {
+		if err := z.addEntry(entry, &origin, &owner); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, entry.line, err)
+		}
+	}
+	for _, records := range z.mx {
+		sort.SliceStable(records, func(i, j int) bool { return records[i].Pref < records[j].Pref })
+	}
+	return z, nil
+}
+
+func (z *zoneResolver) addEntry(entry zoneEntry, origin *string, owner *string) error {
+	tokens := entry.tokens
+	if !entry.continued && !tokens[0].quoted && strings.HasPrefix(tokens[0].text, "$") {
+		switch strings.ToUpper(tokens[0].text) {
+		case "$ORIGIN":
+			if len(tokens) < 2 {
+				return fmt.Errorf("$ORIGIN without a name")
+			}
+			*origin = zoneName(tokens[1].text, *origin)
+		case "$TTL":
+		default:
+			return fmt.Errorf("%s is not supported", tokens[0].text)
+		}
+		return nil
+	}
+
+	if !entry.continued {
+		*owner = zoneName(tokens[0].text, *origin)
+		tokens = tokens[1:]
+	} else if *owner == "" {
+		return fmt.Errorf("record without an owner name")
+	}
+	for len(tokens) > 0 && !tokens[0].quoted &&
+		(zoneTTLPattern.MatchString(tokens[0].text) || zoneClasses[strings.ToUpper(tokens[0].text)]) {
+		tokens = tokens[1:]
+	}
+	if len(tokens) == 0 {
+		return fmt.Errorf("record without a type")
+	}
+
+	recordType, rdata := strings.ToUpper(tokens[0].text), tokens[1:]
+	switch recordType {
+	case "MX":
+		if len(rdata) != 2 {
+			return fmt.Errorf("MX record needs a preference and an exchange, got %d fields", len(rdata))
+		}
+		pref, err := strconv.ParseUint(rdata[0].text, 10, 16)
+		if err != nil {
+			return fmt.Errorf("MX preference %q is not a number from 0 to 65535", rdata[0].text)
+		}
+		host := "."
+		if rdata[1].text != "." {
+			host = zoneName(rdata[1].text, *origin) + "."
+		}
+		z.mx[*owner] = append(z.mx[*owner], &net.MX{Host: host, Pref: uint16(pref)})
+	case "CNAME":
+		if len(rdata) != 1 {
+			return fmt.Errorf("CNAME record needs one target, got %d fields", len(rdata))
+		}
+		if z.cname[*owner] != "" {
+			return fmt.Errorf("%s has more than one CNAME record", *owner)
+		}
+		z.cname[*owner] = zoneName(rdata[0].text, *origin)
+	case "TXT":
+		if len(rdata) == 0 {
+			return fmt.Errorf("TXT record without any strings")
+		}
+		// Like the resolver, join the character strings of one record.
+		var value strings.Builder
+		for _, token := range rdata {
+			value.WriteString(token.text)
+		}
+		z.txt[*owner] = append(z.txt[*owner], value.String())
+	}
+	return nil
+}
+
+// zoneName completes a name from a zone file: @ is the origin, a name
+// ending in a dot is absolute and anything else is relative to the origin.
+func zoneName(name string, origin string) string {
+	switch {
+	case name == "@":
+		return origin
+	case strings.HasSuffix(name, "."):
+		return normalizeDomain(name)
+	case origin == "":
+		return normalizeDomain(name)
+	}
+	return normalizeDomain(name + "." + origin)
+}
+
+// splitZone breaks zone file text into entries. A semicolon outside quotes
+// starts a comment and line breaks inside parentheses don't end an entry.
+// Backslash escapes, \X and \DDD, are resolved.
+func splitZone(text string) ([]zoneEntry, error) {
+	var entries []zoneEntry
+	var current zoneEntry
+	var word strings.Builder
+	inWord, quoted, inQuotes, depth, line := false, false, false, 0, 1
+
+	endWord := func() {
+		if inWord {
+			current.tokens = append(current.tokens, zoneToken{text: word.String(), quoted: quoted})
+		}
+		word.Reset()
+		inWord, quoted = false, false
+	}
+	endEntry := func() {
+		endWord()
+		if len(current.tokens) > 0 {
+			entries = append(entries, current)
+		}
+		current = zoneEntry{line: line + 1}
+	}
+
+	current.line = 1
+	atLineStart := true
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if atLineStart && depth == 0 {
+			current.continued = c == ' ' || c == '\t'
+			atLineStart = false
+		}
+		switch {
+		case c == '\\':
+			inWord = true
+			if i+3 < len(text) && isDigit(text[i+1]) && isDigit(text[i+2]) && isDigit(text[i+3]) {
+				n, _ := strconv.Atoi(text[i+1 : i+4])
+				if n > 255 {
+					return nil, fmt.Errorf("%d: escape \\%s is out of range", line, text[i+1:i+4])
+				}
+				word.WriteByte(byte(n))
+				i += 3
+			} else if i+1 < len(text) {
+				i++
+				if text[i] == '\n' {
+					line++
+				}
+				word.WriteByte(text[i])
+			}
+		case inQuotes && c == '"':
+			inQuotes = false
+		case inQuotes:
+			if c == '\n' {
+				return nil, fmt.Errorf("%d: unterminated quoted string", line)
+			}
+			word.WriteByte(c)
+		case c == '"':
+			endWord()
+			inWord, quoted, inQuotes = true, true, true
+		case c == ';':
+			for i+1 < len(text) && text[i+1] != '\n' {
+				i++
+			}
+		case c == '(':
+			endWord()
+			depth++
+		case c == ')':
+			endWord()
+			if depth == 0 {
+				return nil, fmt.Errorf("%d: unbalanced )", line)
+			}
+			depth--
+		case c == '\n':
+			if depth == 0 {
+				endEntry()
+				atLineStart = true
+			} else {
+				endWord()
+			}
+			line++
+		case c == ' ' || c == '\t' || c == '\r':
+			endWord()
+		default:
+			inWord = true
+			word.WriteByte(c)
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("%d: unterminated quoted string", line)
+	}
+	if depth > 0 {
+		return nil, fmt.Errorf("%d: unbalanced (", line)
+	}
+	endEntry()
+	return entries, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
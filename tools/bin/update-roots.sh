@@ -0,0 +1,20 @@
+#!/bin/bash
+
+# Regenerates roots.pem, the root bundle embedded as a fallback for systems
+# without one, from the CA bundle of this machine. Run it on an up to date
+# system with ca-certificates installed.
+
+BIN_DIR=`dirname "$0"`
+cd $BIN_DIR/../..
+
+BUNDLE=${1:-/etc/ssl/certs/ca-certificates.crt}
+
+{
+	echo "# Mozilla's trusted root certificates as shipped by ca-certificates, the fallback"
+	echo "# for systems without a CA bundle. Regenerate with tools/bin/update-roots.sh."
+	echo "# Generated: `date -u +%Y-%m-%d`"
+	echo
+	grep -v '^#' $BUNDLE
+} > roots.pem
+
+echo "Wrote `grep -c 'BEGIN CERTIFICATE' roots.pem` certificates to roots.pem"